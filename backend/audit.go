@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Audit Trail ---
+
+// Audit actions recorded by the stores.
+const (
+	AuditCustomerCreate  = "customer.create"
+	AuditCustomerUpdate  = "customer.update"
+	AuditCustomerDelete  = "customer.delete"
+	AuditCustomerRestore = "customer.restore"
+	AuditCustomerPurge   = "customer.purge"
+	AuditProductCreate   = "product.create"
+	AuditProductUpdate   = "product.update"
+	AuditProductDelete   = "product.delete"
+	AuditCatalogCreate   = "catalog.create"
+	AuditCatalogUpdate   = "catalog.update"
+	AuditCatalogDelete   = "catalog.delete"
+	AuditOrderCreate     = "order.create"
+	AuditOrderStatus     = "order.status"
+	AuditOrderDelete     = "order.delete"
+	AuditDataFlush       = "data.flush"
+)
+
+// AuditEntry is one row of the append-only audit_log table. Before and After
+// hold the JSON snapshot of the Customer, Product, CatalogItem or Order
+// around the mutation; they are null for creations and deletions
+// respectively.
+type AuditEntry struct {
+	AuditID    int64           `json:"audit_id"`
+	OccurredAt time.Time       `json:"occurred_at"`
+	Actor      string          `json:"actor"`
+	ActorRole  string          `json:"actor_role,omitempty"`
+	Action     string          `json:"action"`
+	EntityType string          `json:"entity_type"`
+	EntityID   string          `json:"entity_id"`
+	CustomerID *int64          `json:"customer_id,omitempty"`
+	Before     json.RawMessage `json:"before,omitempty"`
+	After      json.RawMessage `json:"after,omitempty"`
+	RequestID  string          `json:"request_id,omitempty"`
+}
+
+// AuditStore reads the audit trail. Writes happen inside the mutating
+// CustomerStore/ProductStore methods so they share the mutation's transaction.
+type AuditStore interface {
+	// CustomerHistory returns up to limit entries for the customer with
+	// audit_id greater than afterID, oldest first.
+	CustomerHistory(ctx context.Context, customerID int64, afterID int64, limit int) ([]AuditEntry, error)
+}
+
+// newAuditEntry stamps an entry with the caller and request ID carried by ctx.
+// Work done outside a request (e.g. background jobs) is attributed to "system".
+func newAuditEntry(ctx context.Context, action, entityType, entityID string, customerID *int64, before, after interface{}) AuditEntry {
+	entry := AuditEntry{
+		Actor:      "system",
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		CustomerID: customerID,
+		RequestID:  requestIDFrom(ctx),
+	}
+	if p := principalFrom(ctx); p != nil {
+		entry.Actor, entry.ActorRole = p.Subject, string(p.Role)
+	}
+
+	entry.Before = auditSnapshot(before)
+	entry.After = auditSnapshot(after)
+	return entry
+}
+
+// auditSnapshot encodes v, which is always a Customer, Product, CatalogItem,
+// Order or plain map and therefore cannot fail to marshal.
+func auditSnapshot(v interface{}) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	data, _ := json.Marshal(v)
+	return data
+}
+
+func customerAuditEntry(ctx context.Context, action string, before, after *Customer) AuditEntry {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	id := subject.CustomerID
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	return newAuditEntry(ctx, action, "customer", strconv.FormatInt(id, 10), &id, b, a)
+}
+
+func productAuditEntry(ctx context.Context, action string, before, after *Product) AuditEntry {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	customerID := subject.CustomerID
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	return newAuditEntry(ctx, action, "product", strconv.Itoa(subject.ProductID), &customerID, b, a)
+}
+
+// getCustomerHistory handles GET /api/customers/{customer_id}/history.
+// Page forward with ?cursor=<paging.next_cursor>.
+func (s *server) getCustomerHistory(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parseCustomerID(mux.Vars(r)["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	limit := defaultPageLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "limit must be a positive integer")
+			return
+		}
+		limit = min(n, maxPageLimit)
+	}
+	var afterID int64
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		if afterID, err = strconv.ParseInt(v, 10, 64); err != nil || afterID < 0 {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "invalid cursor")
+			return
+		}
+	}
+
+	entries, err := s.store.CustomerHistory(r.Context(), customerID, afterID, limit+1)
+	if err != nil {
+		respondWithStoreError(w, "Failed to retrieve customer history", err)
+		return
+	}
+
+	paging := &PageInfo{Limit: limit, Sort: "audit_id", Order: "asc"}
+	if len(entries) > limit {
+		entries = entries[:limit]
+		paging.HasMore = true
+		paging.NextCursor = strconv.FormatInt(entries[len(entries)-1].AuditID, 10)
+	}
+	if len(entries) == 0 && afterID == 0 {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "No history found for this customer")
+		return
+	}
+
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Retrieved %d history entries for Customer ID %d", len(entries), customerID),
+		History: entries,
+		Paging:  paging,
+	})
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- Authentication and Role-Based Authorization ---
+
+// Role is a caller's permission level. Each role includes the permissions of
+// the roles below it: admin > operator > viewer.
+type Role string
+
+const (
+	RoleViewer   Role = "viewer"
+	RoleOperator Role = "operator"
+	RoleAdmin    Role = "admin"
+)
+
+var roleRank = map[Role]int{RoleViewer: 1, RoleOperator: 2, RoleAdmin: 3}
+
+func parseRole(s string) (Role, bool) {
+	role := Role(strings.ToLower(strings.TrimSpace(s)))
+	_, ok := roleRank[role]
+	return role, ok
+}
+
+// allows reports whether r has at least the permissions of required.
+func (r Role) allows(required Role) bool {
+	return roleRank[r] >= roleRank[required]
+}
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string
+	Role    Role
+	// Method records how the caller authenticated ("api_key", "jwt", ...).
+	Method string
+}
+
+var (
+	// errNoCredentials means the request carried nothing this authenticator
+	// understands, so the next one in the chain should be tried.
+	errNoCredentials      = errors.New("authentication required")
+	errInvalidCredentials = errors.New("invalid credentials")
+	errTokenExpired       = errors.New("token expired")
+)
+
+// Authenticator resolves the caller of a request. Implementations return
+// errNoCredentials when the request has no credentials of their kind.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// authChain tries each authenticator in order until one recognises the
+// request's credentials.
+type authChain []Authenticator
+
+func (c authChain) Authenticate(r *http.Request) (*Principal, error) {
+	for _, a := range c {
+		p, err := a.Authenticate(r)
+		if errors.Is(err, errNoCredentials) {
+			continue
+		}
+		return p, err
+	}
+	return nil, errNoCredentials
+}
+
+// openAccess treats every request as an anonymous admin. It is only used
+// when AUTH_DISABLED=true, for local development.
+type openAccess struct{}
+
+func (openAccess) Authenticate(r *http.Request) (*Principal, error) {
+	return &Principal{Subject: "anonymous", Role: RoleAdmin, Method: "none"}, nil
+}
+
+// --- Static API Keys ---
+
+// apiKeyAuthenticator accepts `X-API-Key: <key>` or `Authorization: ApiKey <key>`.
+// Keys are held as SHA-256 digests so lookups do not leak timing about the
+// raw key material.
+type apiKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]Principal
+}
+
+// newAPIKeyAuthenticator parses a comma-separated list of subject:role:key
+// entries, e.g. "frontend:operator:k1,ops:admin:k2".
+func newAPIKeyAuthenticator(spec string) (*apiKeyAuthenticator, error) {
+	a := &apiKeyAuthenticator{keys: make(map[[sha256.Size]byte]Principal)}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q (want subject:role:key)", parts[0])
+		}
+		role, ok := parseRole(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("invalid role %q for API key subject %q", parts[1], parts[0])
+		}
+		a.keys[sha256.Sum256([]byte(parts[2]))] = Principal{Subject: parts[0], Role: role, Method: "api_key"}
+	}
+	return a, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	if key == "" {
+		if scheme, value, ok := strings.Cut(r.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "ApiKey") {
+			key = strings.TrimSpace(value)
+		}
+	}
+	if key == "" {
+		return nil, errNoCredentials
+	}
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return nil, errInvalidCredentials
+	}
+	return &p, nil
+}
+
+// --- HMAC-Signed JWTs ---
+
+// jwtAuthenticator verifies `Authorization: Bearer <jwt>` tokens signed with
+// HS256/HS384/HS512. Several secrets may be configured so keys can be rotated:
+// a token is accepted if any of them verifies it.
+type jwtAuthenticator struct {
+	secrets  [][]byte
+	issuer   string
+	audience string
+	leeway   time.Duration
+	now      func() time.Time
+}
+
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Role      string          `json:"role"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"`
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+}
+
+func newJWTAuthenticator(secrets []string, issuer, audience string) *jwtAuthenticator {
+	a := &jwtAuthenticator{issuer: issuer, audience: audience, leeway: 30 * time.Second, now: time.Now}
+	for _, s := range secrets {
+		if s = strings.TrimSpace(s); s != "" {
+			a.secrets = append(a.secrets, []byte(s))
+		}
+	}
+	return a
+}
+
+func (a *jwtAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return nil, errNoCredentials
+	}
+	claims, err := a.verify(strings.TrimSpace(token))
+	if err != nil {
+		return nil, err
+	}
+
+	role, ok := parseRole(claims.Role)
+	if !ok || claims.Subject == "" {
+		return nil, errInvalidCredentials
+	}
+	return &Principal{Subject: claims.Subject, Role: role, Method: "jwt"}, nil
+}
+
+func (a *jwtAuthenticator) verify(token string) (*jwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidCredentials
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if json.Unmarshal(headerJSON, &header) != nil {
+		return nil, errInvalidCredentials
+	}
+	var newHash func() hash.Hash
+	switch header.Alg {
+	case "HS256":
+		newHash = sha256.New
+	case "HS384":
+		newHash = sha512.New384
+	case "HS512":
+		newHash = sha512.New
+	default:
+		// Rejects "none" and asymmetric algorithms.
+		return nil, errInvalidCredentials
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	valid := false
+	for _, secret := range a.secrets {
+		mac := hmac.New(newHash, secret)
+		mac.Write(signed)
+		if hmac.Equal(mac.Sum(nil), signature) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, errInvalidCredentials
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, errInvalidCredentials
+	}
+	var claims jwtClaims
+	if json.Unmarshal(payload, &claims) != nil {
+		return nil, errInvalidCredentials
+	}
+
+	now := a.now()
+	if claims.ExpiresAt == nil || now.After(unixSeconds(*claims.ExpiresAt).Add(a.leeway)) {
+		return nil, errTokenExpired
+	}
+	if claims.NotBefore != nil && now.Add(a.leeway).Before(unixSeconds(*claims.NotBefore)) {
+		return nil, errInvalidCredentials
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return nil, errInvalidCredentials
+	}
+	if a.audience != "" && !audienceContains(claims.Audience, a.audience) {
+		return nil, errInvalidCredentials
+	}
+	return &claims, nil
+}
+
+func unixSeconds(v float64) time.Time {
+	return time.Unix(int64(v), 0)
+}
+
+// audienceContains handles both forms of the aud claim: a string or an array.
+func audienceContains(raw json.RawMessage, want string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == want
+	}
+	var many []string
+	if json.Unmarshal(raw, &many) == nil {
+		for _, aud := range many {
+			if aud == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// --- Configuration ---
+
+// newAuthenticatorFromEnv builds the authenticator chain from:
+//
+//	AUTH_API_KEYS     comma-separated subject:role:key entries
+//	AUTH_JWT_SECRETS  comma-separated HMAC secrets (first is current, rest are accepted during rotation)
+//	AUTH_JWT_ISSUER   optional required `iss`
+//	AUTH_JWT_AUDIENCE optional required `aud`
+//	AUTH_DISABLED     "true" to let every request through as admin (development only)
+func newAuthenticatorFromEnv() (Authenticator, error) {
+	if getEnv("AUTH_DISABLED", "false") == "true" {
+		log.Println("WARNING: AUTH_DISABLED=true, every request is treated as admin.")
+		return openAccess{}, nil
+	}
+
+	var chain authChain
+	if spec := getEnv("AUTH_API_KEYS", ""); spec != "" {
+		keys, err := newAPIKeyAuthenticator(spec)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, keys)
+	}
+	if secrets := getEnv("AUTH_JWT_SECRETS", ""); secrets != "" {
+		chain = append(chain, newJWTAuthenticator(strings.Split(secrets, ","),
+			getEnv("AUTH_JWT_ISSUER", ""), getEnv("AUTH_JWT_AUDIENCE", "")))
+	}
+	if len(chain) == 0 {
+		return nil, errors.New("no authentication configured: set AUTH_API_KEYS and/or AUTH_JWT_SECRETS (or AUTH_DISABLED=true for development)")
+	}
+	return chain, nil
+}
+
+// --- Middleware ---
+
+type principalKey struct{}
+
+func withPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// principalFrom returns the caller stored by require, or nil.
+func principalFrom(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// require wraps a handler so it only runs for callers holding at least role.
+func (s *server) require(role Role, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := s.auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="api", ApiKey realm="api"`)
+			respondWithError(w, http.StatusUnauthorized, ProblemUnauthorized, authErrorMessage(err))
+			return
+		}
+		if !p.Role.allows(role) {
+			respondWithError(w, http.StatusForbidden, ProblemForbidden, fmt.Sprintf("Role %q is not permitted to perform this action (requires %s)", p.Role, role))
+			return
+		}
+		// Idempotency keys are scoped to the caller, so they are checked
+		// after authentication.
+		s.idempotent(next)(w, r.WithContext(withPrincipal(r.Context(), p)))
+	}
+}
+
+func authErrorMessage(err error) string {
+	switch {
+	case errors.Is(err, errNoCredentials):
+		return "Authentication required"
+	case errors.Is(err, errTokenExpired):
+		return "Token expired"
+	default:
+		return "Invalid credentials"
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+)
+
+// --- Cache Layer ---
+
+// ErrCacheMiss is returned by Cache.Get for a key that is absent or expired.
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache is the cache-aside store in front of the database. Values are opaque
+// bytes; callers seal anything holding ID documents before caching it.
+type Cache interface {
+	Get(key string) ([]byte, error)
+	// Set stores value for ttl; 0 means no expiry.
+	Set(key string, value []byte, ttl time.Duration) error
+	// Delete removes key. Deleting an absent key is not an error.
+	Delete(key string) error
+	Flush() error
+}
+
+// --- memcached ---
+
+type memcachedCache struct {
+	client *memcache.Client
+}
+
+func newMemcachedCache(host string) *memcachedCache {
+	return &memcachedCache{client: memcache.New(host)}
+}
+
+func (c *memcachedCache) Get(key string) ([]byte, error) {
+	item, err := c.client.Get(key)
+	if errors.Is(err, memcache.ErrCacheMiss) {
+		return nil, ErrCacheMiss
+	} else if err != nil {
+		return nil, err
+	}
+	return item.Value, nil
+}
+
+func (c *memcachedCache) Set(key string, value []byte, ttl time.Duration) error {
+	return c.client.Set(&memcache.Item{Key: key, Value: value, Expiration: int32(ttl / time.Second)})
+}
+
+func (c *memcachedCache) Delete(key string) error {
+	if err := c.client.Delete(key); err != nil && !errors.Is(err, memcache.ErrCacheMiss) {
+		return err
+	}
+	return nil
+}
+
+func (c *memcachedCache) Flush() error {
+	return c.client.FlushAll()
+}
+
+// --- In-process LRU ---
+
+// lruCache keeps up to capacity entries in process memory, evicting the
+// least recently used.
+type lruCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero for no expiry
+}
+
+func newLRUCache(capacity int) *lruCache {
+	return &lruCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+func (c *lruCache) Get(key string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	entry := el.Value.(*lruEntry)
+	if !entry.expires.IsZero() && c.now().After(entry.expires) {
+		c.remove(el)
+		return nil, ErrCacheMiss
+	}
+	c.order.MoveToFront(el)
+	return entry.value, nil
+}
+
+func (c *lruCache) Set(key string, value []byte, ttl time.Duration) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry := &lruEntry{key: key, value: value}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	if el, ok := c.entries[key]; ok {
+		el.Value = entry
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+	return nil
+}
+
+func (c *lruCache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	return nil
+}
+
+func (c *lruCache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.order.Init()
+	c.entries = make(map[string]*list.Element)
+	return nil
+}
+
+// remove drops el. Callers must hold c.mu.
+func (c *lruCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*lruEntry).key)
+}
+
+// --- No-op ---
+
+// noopCache caches nothing, so every read goes to the database.
+type noopCache struct{}
+
+func (noopCache) Get(key string) ([]byte, error)                        { return nil, ErrCacheMiss }
+func (noopCache) Set(key string, value []byte, ttl time.Duration) error { return nil }
+func (noopCache) Delete(key string) error                               { return nil }
+func (noopCache) Flush() error                                          { return nil }
+
+// --- Two-tier ---
+
+// tieredCache puts a small local cache in front of a shared one. Writes and
+// deletes go to both tiers, but another instance's invalidation only reaches
+// the shared tier, so local entries live at most localTTL.
+type tieredCache struct {
+	local, remote Cache
+	localTTL      time.Duration
+}
+
+func (c *tieredCache) Get(key string) ([]byte, error) {
+	if value, err := c.local.Get(key); err == nil {
+		return value, nil
+	}
+	value, err := c.remote.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	c.local.Set(key, value, c.localTTL)
+	return value, nil
+}
+
+func (c *tieredCache) Set(key string, value []byte, ttl time.Duration) error {
+	localTTL := c.localTTL
+	if ttl > 0 && ttl < localTTL {
+		localTTL = ttl
+	}
+	c.local.Set(key, value, localTTL)
+	return c.remote.Set(key, value, ttl)
+}
+
+func (c *tieredCache) Delete(key string) error {
+	c.local.Delete(key)
+	return c.remote.Delete(key)
+}
+
+func (c *tieredCache) Flush() error {
+	c.local.Flush()
+	return c.remote.Flush()
+}
+
+// Stats reports both tiers.
+func (c *tieredCache) Stats() []CacheStats {
+	return append(cacheStats(c.local), cacheStats(c.remote)...)
+}
+
+// --- Metrics ---
+
+// CacheStats counts the outcomes of one cache (tier) since startup.
+type CacheStats struct {
+	Name    string  `json:"name"`
+	Hits    int64   `json:"hits"`
+	Misses  int64   `json:"misses"`
+	Errors  int64   `json:"errors"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// CacheReport is the body of GET /api/cache/stats. Coalesced counts lookups
+// that waited for an identical lookup already in flight instead of querying
+// the database themselves.
+type CacheReport struct {
+	Caches    []CacheStats `json:"caches"`
+	Coalesced int64        `json:"coalesced"`
+}
+
+// meteredCache counts hits, misses and errors of the cache it wraps and logs
+// the errors, which callers otherwise treat as misses.
+type meteredCache struct {
+	Cache
+	name                 string
+	hits, misses, errors atomic.Int64
+}
+
+func newMeteredCache(name string, c Cache) *meteredCache {
+	return &meteredCache{Cache: c, name: name}
+}
+
+func (c *meteredCache) Get(key string) ([]byte, error) {
+	value, err := c.Cache.Get(key)
+	switch {
+	case err == nil:
+		c.hits.Add(1)
+	case errors.Is(err, ErrCacheMiss):
+		c.misses.Add(1)
+	default:
+		c.fail("get", key, err)
+	}
+	return value, err
+}
+
+func (c *meteredCache) Set(key string, value []byte, ttl time.Duration) error {
+	err := c.Cache.Set(key, value, ttl)
+	if err != nil {
+		c.fail("set", key, err)
+	}
+	return err
+}
+
+func (c *meteredCache) Delete(key string) error {
+	err := c.Cache.Delete(key)
+	if err != nil {
+		c.fail("delete", key, err)
+	}
+	return err
+}
+
+func (c *meteredCache) Flush() error {
+	err := c.Cache.Flush()
+	if err != nil {
+		c.fail("flush", "*", err)
+	}
+	return err
+}
+
+func (c *meteredCache) fail(op, key string, err error) {
+	c.errors.Add(1)
+	log.Printf("Cache %s %s %s failed: %v", c.name, op, key, err)
+}
+
+func (c *meteredCache) Stats() []CacheStats {
+	st := CacheStats{Name: c.name, Hits: c.hits.Load(), Misses: c.misses.Load(), Errors: c.errors.Load()}
+	if lookups := st.Hits + st.Misses + st.Errors; lookups > 0 {
+		st.HitRate = float64(st.Hits) / float64(lookups)
+	}
+	return []CacheStats{st}
+}
+
+// cacheStats returns the counters of c, if it keeps any.
+func cacheStats(c Cache) []CacheStats {
+	if r, ok := c.(interface{ Stats() []CacheStats }); ok {
+		return r.Stats()
+	}
+	return nil
+}
+
+// --- Request Coalescing ---
+
+// flightGroup runs one call per key at a time; callers arriving while it
+// runs wait for and share its result instead of repeating it.
+type flightGroup struct {
+	mu        sync.Mutex
+	calls     map[string]*flightCall
+	coalesced atomic.Int64
+}
+
+type flightCall struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+func (g *flightGroup) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.mu.Unlock()
+		g.coalesced.Add(1)
+		<-call.done
+		return call.value, call.err
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err
+}
+
+// --- Configuration ---
+
+// cacheFromEnv builds the cache selected by CACHE_BACKEND:
+//
+//   - memcached (default): MEMCACHED_HOST
+//   - lru: in-process, CACHE_LRU_SIZE entries (default 10000)
+//   - tiered: an lru in front of memcached; local entries live at most
+//     CACHE_LOCAL_TTL (default 30s)
+//   - none: no caching
+//
+// It also returns CACHE_TTL (default 1h), the lifetime of cached entries.
+func cacheFromEnv() (Cache, time.Duration, error) {
+	ttl, err := time.ParseDuration(getEnv("CACHE_TTL", "1h"))
+	if err != nil || ttl <= 0 {
+		return nil, 0, fmt.Errorf("CACHE_TTL must be a positive duration such as 1h")
+	}
+	lru := func() (Cache, error) {
+		size, err := strconv.Atoi(getEnv("CACHE_LRU_SIZE", "10000"))
+		if err != nil || size < 1 {
+			return nil, fmt.Errorf("CACHE_LRU_SIZE must be a positive integer")
+		}
+		return newMeteredCache("lru", newLRUCache(size)), nil
+	}
+	memcached := func() Cache {
+		return newMeteredCache("memcached", newMemcachedCache(getEnv("MEMCACHED_HOST", "localhost:11211")))
+	}
+
+	switch backend := getEnv("CACHE_BACKEND", "memcached"); backend {
+	case "memcached":
+		return memcached(), ttl, nil
+	case "lru":
+		c, err := lru()
+		return c, ttl, err
+	case "tiered":
+		localTTL, err := time.ParseDuration(getEnv("CACHE_LOCAL_TTL", "30s"))
+		if err != nil || localTTL <= 0 {
+			return nil, 0, fmt.Errorf("CACHE_LOCAL_TTL must be a positive duration such as 30s")
+		}
+		local, err := lru()
+		if err != nil {
+			return nil, 0, err
+		}
+		return &tieredCache{local: local, remote: memcached(), localTTL: localTTL}, ttl, nil
+	case "none":
+		return noopCache{}, ttl, nil
+	default:
+		return nil, 0, fmt.Errorf("unknown CACHE_BACKEND %q (use memcached, lru, tiered or none)", backend)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock is a settable time source for lruCache.now.
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestLRU(capacity int) (*lruCache, *fakeClock) {
+	clock := &fakeClock{t: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)}
+	c := newLRUCache(capacity)
+	c.now = clock.now
+	return c, clock
+}
+
+func expectHit(t *testing.T, c Cache, key, want string) {
+	t.Helper()
+	got, err := c.Get(key)
+	if err != nil || string(got) != want {
+		t.Errorf("Get(%q) = %q, %v; want %q", key, got, err, want)
+	}
+}
+
+func expectMiss(t *testing.T, c Cache, key string) {
+	t.Helper()
+	if got, err := c.Get(key); !errors.Is(err, ErrCacheMiss) {
+		t.Errorf("Get(%q) = %q, %v; want a miss", key, got, err)
+	}
+}
+
+func TestLRUCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestLRU(2)
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+	expectHit(t, c, "a", "1") // a is now more recent than b
+	c.Set("c", []byte("3"), 0)
+
+	expectMiss(t, c, "b")
+	expectHit(t, c, "a", "1")
+	expectHit(t, c, "c", "3")
+}
+
+func TestLRUCacheOverwriteKeepsOneEntry(t *testing.T) {
+	c, _ := newTestLRU(2)
+	c.Set("a", []byte("1"), 0)
+	c.Set("a", []byte("2"), 0)
+	c.Set("b", []byte("3"), 0)
+
+	expectHit(t, c, "a", "2")
+	expectHit(t, c, "b", "3")
+	if n := c.order.Len(); n != 2 || len(c.entries) != 2 {
+		t.Errorf("%d entries in the list and %d in the map, want 2", n, len(c.entries))
+	}
+}
+
+func TestLRUCacheExpiry(t *testing.T) {
+	c, clock := newTestLRU(10)
+	c.Set("short", []byte("1"), time.Minute)
+	c.Set("forever", []byte("2"), 0)
+
+	clock.t = clock.t.Add(time.Minute)
+	expectHit(t, c, "short", "1")
+	clock.t = clock.t.Add(time.Second)
+	expectMiss(t, c, "short")
+	expectHit(t, c, "forever", "2")
+	if _, ok := c.entries["short"]; ok {
+		t.Error("expired entry was not removed")
+	}
+}
+
+func TestLRUCacheDeleteAndFlush(t *testing.T) {
+	c, _ := newTestLRU(10)
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+
+	if err := c.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("missing"); err != nil {
+		t.Errorf("Delete of an absent key: %v", err)
+	}
+	expectMiss(t, c, "a")
+	expectHit(t, c, "b", "2")
+
+	c.Flush()
+	expectMiss(t, c, "b")
+	if c.order.Len() != 0 {
+		t.Errorf("%d entries left after Flush", c.order.Len())
+	}
+}
+
+func TestTieredCacheFillsLocalFromRemote(t *testing.T) {
+	local, _ := newTestLRU(10)
+	remote, _ := newTestLRU(10)
+	c := &tieredCache{local: local, remote: remote, localTTL: 30 * time.Second}
+
+	remote.Set("k", []byte("v"), 0)
+	expectMiss(t, local, "k")
+	expectHit(t, c, "k", "v")
+	expectHit(t, local, "k", "v")
+	expectMiss(t, c, "absent")
+}
+
+func TestTieredCacheLocalTTL(t *testing.T) {
+	local, clock := newTestLRU(10)
+	remote, _ := newTestLRU(10)
+	c := &tieredCache{local: local, remote: remote, localTTL: 30 * time.Second}
+
+	c.Set("long", []byte("1"), time.Hour)
+	c.Set("short", []byte("2"), 10*time.Second)
+
+	// Another instance's invalidation only reaches the remote tier; the
+	// local copy must still go once localTTL has passed.
+	remote.Delete("long")
+	clock.t = clock.t.Add(11 * time.Second)
+	expectHit(t, local, "long", "1")
+	expectMiss(t, local, "short") // a shorter ttl wins over localTTL
+	clock.t = clock.t.Add(20 * time.Second)
+	expectMiss(t, c, "long")
+}
+
+func TestTieredCacheDeleteAndFlushReachBothTiers(t *testing.T) {
+	local, _ := newTestLRU(10)
+	remote, _ := newTestLRU(10)
+	c := &tieredCache{local: local, remote: remote, localTTL: 30 * time.Second}
+
+	c.Set("a", []byte("1"), 0)
+	c.Set("b", []byte("2"), 0)
+	c.Delete("a")
+	expectMiss(t, local, "a")
+	expectMiss(t, remote, "a")
+
+	c.Flush()
+	expectMiss(t, local, "b")
+	expectMiss(t, remote, "b")
+}
+
+func TestMeteredCacheCounts(t *testing.T) {
+	c := newMeteredCache("lru", newLRUCache(10))
+	c.Set("a", []byte("1"), 0)
+	c.Get("a")
+	c.Get("a")
+	c.Get("b")
+
+	st := c.Stats()[0]
+	if st.Hits != 2 || st.Misses != 1 || st.Errors != 0 {
+		t.Errorf("got %+v, want 2 hits and 1 miss", st)
+	}
+	if st.HitRate < 0.66 || st.HitRate > 0.67 {
+		t.Errorf("hit rate = %v, want 2/3", st.HitRate)
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Product Catalog ---
+
+var (
+	ErrCatalogItemNotFound = errors.New("catalog item not found")
+	ErrCatalogItemInactive = errors.New("catalog item is no longer sold")
+)
+
+// CatalogItem is something customers can buy. Products added by SKU copy its
+// name and list price, so later price changes do not rewrite past purchases.
+// Items still referenced by products cannot be deleted, only deactivated.
+type CatalogItem struct {
+	SKU       string    `json:"sku"`
+	Name      string    `json:"name"`
+	Category  string    `json:"category"`
+	ListPrice Money     `json:"list_price"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CatalogFilter narrows ListCatalogItems. Inactive items are left out unless
+// IncludeInactive is set.
+type CatalogFilter struct {
+	Category        string
+	IncludeInactive bool
+}
+
+// CatalogLinkReport is the outcome of LinkProductsToCatalog. Unmatched counts
+// unlinked products per product_name; Ambiguous lists the SKUs of names that
+// match more than one catalog item.
+type CatalogLinkReport struct {
+	DryRun    bool                `json:"dry_run"`
+	Linked    int                 `json:"linked"`
+	Unmatched map[string]int      `json:"unmatched,omitempty"`
+	Ambiguous map[string][]string `json:"ambiguous,omitempty"`
+}
+
+// CatalogStore covers the catalog and the migration of free-text products
+// onto it. Mutations are audited like customers and products.
+type CatalogStore interface {
+	// CreateCatalogItem fails with a *DuplicateKeyError on "sku" if the SKU
+	// is taken.
+	CreateCatalogItem(ctx context.Context, item *CatalogItem) error
+	GetCatalogItem(ctx context.Context, sku string) (CatalogItem, error)
+	ListCatalogItems(ctx context.Context, filter CatalogFilter) ([]CatalogItem, error)
+	// UpdateCatalogItem replaces every field but SKU and CreatedAt and
+	// returns the stored item.
+	UpdateCatalogItem(ctx context.Context, item CatalogItem) (CatalogItem, error)
+	// DeleteCatalogItem fails with a *ForeignKeyError while products refer
+	// to the item.
+	DeleteCatalogItem(ctx context.Context, sku string) error
+	// LinkProductsToCatalog sets the SKU of every product without one whose
+	// product_name matches the name of exactly one catalog item (ignoring
+	// case and surrounding space). Prices are left as they were paid.
+	LinkProductsToCatalog(ctx context.Context, dryRun bool) (CatalogLinkReport, error)
+}
+
+// catalogNameKey is the form in which product and catalog names are matched.
+func catalogNameKey(name string) string {
+	return strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// catalogNameIndex maps each catalogNameKey to the SKUs carrying that name.
+func catalogNameIndex(items []CatalogItem) map[string][]string {
+	index := make(map[string][]string, len(items))
+	for _, item := range items {
+		key := catalogNameKey(item.Name)
+		index[key] = append(index[key], item.SKU)
+	}
+	return index
+}
+
+// link records the outcome for one product and returns the SKU to set, or
+// "" if the product stays unlinked.
+func (r *CatalogLinkReport) link(index map[string][]string, productName string) string {
+	switch skus := index[catalogNameKey(productName)]; len(skus) {
+	case 1:
+		r.Linked++
+		return skus[0]
+	case 0:
+		if r.Unmatched == nil {
+			r.Unmatched = make(map[string]int)
+		}
+		r.Unmatched[productName]++
+	default:
+		if r.Ambiguous == nil {
+			r.Ambiguous = make(map[string][]string)
+		}
+		r.Ambiguous[productName] = skus
+	}
+	return ""
+}
+
+// applyCatalogItem snapshots the item's name and current list price into a
+// product bought by SKU.
+func applyCatalogItem(p *Product, item CatalogItem) error {
+	if !item.Active {
+		return ErrCatalogItemInactive
+	}
+	p.ProductName, p.Price = item.Name, item.ListPrice
+	return nil
+}
+
+func catalogAuditEntry(ctx context.Context, action string, before, after *CatalogItem) AuditEntry {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	return newAuditEntry(ctx, action, "catalog_item", subject.SKU, nil, b, a)
+}
+
+// --- Handlers ---
+
+// listCatalog handles GET /api/catalog?category=...&include_inactive=true.
+func (s *server) listCatalog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := CatalogFilter{Category: strings.TrimSpace(q.Get("category"))}
+	if v := q.Get("include_inactive"); v != "" {
+		if v != "true" && v != "false" {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "include_inactive must be true or false")
+			return
+		}
+		filter.IncludeInactive = v == "true"
+	}
+
+	items, err := s.store.ListCatalogItems(r.Context(), filter)
+	if err != nil {
+		respondWithStoreError(w, "Failed to retrieve the catalog", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Successfully retrieved %d catalog items", len(items)),
+		Catalog: items,
+	})
+}
+
+func (s *server) getCatalogItem(w http.ResponseWriter, r *http.Request) {
+	item, err := s.store.GetCatalogItem(r.Context(), normalizeSKU(mux.Vars(r)["sku"]))
+	if errors.Is(err, ErrCatalogItemNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Catalog item not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to retrieve catalog item", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{CatalogItem: &item})
+}
+
+func (s *server) createCatalogItem(w http.ResponseWriter, r *http.Request) {
+	// Items are sold from creation unless the body says otherwise.
+	item := CatalogItem{Active: true}
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	var verr *ValidationError
+	if errors.As(validateCatalogItem(&item), &verr) {
+		respondWithValidationError(w, verr)
+		return
+	}
+
+	if err := s.store.CreateCatalogItem(r.Context(), &item); err != nil {
+		respondWithStoreError(w, "Failed to create catalog item", err)
+		return
+	}
+	respondWithJSON(w, http.StatusCreated, SuccessResponse{
+		Message:     "Catalog item created successfully",
+		CatalogItem: &item,
+	})
+}
+
+// updateCatalogItem handles PUT /api/catalog/{sku}. The SKU in the path
+// wins; products already bought keep the price they were bought at.
+func (s *server) updateCatalogItem(w http.ResponseWriter, r *http.Request) {
+	var item CatalogItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	sku := normalizeSKU(mux.Vars(r)["sku"])
+	if item.SKU != "" && normalizeSKU(item.SKU) != sku {
+		respondWithValidationError(w, &ValidationError{Fields: []FieldError{
+			{Field: "sku", Code: CodeReadOnly, Message: "cannot be changed"},
+		}})
+		return
+	}
+	item.SKU = sku
+
+	var verr *ValidationError
+	if errors.As(validateCatalogItem(&item), &verr) {
+		respondWithValidationError(w, verr)
+		return
+	}
+
+	updated, err := s.store.UpdateCatalogItem(r.Context(), item)
+	if errors.Is(err, ErrCatalogItemNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Catalog item not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to update catalog item", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message:     "Catalog item updated successfully",
+		CatalogItem: &updated,
+	})
+}
+
+// deleteCatalogItem handles DELETE /api/catalog/{sku}. An item that has been
+// bought is answered with 409; set active to false instead.
+func (s *server) deleteCatalogItem(w http.ResponseWriter, r *http.Request) {
+	sku := normalizeSKU(mux.Vars(r)["sku"])
+	err := s.store.DeleteCatalogItem(r.Context(), sku)
+	if errors.Is(err, ErrCatalogItemNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Catalog item not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to delete catalog item", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Catalog item %s deleted successfully", sku),
+	})
+}
+
+// linkProductsToCatalog handles POST /api/catalog/link-products?dry_run=true.
+func (s *server) linkProductsToCatalog(w http.ResponseWriter, r *http.Request) {
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+	report, err := s.store.LinkProductsToCatalog(r.Context(), dryRun)
+	if err != nil {
+		respondWithStoreError(w, "Failed to link products to the catalog", err)
+		return
+	}
+	if !dryRun && report.Linked > 0 {
+		// Any customer's cached product list may have changed.
+		s.cache.Flush()
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message:     fmt.Sprintf("Linked %d products to catalog items", report.Linked),
+		CatalogLink: &report,
+	})
+}
+
+// runLinkCatalogCommand implements `customerDB link-catalog [-dry-run]`, the
+// command-line form of POST /api/catalog/link-products. It prints the report
+// as JSON.
+func runLinkCatalogCommand(args []string) {
+	fs := flag.NewFlagSet("link-catalog", flag.ExitOnError)
+	dryRun := fs.Bool("dry-run", false, "report the matches without writing")
+	fs.Parse(args)
+
+	store, _, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	ctx := withPrincipal(context.Background(), &Principal{Subject: "cli:link-catalog", Role: RoleAdmin, Method: "cli"})
+	report, err := store.LinkProductsToCatalog(ctx, *dryRun)
+	if err != nil {
+		log.Fatal("Linking products failed: ", err)
+	}
+	if !*dryRun && report.Linked > 0 {
+		if cache, _, err := cacheFromEnv(); err == nil {
+			cache.Flush()
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
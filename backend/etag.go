@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Optimistic Concurrency (ETag / If-Match) ---
+
+// customerETag derives the strong ETag of a customer from its version and
+// the response shape: the same version renders differently when masked or
+// limited by ?fields=, so each rendering gets its own tag, e.g. "3" for the
+// full customer and "3-masked" for a viewer's copy.
+func customerETag(c Customer, shape responseShape) string {
+	tag := strconv.Itoa(c.Version)
+	if shape.mask {
+		tag += "-masked"
+	}
+	if shape.fields != nil {
+		names := make([]string, 0, len(shape.fields))
+		for name := range shape.fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		h := fnv.New32a()
+		h.Write([]byte(strings.Join(names, ",")))
+		tag += fmt.Sprintf("-f%08x", h.Sum32())
+	}
+	return `"` + tag + `"`
+}
+
+// setCustomerETag adds the ETag header for a single-customer response. The
+// body depends on the caller's role, so shared caches must key on the
+// credentials too.
+func setCustomerETag(w http.ResponseWriter, shape responseShape, c Customer) {
+	w.Header().Set("ETag", customerETag(c, shape))
+	w.Header().Add("Vary", "Authorization, X-API-Key")
+}
+
+var (
+	errIfMatchMissing = errors.New("If-Match header is required; send the ETag from the latest read of this customer")
+	errIfMatchInvalid = errors.New(`If-Match must be a quoted ETag from a read of this customer, such as "3"`)
+)
+
+// parseIfMatch extracts the expected version from If-Match. A value of "*"
+// matches any current version and is reported as 0. The shape suffix of the
+// ETag is ignored: any rendering of a version may be used to update it.
+func parseIfMatch(r *http.Request) (int, error) {
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		return 0, errIfMatchMissing
+	}
+	if header == "*" {
+		return 0, nil
+	}
+	// Only a single ETag makes sense for a versioned update.
+	if strings.Contains(header, ",") || strings.HasPrefix(header, "W/") {
+		return 0, errIfMatchInvalid
+	}
+	tag, _, _ := strings.Cut(strings.Trim(header, `"`), "-")
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return 0, errIfMatchInvalid
+	}
+	return version, nil
+}
+
+// notModified reports whether If-None-Match already names etag, using the
+// weak comparison RFC 9110 prescribes for conditional GETs.
+func notModified(r *http.Request, etag string) bool {
+	header := r.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// respondWithCustomer writes a single customer, shaped for the caller, with
+// its ETag, or 304 when the client's If-None-Match shows it already has this
+// version.
+func respondWithCustomer(w http.ResponseWriter, r *http.Request, shape responseShape, customer Customer) {
+	setCustomerETag(w, shape, customer)
+	if notModified(r, customerETag(customer, shape)) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, shape.view(customer))
+}
+
+// PreconditionFailedResponse is returned with 412 so the client can retry its
+// edit against the current representation without another round trip.
+type PreconditionFailedResponse struct {
+	Problem
+	Customer *CustomerView `json:"customer"`
+}
+
+// respondWithVersionMismatch answers a stale If-Match with the customer as it
+// is now.
+func respondWithVersionMismatch(w http.ResponseWriter, shape responseShape, current Customer) {
+	setCustomerETag(w, shape, current)
+	body := PreconditionFailedResponse{
+		Problem: newProblem(http.StatusPreconditionFailed, ProblemVersionMismatch,
+			fmt.Sprintf("Customer was modified by someone else (current version %d); review the current data and retry", current.Version)),
+		Customer: shape.view(current),
+	}
+	writeProblem(w, &body.Problem, &body)
+}
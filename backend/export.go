@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// --- Data Export ---
+
+// Export formats accepted by ?format= and the -format flag.
+const (
+	ExportCSV     = "csv"
+	ExportNDJSON  = "ndjson"
+	ExportParquet = "parquet"
+)
+
+var exportContentTypes = map[string]string{
+	ExportCSV:     "text/csv; charset=utf-8",
+	ExportNDJSON:  "application/x-ndjson",
+	ExportParquet: "application/vnd.apache.parquet",
+}
+
+// exportFlushRows bounds how many rows are buffered before they are pushed
+// to the client (CSV, NDJSON) or closed into a row group (Parquet).
+const exportFlushRows = 10000
+
+// exportRecord is one NDJSON line: the customer with its products nested.
+type exportRecord struct {
+	Customer
+	Products []Product `json:"products"`
+}
+
+// exportRow is the flat layout used by CSV and Parquet: one row per product,
+// with the customer columns repeated. A customer without products gets a
+// single row whose product columns are empty.
+type exportRow struct {
+	CustomerID       int64     `parquet:"customer_id"`
+	Name             string    `parquet:"name"`
+	Age              int32     `parquet:"age"`
+	Address          string    `parquet:"address"`
+	PhoneNumber      *string   `parquet:"phone_number,optional"`
+	Email            *string   `parquet:"email,optional"`
+	PassportID       *string   `parquet:"passport_id,optional"`
+	AadharID         *string   `parquet:"aadhar_id,optional"`
+	DrivingLicenseID *string   `parquet:"driving_license_id,optional"`
+	CreatedAt        time.Time `parquet:"created_at,timestamp(millisecond)"`
+	Version          int32     `parquet:"version"`
+	ProductID        *int32    `parquet:"product_id,optional"`
+	ProductName      *string   `parquet:"product_name,optional"`
+	Quantity         *int32    `parquet:"quantity,optional"`
+	Price            *string   `parquet:"price,optional"`
+	Currency         *string   `parquet:"currency,optional"`
+}
+
+var exportCSVHeader = []string{
+	"customer_id", "name", "age", "address", "phone_number", "email", "passport_id", "aadhar_id",
+	"driving_license_id", "created_at", "version", "product_id", "product_name", "quantity", "price", "currency",
+}
+
+func flattenExport(c Customer, products []Product) []exportRow {
+	base := exportRow{
+		CustomerID:       c.CustomerID,
+		Name:             c.Name,
+		Age:              int32(c.Age),
+		Address:          c.Address,
+		PhoneNumber:      c.PhoneNumber,
+		Email:            c.Email,
+		PassportID:       c.PassportID,
+		AadharID:         c.AadharID,
+		DrivingLicenseID: c.DrivingLicenseID,
+		CreatedAt:        c.CreatedAt.UTC(),
+		Version:          int32(c.Version),
+	}
+	if len(products) == 0 {
+		return []exportRow{base}
+	}
+	rows := make([]exportRow, len(products))
+	for i, p := range products {
+		row := base
+		id, qty, name, price, currency := int32(p.ProductID), int32(p.Quantity), p.ProductName, p.Price.Amount(), p.Price.Currency
+		row.ProductID, row.Quantity, row.ProductName, row.Price, row.Currency = &id, &qty, &name, &price, &currency
+		rows[i] = row
+	}
+	return rows
+}
+
+func (row exportRow) csvRecord() []string {
+	opt := func(p *string) string {
+		if p == nil {
+			return ""
+		}
+		return *p
+	}
+	record := []string{
+		strconv.FormatInt(row.CustomerID, 10), row.Name, strconv.Itoa(int(row.Age)), row.Address,
+		opt(row.PhoneNumber), opt(row.Email), opt(row.PassportID), opt(row.AadharID), opt(row.DrivingLicenseID),
+		row.CreatedAt.Format(time.RFC3339), strconv.Itoa(int(row.Version)), "", "", "", "", "",
+	}
+	if row.ProductID != nil {
+		record[11] = strconv.Itoa(int(*row.ProductID))
+		record[12] = *row.ProductName
+		record[13] = strconv.Itoa(int(*row.Quantity))
+		record[14] = *row.Price
+		record[15] = *row.Currency
+	}
+	return record
+}
+
+// exportWriter encodes customers in one export format.
+type exportWriter interface {
+	write(c Customer, products []Product) error
+	close() error
+}
+
+// flusher is satisfied by http.ResponseWriter implementations that support
+// streaming; other destinations (files) simply don't implement it.
+type flusher interface{ Flush() }
+
+func flushOutput(w io.Writer) {
+	if f, ok := w.(flusher); ok {
+		f.Flush()
+	}
+}
+
+type csvExportWriter struct {
+	out  io.Writer
+	w    *csv.Writer
+	rows int
+}
+
+func (e *csvExportWriter) write(c Customer, products []Product) error {
+	for _, row := range flattenExport(c, products) {
+		if err := e.w.Write(row.csvRecord()); err != nil {
+			return err
+		}
+		if e.rows++; e.rows%exportFlushRows == 0 {
+			e.w.Flush()
+			flushOutput(e.out)
+		}
+	}
+	return nil
+}
+
+func (e *csvExportWriter) close() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+type ndjsonExportWriter struct {
+	out  io.Writer
+	enc  *json.Encoder
+	rows int
+}
+
+func (e *ndjsonExportWriter) write(c Customer, products []Product) error {
+	if err := e.enc.Encode(exportRecord{Customer: c, Products: products}); err != nil {
+		return err
+	}
+	if e.rows++; e.rows%exportFlushRows == 0 {
+		flushOutput(e.out)
+	}
+	return nil
+}
+
+func (e *ndjsonExportWriter) close() error { return nil }
+
+type parquetExportWriter struct {
+	w    *parquet.GenericWriter[exportRow]
+	rows int
+}
+
+func (e *parquetExportWriter) write(c Customer, products []Product) error {
+	rows := flattenExport(c, products)
+	if _, err := e.w.Write(rows); err != nil {
+		return err
+	}
+	// Closing row groups regularly keeps the writer's buffers bounded.
+	if e.rows += len(rows); e.rows >= exportFlushRows {
+		e.rows = 0
+		return e.w.Flush()
+	}
+	return nil
+}
+
+func (e *parquetExportWriter) close() error { return e.w.Close() }
+
+func newExportWriter(format string, out io.Writer) (exportWriter, error) {
+	switch format {
+	case ExportCSV:
+		w := csv.NewWriter(out)
+		if err := w.Write(exportCSVHeader); err != nil {
+			return nil, err
+		}
+		return &csvExportWriter{out: out, w: w}, nil
+	case ExportNDJSON:
+		return &ndjsonExportWriter{out: out, enc: json.NewEncoder(out)}, nil
+	case ExportParquet:
+		return &parquetExportWriter{w: parquet.NewGenericWriter[exportRow](out, parquet.Compression(&parquet.Snappy))}, nil
+	}
+	return nil, fmt.Errorf("unsupported export format %q (use csv, ndjson or parquet)", format)
+}
+
+// exportCustomers streams every customer matching filter, with its products,
+// to out. It returns the number of customers written.
+func exportCustomers(ctx context.Context, store CustomerStore, out io.Writer, format string, filter CustomerFilter, maskPII bool) (int, error) {
+	w, err := newExportWriter(format, out)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	err = store.ExportCustomers(ctx, filter, func(c Customer, products []Product) error {
+		if maskPII {
+			c = maskCustomerPII(c)
+		}
+		count++
+		return w.write(c, products)
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, w.close()
+}
+
+// exportCustomersHandler handles GET /api/customers/export. It accepts the
+// same filters as /api/customers/all plus format=csv|ndjson|parquet and
+// mask_pii=true. Unmasked exports require the admin role.
+func (s *server) exportCustomersHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	format := strings.ToLower(q.Get("format"))
+	if format == "" {
+		format = ExportNDJSON
+	}
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "format must be csv, ndjson or parquet")
+		return
+	}
+	filter, err := parseCustomerFilter(q)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+	maskPII := false
+	if v := q.Get("mask_pii"); v != "" {
+		if maskPII, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "mask_pii must be true or false")
+			return
+		}
+	}
+	if p := principalFrom(r.Context()); !maskPII && (p == nil || !p.Role.allows(RoleAdmin)) {
+		respondWithError(w, http.StatusForbidden, ProblemForbidden, "Exports without mask_pii=true require the admin role")
+		return
+	}
+
+	filename := fmt.Sprintf("customers-%s.%s", time.Now().UTC().Format("20060102T150405Z"), format)
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.WriteHeader(http.StatusOK)
+
+	// The status line is already sent, so a failure part-way through can
+	// only be logged; the client sees a truncated body.
+	count, err := exportCustomers(r.Context(), s.store, w, format, filter, maskPII)
+	if err != nil {
+		log.Printf("Export aborted after %d customers (request %s): %v", count, requestIDFrom(r.Context()), err)
+	}
+}
+
+// runExportCommand implements `customerDB export [-format csv|ndjson|parquet]
+// [-filter QUERY] [-mask-pii] [-o FILE]`, using the same store configuration
+// as the server. -filter takes the listing query parameters, e.g.
+// "min_age=18&has_email=true".
+func runExportCommand(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", ExportNDJSON, "output format: csv, ndjson or parquet")
+	filterQuery := fs.String("filter", "", `listing filters as a query string, e.g. "min_age=18&has_email=true"`)
+	maskPII := fs.Bool("mask-pii", false, "mask ID documents, phone numbers and e-mail addresses")
+	output := fs.String("o", "-", `output file, or "-" for stdout`)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: customerDB export [-format csv|ndjson|parquet] [-filter QUERY] [-mask-pii] [-o FILE]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	values, err := url.ParseQuery(*filterQuery)
+	if err != nil {
+		log.Fatal("Invalid -filter: ", err)
+	}
+	filter, err := parseCustomerFilter(values)
+	if err != nil {
+		log.Fatal("Invalid -filter: ", err)
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	store, _, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	count, err := exportCustomers(context.Background(), store, out, strings.ToLower(*format), filter, *maskPII)
+	if err != nil {
+		closeStore()
+		log.Fatalf("Export failed after %d customers: %v", count, err)
+	}
+	log.Printf("Exported %d customers", count)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+func TestExportCustomersCreatedFilter(t *testing.T) {
+	h := newTestServer(t)
+	c := createTestCustomer(t, h, "Asha Rao", "K1234567")
+	rec := call(t, h, "POST", "/api/products",
+		`{"customer_id": `+strconv.FormatInt(c.CustomerID, 10)+`, "product_name": "Laptop", "quantity": 1, "price": {"amount": "1200.00", "currency": "INR"}}`)
+	expectStatus(t, rec, http.StatusCreated)
+
+	for _, tc := range []struct {
+		query string
+		want  int
+	}{
+		{"created_after=2000-01-01", 1},
+		{"created_before=2000-01-01", 0},
+		{"created_after=2000-01-01&created_before=2999-01-01", 1},
+	} {
+		rec := call(t, h, "GET", "/api/customers/export?format=ndjson&"+tc.query, "")
+		expectStatus(t, rec, http.StatusOK)
+		var lines int
+		scanner := bufio.NewScanner(rec.Body)
+		for scanner.Scan() {
+			var row map[string]interface{}
+			if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+				t.Fatalf("%s: line %q: %v", tc.query, scanner.Text(), err)
+			}
+			lines++
+		}
+		if lines != tc.want {
+			t.Errorf("%s: exported %d customers, want %d", tc.query, lines, tc.want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// --- Idempotency Keys ---
+
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
+	maxIdempotencyKeyLength  = 255
+	// Stored responses are replayed for idempotencyTTL. A claim still in
+	// flight after idempotencyLockTimeout is taken to be from a crashed
+	// instance and may be claimed again.
+	idempotencyTTL         = 24 * time.Hour
+	idempotencyLockTimeout = 5 * time.Minute
+	// Keyed request bodies up to idempotencyMemoryBody bytes are held in
+	// memory while they are hashed; larger ones, such as imports, are
+	// spooled to a temporary file.
+	idempotencyMemoryBody = 1 << 20
+)
+
+// replayedHeaders are the response headers stored with a response and sent
+// again on replay, besides the body.
+var replayedHeaders = []string{"Content-Type", "ETag", "Retry-After"}
+
+// IdempotencyRecord is the stored outcome of the first request made with an
+// Idempotency-Key. Status is 0 while that request is still in flight.
+type IdempotencyRecord struct {
+	// Key is the keyed hash of the caller and the client's key, so keys
+	// chosen by different callers never meet.
+	Key string `json:"key"`
+	// Fingerprint is the keyed hash of method, path and body. A key reused
+	// for a different request is rejected rather than replayed.
+	Fingerprint string            `json:"fingerprint"`
+	Status      int               `json:"status"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	// Body is sealed with the PII key ring; responses carry ID documents.
+	Body      string    `json:"body,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// IdempotencyStore keeps IdempotencyRecords in the database, where every API
+// instance sees them.
+type IdempotencyStore interface {
+	// ClaimIdempotencyKey stores rec as in flight and returns nil, or returns
+	// the record already held under rec.Key. Completed records created
+	// before expiredBefore and in-flight ones created before abandonedBefore
+	// are replaced by rec as if they did not exist.
+	ClaimIdempotencyKey(ctx context.Context, rec IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*IdempotencyRecord, error)
+	// CompleteIdempotencyKey fills in the response of a claimed record.
+	CompleteIdempotencyKey(ctx context.Context, rec IdempotencyRecord) error
+	// ReleaseIdempotencyKey drops a claim whose request failed, so a retry
+	// runs again.
+	ReleaseIdempotencyKey(ctx context.Context, key string) error
+	// PurgeIdempotencyKeys removes records created before createdBefore.
+	PurgeIdempotencyKeys(ctx context.Context, createdBefore time.Time) (int64, error)
+}
+
+// responseRecorder passes a response through to the client and keeps a copy.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent honours the Idempotency-Key header on writes. The first request
+// with a key runs next and its response is stored; later requests from the
+// same caller with that key get the stored status, headers and body back
+// without running next. 5xx responses are not stored, so the request can be
+// retried. Requests without the header run unchanged.
+func (s *server) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		clientKey := r.Header.Get(idempotencyKeyHeader)
+		if clientKey == "" || r.Method == http.MethodGet || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		if len(clientKey) > maxIdempotencyKeyLength {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter,
+				fmt.Sprintf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength))
+			return
+		}
+
+		// The body is hashed into the fingerprint, then handed on to next.
+		digest, cleanup, err := spoolBody(r, maxImportBytes+1)
+		if err != nil {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidJSON, "Failed to read request body")
+			return
+		}
+		defer cleanup()
+
+		caller := "anonymous"
+		if p := principalFrom(r.Context()); p != nil {
+			caller = p.Method + ":" + p.Subject
+		}
+		now := time.Now().UTC()
+		rec := IdempotencyRecord{
+			Key:         s.pii.hash("idempotency_key", caller+"\x00"+clientKey),
+			Fingerprint: s.pii.hash("idempotency_request", r.Method+" "+r.URL.RequestURI()+"\x00"+digest),
+			CreatedAt:   now,
+		}
+
+		existing := s.cachedIdempotencyRecord(rec.Key)
+		if existing == nil {
+			existing, err = s.store.ClaimIdempotencyKey(r.Context(), rec, now.Add(-idempotencyTTL), now.Add(-idempotencyLockTimeout))
+			if err != nil {
+				respondWithStoreError(w, "Failed to check the idempotency key", err)
+				return
+			}
+		}
+		if existing != nil {
+			s.replayIdempotent(w, rec, existing)
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		next(recorder, r)
+
+		// The response has been sent; finish the bookkeeping even if the
+		// client has already gone away.
+		ctx := context.WithoutCancel(r.Context())
+		if recorder.status == 0 || recorder.status >= 500 {
+			if err := s.store.ReleaseIdempotencyKey(ctx, rec.Key); err != nil {
+				log.Printf("Failed to release idempotency key (request %s): %v", w.Header().Get(requestIDHeader), err)
+			}
+			return
+		}
+		rec.Status = recorder.status
+		rec.Headers = make(map[string]string)
+		for _, h := range replayedHeaders {
+			if v := w.Header().Get(h); v != "" {
+				rec.Headers[h] = v
+			}
+		}
+		rec.Body = s.pii.seal("idempotent_response", recorder.body.String())
+		if err := s.store.CompleteIdempotencyKey(ctx, rec); err != nil {
+			log.Printf("Failed to store idempotent response (request %s): %v", w.Header().Get(requestIDHeader), err)
+			return
+		}
+		s.cacheIdempotencyRecord(rec)
+	}
+}
+
+// spoolBody reads up to limit bytes of r.Body through SHA-256 and replaces
+// r.Body with a copy next can read again. Bodies over idempotencyMemoryBody
+// go to a temporary file, so concurrent keyed imports do not each hold up to
+// maxImportBytes in memory. cleanup removes the file.
+func spoolBody(r *http.Request, limit int64) (digest string, cleanup func(), err error) {
+	h := sha256.New()
+	body := io.TeeReader(io.LimitReader(r.Body, limit), h)
+
+	var head bytes.Buffer
+	if _, err := io.CopyN(&head, body, idempotencyMemoryBody+1); err == io.EOF {
+		r.Body = io.NopCloser(&head)
+		return hex.EncodeToString(h.Sum(nil)), func() {}, nil
+	} else if err != nil {
+		return "", nil, err
+	}
+
+	f, err := os.CreateTemp("", "idempotent-body-*")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup = func() {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	if _, err := head.WriteTo(f); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := io.Copy(f, body); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	r.Body = io.NopCloser(f)
+	return hex.EncodeToString(h.Sum(nil)), cleanup, nil
+}
+
+// replayIdempotent answers a request whose key is already known.
+func (s *server) replayIdempotent(w http.ResponseWriter, rec IdempotencyRecord, existing *IdempotencyRecord) {
+	switch {
+	case existing.Fingerprint != rec.Fingerprint:
+		respondWithError(w, http.StatusUnprocessableEntity, ProblemIdempotencyKeyReused,
+			fmt.Sprintf("%s was already used for a different request", idempotencyKeyHeader))
+	case existing.Status == 0:
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusConflict, ProblemIdempotencyInFlight,
+			fmt.Sprintf("A request with this %s is still being processed", idempotencyKeyHeader))
+	default:
+		body, err := s.pii.open("idempotent_response", existing.Body)
+		if err != nil {
+			respondWithInternalError(w, "Failed to replay the stored response", err)
+			return
+		}
+		for h, v := range existing.Headers {
+			w.Header().Set(h, v)
+		}
+		w.Header().Set(idempotentReplayedHeader, "true")
+		w.WriteHeader(existing.Status)
+		io.WriteString(w, body)
+	}
+}
+
+func idempotencyCacheKey(key string) string {
+	return "idempotency:" + key
+}
+
+// cachedIdempotencyRecord returns a completed record from memcached, or nil.
+// Only completed records are cached, so claims always go to the database.
+func (s *server) cachedIdempotencyRecord(key string) *IdempotencyRecord {
+	cached, err := s.cache.Get(idempotencyCacheKey(key))
+	if err != nil {
+		return nil
+	}
+	var rec IdempotencyRecord
+	if json.Unmarshal(cached, &rec) != nil || rec.Status == 0 || time.Since(rec.CreatedAt) > idempotencyTTL {
+		return nil
+	}
+	return &rec
+}
+
+func (s *server) cacheIdempotencyRecord(rec IdempotencyRecord) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	// Responses over memcached's item size are not cached; the database
+	// copy still serves their replays.
+	s.cache.Set(idempotencyCacheKey(rec.Key), data, idempotencyTTL)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSpoolBody(t *testing.T) {
+	for _, size := range []int{0, 10, idempotencyMemoryBody, idempotencyMemoryBody + 1, 3 * idempotencyMemoryBody} {
+		body := bytes.Repeat([]byte("x"), size)
+		r := httptest.NewRequest("POST", "/api/customers/import", bytes.NewReader(body))
+		digest, cleanup, err := spoolBody(r, int64(size)+1)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", size, err)
+		}
+		got, err := io.ReadAll(r.Body)
+		cleanup()
+		if err != nil || !bytes.Equal(got, body) {
+			t.Errorf("%d bytes: read back %d bytes, %v", size, len(got), err)
+		}
+		sum := sha256.Sum256(body)
+		if digest != hex.EncodeToString(sum[:]) {
+			t.Errorf("%d bytes: digest %s, want the SHA-256 of the body", size, digest)
+		}
+	}
+}
+
+func TestIdempotencyKeyReplay(t *testing.T) {
+	h := newTestServer(t)
+	body := `{"name": "Asha Rao", "age": 30, "address": "12 MG Road", "passport_id": "K1234567"}`
+
+	first := call(t, h, "POST", "/api/customers", body, idempotencyKeyHeader, "k1")
+	expectStatus(t, first, http.StatusCreated)
+	again := call(t, h, "POST", "/api/customers", body, idempotencyKeyHeader, "k1")
+	expectStatus(t, again, http.StatusCreated)
+	if again.Header().Get(idempotentReplayedHeader) != "true" || again.Body.String() != first.Body.String() {
+		t.Errorf("retry was not replayed: %s", again.Body.String())
+	}
+
+	other := call(t, h, "POST", "/api/customers", `{"name": "Ravi Rao", "age": 40, "address": "1 Park St", "passport_id": "K7654321"}`,
+		idempotencyKeyHeader, "k1")
+	expectStatus(t, other, http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"sync"
+)
+
+// --- Customer ID Generation ---
+
+// Customer IDs are ten digits: a nine-digit body chosen by an IDGenerator
+// followed by a Luhn check digit, so a mistyped ID is rejected by
+// parseCustomerID instead of being looked up.
+const (
+	minIDBody = 100000000
+	maxIDBody = 999999999
+)
+
+// ErrIDSpaceExhausted is returned once a generator has handed out every body
+// it can represent.
+var ErrIDSpaceExhausted = errors.New("customer ID space exhausted")
+
+// IDGenerator hands out customer IDs. Only the random strategy can repeat an
+// ID; sequence may still collide with IDs issued by a different strategy,
+// so stores insert and draw again on a duplicate customer_id rather than
+// checking first.
+type IDGenerator interface {
+	NextID(ctx context.Context) (int64, error)
+}
+
+// luhnDigit returns the Luhn check digit for n.
+func luhnDigit(n int64) int64 {
+	sum, double := int64(0), true
+	for ; n > 0; n /= 10 {
+		d := n % 10
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return (10 - sum%10) % 10
+}
+
+// withCheckDigit turns a nine-digit body into a customer ID.
+func withCheckDigit(body int64) int64 {
+	return body*10 + luhnDigit(body)
+}
+
+// validCustomerID reports whether id is ten digits ending in a correct check
+// digit.
+func validCustomerID(id int64) bool {
+	return id >= minIDBody*10 && id <= maxIDBody*10+9 && luhnDigit(id/10) == id%10
+}
+
+// checkCustomerIDs is set by CUSTOMER_ID_CHECK_DIGIT=true. It is off by
+// default: IDs issued before check digits were introduced are random ten-digit
+// numbers, nine in ten of which fail the check, and the check cannot tell them
+// apart from typos.
+var checkCustomerIDs = false
+
+// parseCustomerID parses a customer ID from a URL or query string.
+func parseCustomerID(v string) (int64, error) {
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errors.New("Invalid customer ID format")
+	}
+	if checkCustomerIDs && !validCustomerID(id) {
+		return 0, errors.New("Invalid customer ID: the check digit does not match, please check for typos")
+	}
+	return id, nil
+}
+
+// randomIDGenerator draws bodies uniformly. Collisions become likelier as the
+// table fills; the stores retry them.
+type randomIDGenerator struct{}
+
+func (randomIDGenerator) NextID(ctx context.Context) (int64, error) {
+	return withCheckDigit(minIDBody + rand.Int63n(maxIDBody-minIDBody+1)), nil
+}
+
+// sequenceIDGenerator issues consecutive bodies from blocks reserved by
+// allocate, so the shared counter is only touched once per block. Blocks not
+// used up before a restart leave gaps.
+type sequenceIDGenerator struct {
+	mu        sync.Mutex
+	next, end int64
+	blockSize int
+	// allocate reserves n bodies and returns the first.
+	allocate func(ctx context.Context, n int) (int64, error)
+}
+
+func newSequenceIDGenerator(blockSize int, allocate func(ctx context.Context, n int) (int64, error)) *sequenceIDGenerator {
+	return &sequenceIDGenerator{blockSize: blockSize, allocate: allocate}
+}
+
+func (g *sequenceIDGenerator) NextID(ctx context.Context) (int64, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.next == g.end {
+		start, err := g.allocate(ctx, g.blockSize)
+		if err != nil {
+			return 0, fmt.Errorf("failed to allocate customer ID block: %w", err)
+		}
+		if start+int64(g.blockSize)-1 > maxIDBody {
+			return 0, ErrIDSpaceExhausted
+		}
+		g.next, g.end = start, start+int64(g.blockSize)
+	}
+	body := g.next
+	g.next++
+	return withCheckDigit(body), nil
+}
+
+// memorySequence is the allocate function of a sequenceIDGenerator that is
+// not shared with other processes.
+func memorySequence() func(ctx context.Context, n int) (int64, error) {
+	var mu sync.Mutex
+	next := int64(minIDBody)
+	return func(ctx context.Context, n int) (int64, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		start := next
+		next += int64(n)
+		return start, nil
+	}
+}
+
+// idGeneratorFromEnv builds the generator named by CUSTOMER_ID_STRATEGY
+// (random or sequence; default random). sequence draws blocks of
+// CUSTOMER_ID_BLOCK_SIZE (default 100) from allocate.
+func idGeneratorFromEnv(allocate func(ctx context.Context, n int) (int64, error)) (IDGenerator, error) {
+	switch strategy := getEnv("CUSTOMER_ID_STRATEGY", "random"); strategy {
+	case "random":
+		return randomIDGenerator{}, nil
+	case "sequence":
+		blockSize, err := strconv.Atoi(getEnv("CUSTOMER_ID_BLOCK_SIZE", "100"))
+		if err != nil || blockSize < 1 {
+			return nil, fmt.Errorf("CUSTOMER_ID_BLOCK_SIZE must be a positive integer")
+		}
+		return newSequenceIDGenerator(blockSize, allocate), nil
+	case "snowflake":
+		// 900 million bodies cannot hold a timestamp fine enough to absorb
+		// bursts plus a node and a sequence for any useful number of years.
+		return nil, fmt.Errorf("CUSTOMER_ID_STRATEGY=snowflake is not supported: 9-digit IDs are too short for a timestamp, node and sequence; use sequence to share IDs between instances")
+	default:
+		return nil, fmt.Errorf("unknown CUSTOMER_ID_STRATEGY %q (use random or sequence)", strategy)
+	}
+}
+
+// maxIDAttempts bounds how often a store draws a new ID after a collision.
+const maxIDAttempts = 5
+
+// isIDCollision reports whether err is a duplicate customer_id, meaning the
+// insert should be retried with a fresh ID.
+func isIDCollision(err error) bool {
+	var dupErr *DuplicateKeyError
+	return errors.As(err, &dupErr) && dupErr.Field == "customer_id"
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestLuhnDigit(t *testing.T) {
+	for _, tc := range []struct {
+		body, want int64
+	}{
+		{100000000, 8}, // the README example, 1000000008
+		{7992739871, 3},
+		{123456789, 7},
+		{999999999, 9},
+	} {
+		if got := luhnDigit(tc.body); got != tc.want {
+			t.Errorf("luhnDigit(%d) = %d, want %d", tc.body, got, tc.want)
+		}
+	}
+}
+
+func TestValidCustomerID(t *testing.T) {
+	for _, tc := range []struct {
+		id   int64
+		want bool
+	}{
+		{1000000008, true},
+		{withCheckDigit(maxIDBody), true},
+		{1000000009, false},
+		{1234567890, false},
+		{100000008, false},   // nine digits
+		{99999999999, false}, // eleven digits
+		{withCheckDigit(minIDBody - 1), false},
+	} {
+		if got := validCustomerID(tc.id); got != tc.want {
+			t.Errorf("validCustomerID(%d) = %v, want %v", tc.id, got, tc.want)
+		}
+	}
+}
+
+// Every single-digit typo in an ID changes the Luhn sum.
+func TestValidCustomerIDDetectsTypos(t *testing.T) {
+	id := strconv.FormatInt(withCheckDigit(482913507), 10)
+	for i := 0; i < len(id); i++ {
+		for d := byte('0'); d <= '9'; d++ {
+			if d == id[i] {
+				continue
+			}
+			typo, _ := strconv.ParseInt(id[:i]+string(d)+id[i+1:], 10, 64)
+			if validCustomerID(typo) {
+				t.Errorf("typo %d at position %d not detected", typo, i)
+			}
+		}
+	}
+}
+
+func TestParseCustomerID(t *testing.T) {
+	defer func(saved bool) { checkCustomerIDs = saved }(checkCustomerIDs)
+
+	for _, tc := range []struct {
+		value string
+		check bool
+		want  int64 // 0 for an error
+	}{
+		{"1000000008", true, 1000000008},
+		{"1000000009", true, 0},
+		{"1000000009", false, 1000000009}, // a legacy ID without a check digit
+		{"42", false, 42},
+		{"0", false, 0},
+		{"-5", false, 0},
+		{"abc", false, 0},
+		{"", false, 0},
+	} {
+		checkCustomerIDs = tc.check
+		got, err := parseCustomerID(tc.value)
+		if tc.want == 0 {
+			if err == nil {
+				t.Errorf("parseCustomerID(%q) with check %v = %d, want an error", tc.value, tc.check, got)
+			}
+		} else if err != nil || got != tc.want {
+			t.Errorf("parseCustomerID(%q) with check %v = %d, %v; want %d", tc.value, tc.check, got, err, tc.want)
+		}
+	}
+}
+
+func TestSequenceIDGenerator(t *testing.T) {
+	g := newSequenceIDGenerator(2, memorySequence())
+	for want := int64(minIDBody); want < minIDBody+5; want++ {
+		id, err := g.NextID(context.Background())
+		if err != nil || id != withCheckDigit(want) {
+			t.Fatalf("NextID() = %d, %v; want %d", id, err, withCheckDigit(want))
+		}
+	}
+}
+
+func TestIDGeneratorFromEnvRejectsSnowflake(t *testing.T) {
+	t.Setenv("CUSTOMER_ID_STRATEGY", "snowflake")
+	if g, err := idGeneratorFromEnv(memorySequence()); err == nil {
+		t.Errorf("snowflake accepted as %T", g)
+	}
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Bulk Import ---
+
+const (
+	importBatchSize = 500
+	maxImportBytes  = 64 << 20
+	maxImportRows   = 100000
+)
+
+// Row statuses in an ImportReport.
+const (
+	ImportRowCreated  = "created"
+	ImportRowValid    = "valid" // dry run: would have been created
+	ImportRowRejected = "rejected"
+)
+
+// ImportRowResult is the outcome of one input row. Row is the 1-based line
+// number in the uploaded file (the CSV header is line 1).
+type ImportRowResult struct {
+	Row        int          `json:"row"`
+	Status     string       `json:"status"`
+	CustomerID int64        `json:"customer_id,omitempty"`
+	Error      string       `json:"error,omitempty"`
+	Violations []FieldError `json:"violations,omitempty"`
+}
+
+// ImportReport summarises an import; Rows lists every row in file order.
+type ImportReport struct {
+	Format   string            `json:"format"`
+	DryRun   bool              `json:"dry_run"`
+	Total    int               `json:"total"`
+	Created  int               `json:"created"`
+	Valid    int               `json:"valid"`
+	Rejected int               `json:"rejected"`
+	Rows     []ImportRowResult `json:"rows"`
+}
+
+func (rep *ImportReport) add(res ImportRowResult) {
+	rep.Rows = append(rep.Rows, res)
+	switch res.Status {
+	case ImportRowCreated:
+		rep.Created++
+	case ImportRowValid:
+		rep.Valid++
+	case ImportRowRejected:
+		rep.Rejected++
+	}
+}
+
+// importRow is a parsed input row; err is set when the row could not be
+// turned into a Customer.
+type importRow struct {
+	line     int
+	customer Customer
+	err      error
+}
+
+// importCSVColumns maps CSV header names to setters. Headers use the JSON
+// field names of Customer; empty cells leave optional fields null.
+var importCSVColumns = map[string]func(c *Customer, v string) error{
+	"name":    func(c *Customer, v string) error { c.Name = v; return nil },
+	"address": func(c *Customer, v string) error { c.Address = v; return nil },
+	"age": func(c *Customer, v string) error {
+		if v == "" {
+			return nil
+		}
+		age, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("age %q is not an integer", v)
+		}
+		c.Age = age
+		return nil
+	},
+	"phone_number":       optionalColumn(func(c *Customer) **string { return &c.PhoneNumber }),
+	"email":              optionalColumn(func(c *Customer) **string { return &c.Email }),
+	"passport_id":        optionalColumn(func(c *Customer) **string { return &c.PassportID }),
+	"aadhar_id":          optionalColumn(func(c *Customer) **string { return &c.AadharID }),
+	"driving_license_id": optionalColumn(func(c *Customer) **string { return &c.DrivingLicenseID }),
+}
+
+func optionalColumn(field func(c *Customer) **string) func(c *Customer, v string) error {
+	return func(c *Customer, v string) error {
+		if v != "" {
+			*field(c) = &v
+		}
+		return nil
+	}
+}
+
+// parseImportCSV reads a CSV file whose first line names the columns.
+// Malformed quoting aborts the whole import; a row with the wrong number of
+// fields only rejects that row.
+func parseImportCSV(r io.Reader, emit func(importRow) error) error {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return errors.New("CSV file is empty; the first line must name the columns")
+	} else if err != nil {
+		return fmt.Errorf("CSV header: %w", err)
+	}
+	setters := make([]func(*Customer, string) error, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))
+		set, ok := importCSVColumns[name]
+		if !ok {
+			return fmt.Errorf("unknown CSV column %q", name)
+		}
+		if seen[name] {
+			return fmt.Errorf("duplicate CSV column %q", name)
+		}
+		seen[name], setters[i] = true, set
+	}
+	for _, required := range []string{"name", "age", "address"} {
+		if !seen[required] {
+			return fmt.Errorf("CSV header is missing the %q column", required)
+		}
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line, _ := reader.FieldPos(0)
+		row := importRow{line: line}
+		if errors.Is(err, csv.ErrFieldCount) {
+			row.err = fmt.Errorf("expected %d fields, got %d", len(header), len(record))
+		} else if err != nil {
+			return fmt.Errorf("CSV: %w", err)
+		} else {
+			for i, value := range record {
+				if err := setters[i](&row.customer, strings.TrimSpace(value)); err != nil {
+					row.err = err
+					break
+				}
+			}
+		}
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+}
+
+// parseImportNDJSON reads one Customer JSON object per line. Blank lines are
+// skipped; customer_id and server-managed fields are ignored.
+func parseImportNDJSON(r io.Reader, emit func(importRow) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		row := importRow{line: line}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&row.customer); err != nil {
+			row.err = fmt.Errorf("invalid JSON: %v", err)
+			row.customer = Customer{}
+		}
+		row.customer.CustomerID = 0
+		if err := emit(row); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("line %d: %w", line+1, err)
+	}
+	return nil
+}
+
+var errTooManyImportRows = fmt.Errorf("imports are limited to %d rows per file", maxImportRows)
+
+// importDocumentFields lists the ID document JSON fields in the order
+// duplicates are reported.
+var importDocumentFields = []string{"passport_id", "aadhar_id", "driving_license_id"}
+
+// duplicateRowResult rejects a row whose ID document is already taken.
+func duplicateRowResult(line int, dupErr *DuplicateKeyError) ImportRowResult {
+	reason := dupErr.reason() + " or earlier in this file"
+	return ImportRowResult{
+		Row: line, Status: ImportRowRejected, Error: dupErr.Field + " " + reason,
+		Violations: []FieldError{{Field: dupErr.Field, Code: CodeDuplicate, Message: reason}},
+	}
+}
+
+// importCustomers validates every row like createCustomer does, then hands
+// the valid ones to the store in batches of importBatchSize. An error is
+// returned only for problems with the file as a whole or the database;
+// row-level problems are recorded in the report.
+//
+// The store only sees duplicates within a batch and against committed rows.
+// A dry run rolls every batch back, so the ID documents of rows accepted in
+// earlier batches are remembered here; a later row reusing one is rejected
+// before it reaches the store, in dry runs and real imports alike.
+func importCustomers(ctx context.Context, store CustomerStore, r io.Reader, format string, dryRun bool) (ImportReport, error) {
+	report := ImportReport{Format: format, DryRun: dryRun, Rows: []ImportRowResult{}}
+
+	// JSON field -> normalised document value -> accepted in an earlier batch
+	seen := make(map[string]map[string]bool, len(importDocumentFields))
+	for _, field := range importDocumentFields {
+		seen[field] = make(map[string]bool)
+	}
+	documents := func(c *Customer, fn func(field, value string) bool) {
+		docs := customerDocuments(c)
+		for _, field := range importDocumentFields {
+			if doc := *docs[documentJSONFields[field]]; doc != nil && !fn(field, *doc) {
+				return
+			}
+		}
+	}
+
+	var batch []Customer
+	var batchLines []int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rowErrs, err := store.ImportCustomers(ctx, batch, dryRun)
+		if err != nil {
+			return storeError{err}
+		}
+		for i, rowErr := range rowErrs {
+			res := ImportRowResult{Row: batchLines[i]}
+			var dupErr *DuplicateKeyError
+			if rowErr == nil {
+				documents(&batch[i], func(field, value string) bool {
+					seen[field][value] = true
+					return true
+				})
+			}
+			switch {
+			case errors.As(rowErr, &dupErr):
+				res = duplicateRowResult(batchLines[i], dupErr)
+			case rowErr != nil:
+				res.Status, res.Error = ImportRowRejected, rowErr.Error()
+			case dryRun:
+				res.Status = ImportRowValid
+			default:
+				res.Status, res.CustomerID = ImportRowCreated, batch[i].CustomerID
+			}
+			report.add(res)
+		}
+		batch, batchLines = batch[:0], batchLines[:0]
+		return nil
+	}
+
+	emit := func(row importRow) error {
+		if report.Total == maxImportRows {
+			return errTooManyImportRows
+		}
+		report.Total++
+		if row.err == nil {
+			row.err = validateCustomer(&row.customer)
+		}
+		if row.err != nil {
+			res := ImportRowResult{Row: row.line, Status: ImportRowRejected, Error: row.err.Error()}
+			var verr *ValidationError
+			if errors.As(row.err, &verr) {
+				res.Violations = verr.Fields
+			}
+			report.add(res)
+			return nil
+		}
+		var dupErr *DuplicateKeyError
+		documents(&row.customer, func(field, value string) bool {
+			if seen[field][value] {
+				dupErr = &DuplicateKeyError{Field: field}
+			}
+			return dupErr == nil
+		})
+		if dupErr != nil {
+			report.add(duplicateRowResult(row.line, dupErr))
+			return nil
+		}
+		batch = append(batch, row.customer)
+		batchLines = append(batchLines, row.line)
+		if len(batch) == importBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	var err error
+	switch format {
+	case "csv":
+		err = parseImportCSV(r, emit)
+	case "ndjson":
+		err = parseImportNDJSON(r, emit)
+	default:
+		err = fmt.Errorf("unsupported import format %q (use csv or ndjson)", format)
+	}
+	if err == nil {
+		err = flush()
+	}
+	// Rows rejected during validation were reported before their batch
+	// was flushed; restore file order.
+	sort.Slice(report.Rows, func(i, j int) bool { return report.Rows[i].Row < report.Rows[j].Row })
+	return report, err
+}
+
+// importFormat picks the format from ?format= or the Content-Type header.
+func importFormat(r *http.Request) string {
+	if f := strings.ToLower(r.URL.Query().Get("format")); f != "" {
+		return f
+	}
+	switch ct := strings.ToLower(r.Header.Get("Content-Type")); {
+	case strings.HasPrefix(ct, "text/csv"):
+		return "csv"
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/ndjson"),
+		strings.HasPrefix(ct, "application/jsonl"):
+		return "ndjson"
+	}
+	return ""
+}
+
+// importCustomersHandler handles POST /api/customers/import. The body is the
+// raw CSV or NDJSON file; ?dry_run=true validates every row (including ID
+// document uniqueness) without writing anything.
+func (s *server) importCustomersHandler(w http.ResponseWriter, r *http.Request) {
+	format := importFormat(r)
+	if format != "csv" && format != "ndjson" {
+		respondWithError(w, http.StatusUnsupportedMediaType, ProblemUnsupportedMedia, "Send Content-Type text/csv or application/x-ndjson, or set ?format=csv|ndjson")
+		return
+	}
+	dryRun := false
+	if v := r.URL.Query().Get("dry_run"); v != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(v); err != nil {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "dry_run must be true or false")
+			return
+		}
+	}
+
+	body := http.MaxBytesReader(w, r.Body, maxImportBytes)
+	report, err := importCustomers(r.Context(), s.store, body, format, dryRun)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		err = fmt.Errorf("import file exceeds %d bytes", maxImportBytes)
+	}
+	if err != nil {
+		// Earlier batches may already be committed; return the partial
+		// report so the caller knows which rows to resubmit.
+		body := ImportFailedResponse{
+			Problem: newProblem(http.StatusBadRequest, ProblemImportAborted, fmt.Sprintf("Import aborted: %v", err)),
+			Import:  &report,
+		}
+		if isStoreError(err) {
+			log.Printf("Import error after %d rows (request %s): %v", report.Total, requestIDFrom(r.Context()), err)
+			body.Status, body.Code = http.StatusInternalServerError, ProblemInternal
+			body.Detail = fmt.Sprintf("Import aborted by a database error after %d rows", report.Total)
+		}
+		writeProblem(w, &body.Problem, &body)
+		return
+	}
+
+	message := fmt.Sprintf("Imported %d of %d rows; %d rejected", report.Created, report.Total, report.Rejected)
+	if dryRun {
+		message = fmt.Sprintf("Dry run: %d of %d rows are valid; %d rejected", report.Valid, report.Total, report.Rejected)
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{Message: message, Import: &report})
+}
+
+// ImportFailedResponse carries the partial report when a bad file or a
+// database error stops an import part-way through.
+type ImportFailedResponse struct {
+	Problem
+	Import *ImportReport `json:"import"`
+}
+
+// storeError marks failures that came from the persistence layer rather than
+// from the uploaded file.
+type storeError struct{ err error }
+
+func (e storeError) Error() string { return e.err.Error() }
+func (e storeError) Unwrap() error { return e.err }
+
+func isStoreError(err error) bool {
+	var se storeError
+	return errors.As(err, &se)
+}
+
+// runImportCommand implements `customerDB import [-format csv|ndjson]
+// [-dry-run] FILE`, using the same store configuration as the server. FILE
+// may be "-" for stdin. The report is printed as JSON; the exit status is 1
+// if any row was rejected.
+func runImportCommand(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	format := fs.String("format", "", "input format: csv or ndjson (default: from the file extension)")
+	dryRun := fs.Bool("dry-run", false, "validate every row without writing")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: customerDB import [-format csv|ndjson] [-dry-run] FILE")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	if *format == "" {
+		switch {
+		case strings.HasSuffix(strings.ToLower(path), ".csv"):
+			*format = "csv"
+		case strings.HasSuffix(strings.ToLower(path), ".ndjson"), strings.HasSuffix(strings.ToLower(path), ".jsonl"):
+			*format = "ndjson"
+		default:
+			log.Fatal("Cannot infer the format from the file name; pass -format csv or -format ndjson")
+		}
+	}
+
+	in := os.Stdin
+	if path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	store, _, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	ctx := withPrincipal(context.Background(), &Principal{Subject: "cli:import", Role: RoleAdmin, Method: "cli"})
+	report, err := importCustomers(ctx, store, in, *format, *dryRun)
+
+	out := json.NewEncoder(os.Stdout)
+	out.SetIndent("", "  ")
+	out.Encode(report)
+	if err != nil {
+		closeStore()
+		log.Fatal("Import failed: ", err)
+	}
+	if report.Rejected > 0 {
+		closeStore()
+		os.Exit(1)
+	}
+}
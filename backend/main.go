@@ -1,8 +1,10 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -12,7 +14,6 @@ import (
 	"strings"
 	"time"
 
-	"github.com/bradfitz/gomemcache/memcache"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/rs/cors"
@@ -21,40 +22,99 @@ import (
 // --- Struct Definitions ---
 
 type Customer struct {
-	CustomerID       int64     `json:"customer_id"`
-	Name             string    `json:"name"`
-	Age              int       `json:"age"`
-	Address          string    `json:"address"`
-	PhoneNumber      *string   `json:"phone_number,omitempty"`
-	Email            *string   `json:"email,omitempty"`
-	PassportID       *string   `json:"passport_id,omitempty"`
-	AadharID         *string   `json:"aadhar_id,omitempty"`
-	DrivingLicenseID *string   `json:"driving_license_id,omitempty"`
-	CreatedAt        time.Time `json:"created_at,omitempty"`
+	CustomerID       int64      `json:"customer_id"`
+	Name             string     `json:"name"`
+	Age              int        `json:"age"`
+	Address          string     `json:"address"`
+	PhoneNumber      *string    `json:"phone_number,omitempty"`
+	Email            *string    `json:"email,omitempty"`
+	PassportID       *string    `json:"passport_id,omitempty"`
+	AadharID         *string    `json:"aadhar_id,omitempty"`
+	DrivingLicenseID *string    `json:"driving_license_id,omitempty"`
+	CreatedAt        time.Time  `json:"created_at,omitempty"`
+	DeletedAt        *time.Time `json:"deleted_at,omitempty"`
+	// Version increments on every change; its ETag guards PUT via If-Match.
+	Version int `json:"version"`
 }
 
+// Product is a purchase by a customer. Products added by SKU refer to a
+// CatalogItem; Price is the exact unit price at the time of purchase.
 type Product struct {
 	ProductID   int     `json:"product_id"`
 	CustomerID  int64   `json:"customer_id"`
+	SKU         *string `json:"sku,omitempty"`
 	ProductName string  `json:"product_name"`
 	Quantity    int     `json:"quantity"`
-	Price       float64 `json:"price"`
-}
-
-type ErrorResponse struct {
-	Error string `json:"error"`
+	Price       Money   `json:"price"`
+	// When the product was bought; unknown (nil) for products recorded
+	// before purchase times were kept.
+	CreatedAt *time.Time `json:"created_at,omitempty"`
 }
 
 // FIX: Ensure 'Customers' field uses the correct lowercase JSON tag "customers"
 type SuccessResponse struct {
-	Message   string     `json:"message"`
-	Customer  *Customer  `json:"customer,omitempty"`
-	Products  []Product  `json:"products,omitempty"`
-	Customers []Customer `json:"customers,omitempty"` // <-- CRITICAL FIX for UI list endpoint
+	Message   string          `json:"message"`
+	Customer  *CustomerView   `json:"customer,omitempty"`
+	Products  []Product       `json:"products,omitempty"`
+	Customers []*CustomerView `json:"customers,omitempty"` // <-- CRITICAL FIX for UI list endpoint
+	Paging    *PageInfo       `json:"paging,omitempty"`
+	Search    *SearchInfo     `json:"search,omitempty"`
+	History   []AuditEntry    `json:"history,omitempty"`
+	Import    *ImportReport   `json:"import,omitempty"`
+	Cache     *CacheReport    `json:"cache,omitempty"`
+	Product   *Product        `json:"product,omitempty"`
+	Totals    []Money         `json:"totals,omitempty"` // spend per currency across Products
+
+	Catalog     []CatalogItem      `json:"catalog,omitempty"`
+	CatalogItem *CatalogItem       `json:"catalog_item,omitempty"`
+	CatalogLink *CatalogLinkReport `json:"catalog_link,omitempty"`
+
+	Order  *Order  `json:"order,omitempty"`
+	Orders []Order `json:"orders,omitempty"`
+
+	Summary *ProductSummary `json:"summary,omitempty"`
+}
+
+// server holds the dependencies shared by all handlers. Tests can build one
+// around newMemoryStore(), newLRUCache(), openAccess{} and
+// newEphemeralPIIKeyRing() to run without containers.
+type server struct {
+	store    Store
+	cache    Cache
+	cacheTTL time.Duration
+	auth     Authenticator
+	pii      *piiKeyRing
+	// lookups coalesces concurrent cache misses for the same customer.
+	lookups flightGroup
+	// corsOrigins are the browser origins allowed to call the API.
+	corsOrigins []string
 }
 
-var db *sql.DB
-var mc *memcache.Client
+func newServer(store Store, cache Cache, auth Authenticator, pii *piiKeyRing) *server {
+	return &server{store: store, cache: cache, cacheTTL: time.Hour, auth: auth, pii: pii,
+		corsOrigins: []string{defaultCORSOrigin}}
+}
+
+// defaultCORSOrigin is the React development server.
+const defaultCORSOrigin = "http://localhost:3000"
+
+// corsOriginsFromEnv reads CORS_ALLOWED_ORIGINS, a comma-separated list of
+// origins such as https://crm.example.com. "*" is refused: the API is called
+// with credentials in headers, and any page on the web could use them.
+func corsOriginsFromEnv() ([]string, error) {
+	var origins []string
+	for _, origin := range strings.Split(getEnv("CORS_ALLOWED_ORIGINS", defaultCORSOrigin), ",") {
+		origin = strings.TrimSpace(origin)
+		if origin == "" {
+			continue
+		}
+		if strings.Contains(origin, "*") {
+			return nil, fmt.Errorf("CORS_ALLOWED_ORIGINS must list exact origins, not %q", origin)
+		}
+		origins = append(origins, origin)
+	}
+	return origins, nil
+}
 
 // Initialize the random source
 func init() {
@@ -62,7 +122,7 @@ func init() {
 	rand.NewSource(time.Now().UnixNano())
 }
 
-// --- Utility Functions (Unchanged) ---
+// --- Utility Functions ---
 
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
@@ -71,31 +131,9 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// generateUniqueID generates a unique 10-digit Customer ID
-func generateUniqueID(tx *sql.Tx) (int64, error) {
-	const maxRetries = 5
-	for i := 0; i < maxRetries; i++ {
-		// Generate a 10-digit number (1,000,000,000 to 9,999,999,999)
-		id := rand.Int63n(9000000000) + 1000000000
-
-		// Check if the ID already exists in the database
-		var exists bool
-		err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE customer_id = ?)", id).Scan(&exists)
-		if err != nil && err != sql.ErrNoRows {
-			return 0, fmt.Errorf("database check failed: %w", err)
-		}
-
-		if !exists {
-			return id, nil
-		}
-		log.Printf("Generated ID %d already exists. Retrying...", id)
-	}
-	return 0, fmt.Errorf("failed to generate unique customer ID after %d retries", maxRetries)
-}
-
-// --- DB/Memcached Initialization (Unchanged) ---
+// --- DB/Memcached Initialization ---
 
-func initDB() error {
+func initDB() (*sql.DB, error) {
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true",
 		getEnv("DB_USER", "rghoshal"),
 		getEnv("DB_PASSWORD", "Putishwar2345@"),
@@ -108,11 +146,9 @@ func initDB() error {
 	initialWait := 1 * time.Second
 
 	for i := 0; i < maxRetries; i++ {
-		var err error
-
-		db, err = sql.Open("mysql", dsn)
+		db, err := sql.Open("mysql", dsn)
 		if err != nil {
-			return fmt.Errorf("failed to open database connection: %w", err)
+			return nil, fmt.Errorf("failed to open database connection: %w", err)
 		}
 
 		if err = db.Ping(); err == nil {
@@ -120,14 +156,12 @@ func initDB() error {
 			db.SetMaxOpenConns(25)
 			db.SetMaxIdleConns(5)
 			db.SetConnMaxLifetime(5 * time.Minute)
-			return nil
+			return db, nil
 		}
 
 		log.Printf("DB Ping failed (attempt %d/%d): %v. Retrying in %v...", i+1, maxRetries, err, initialWait)
 		// Close the connection attempt before retrying
-		if db != nil {
-			db.Close()
-		}
+		db.Close()
 		time.Sleep(initialWait)
 
 		initialWait = initialWait * 2
@@ -136,539 +170,548 @@ func initDB() error {
 		}
 	}
 
-	return fmt.Errorf("failed to connect to database after %d retries", maxRetries)
-}
-
-func initMemcached() {
-	mc = memcache.New(getEnv("MEMCACHED_HOST", "localhost:11211"))
+	return nil, fmt.Errorf("failed to connect to database after %d retries", maxRetries)
 }
 
 // --- Handlers ---
 
-// createCustomer: (Unchanged)
-func createCustomer(w http.ResponseWriter, r *http.Request) {
-	var customer Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	if customer.Name == "" || customer.Age <= 0 || customer.Address == "" {
-		respondWithError(w, http.StatusBadRequest, "Name, age, and address are mandatory")
-		return
-	}
-
-	if customer.AadharID == nil && customer.PassportID == nil && customer.DrivingLicenseID == nil {
-		respondWithError(w, http.StatusBadRequest, "At least one ID document (Aadhar/Passport/Driving License) is required")
-		return
-	}
-
-	tx, err := db.Begin()
+// createCustomer validates the body, assigns a customer_id and caches the
+// new customer.
+func (s *server) createCustomer(w http.ResponseWriter, r *http.Request) {
+	shape, err := newResponseShape(r)
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to start transaction: %v", err))
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
-	defer tx.Rollback()
 
-	newID, err := generateUniqueID(tx)
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, err.Error())
+	var customer Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
-	customer.CustomerID = newID
-
-	query := `INSERT INTO customers (customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID) 
-              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
-	_, err = tx.Exec(query, customer.CustomerID, customer.Name, customer.Age, customer.Address,
-		customer.PhoneNumber, customer.Email, customer.PassportID, customer.AadharID, customer.DrivingLicenseID)
-
-	if err != nil {
-		if strings.Contains(err.Error(), "Duplicate entry") {
-			respondWithError(w, http.StatusConflict, "ID document already exists in database")
-			return
-		}
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Database error: %v", err))
+	var verr *ValidationError
+	if errors.As(validateCustomer(&customer), &verr) {
+		respondWithValidationError(w, verr)
 		return
 	}
 
-	if err := tx.Commit(); err != nil {
-		log.Printf("Failed to commit transaction: %v", err)
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to commit transaction: %v", err))
+	if err := s.store.CreateCustomer(r.Context(), &customer); err != nil {
+		respondWithStoreError(w, "Failed to create customer", err)
 		return
 	}
 
-	// Fetch the customer again to get the correct created_at timestamp
-	err = db.QueryRow(`SELECT created_at FROM customers WHERE customer_id = ?`, customer.CustomerID).Scan(&customer.CreatedAt)
-	if err != nil {
-		log.Printf("Warning: Failed to fetch created_at after insert: %v", err)
-	}
-
-	cacheCustomer(customer)
+	s.cacheCustomer(customer)
 
+	setCustomerETag(w, shape, customer)
 	respondWithJSON(w, http.StatusCreated, SuccessResponse{
 		Message:  "Customer created successfully",
-		Customer: &customer,
+		Customer: shape.view(customer),
 	})
 }
 
 // getAllCustomers handles GET /api/customers/all (NEW ENDPOINT for 'View All')
-func getAllCustomers(w http.ResponseWriter, r *http.Request) {
-	query := `SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers ORDER BY customer_id DESC`
-	rows, err := db.Query(query)
+// Results are paginated with ?limit= and the opaque ?cursor= values returned
+// in `paging`; see parseCustomerListOptions for sort and filter parameters.
+func (s *server) getAllCustomers(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseCustomerListOptions(r)
 	if err != nil {
-		log.Printf("Database query error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve all customers due to query error")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
-	defer rows.Close()
-
-	customers := []Customer{}
-	for rows.Next() {
-		var customer Customer
-		if err := rows.Scan(
-			&customer.CustomerID, &customer.Name, &customer.Age, &customer.Address,
-			&customer.PhoneNumber, &customer.Email, &customer.PassportID,
-			&customer.AadharID, &customer.DrivingLicenseID, &customer.CreatedAt,
-		); err != nil {
-			log.Printf("Scan error for getAllCustomers: %v", err)
-			continue
-		}
-		customers = append(customers, customer)
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
 	}
 
-	// Check for errors encountered during iteration
-	if err := rows.Err(); err != nil {
-		log.Printf("Rows iteration error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Error reading customer data during iteration")
+	page, err := s.store.ListCustomers(r.Context(), opts)
+	if err != nil {
+		log.Printf("Database query error: %v", err)
+		respondWithStoreError(w, "Failed to retrieve all customers due to query error", err)
 		return
 	}
 
 	// CRITICAL: Respond with the correct SuccessResponse structure containing the 'customers' array.
 	respondWithJSON(w, http.StatusOK, SuccessResponse{
-		Message:   fmt.Sprintf("Successfully retrieved %d customers", len(customers)),
-		Customers: customers, // Uses the json:"customers" tag
+		Message:   fmt.Sprintf("Successfully retrieved %d customers", len(page.Customers)),
+		Customers: shape.views(page.Customers), // Uses the json:"customers" tag
+		Paging:    opts.pageInfo(page),
 	})
 }
 
 // getCustomerByID: ADJUSTED to search by customer_id AND existing ID types
-func getCustomerByID(w http.ResponseWriter, r *http.Request) {
+func (s *server) getCustomerByID(w http.ResponseWriter, r *http.Request) {
 	idType := r.URL.Query().Get("type")
-	idValue := r.URL.Query().Get("value")
+	// Documents are stored in canonical form, so "1234 5678 9012" and
+	// "k1234567" find the same customers as their normalised spellings.
+	idValue := normalizeDocument(idType, r.URL.Query().Get("value"))
 
 	if idType == "" || idValue == "" {
-		respondWithError(w, http.StatusBadRequest, "ID type and value are required")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "ID type and value are required")
 		return
 	}
 
-	// Cache lookup logic
-	cacheKey := fmt.Sprintf("customer:%s:%s", idType, idValue)
-	if item, err := mc.Get(cacheKey); err == nil {
-		var customer Customer
-		if json.Unmarshal(item.Value, &customer) == nil {
-			respondWithJSON(w, http.StatusOK, customer)
+	switch idType {
+	case LookupCustomerID:
+		if _, err := parseCustomerID(idValue); err != nil {
+			respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 			return
 		}
-	}
-
-	var query string
-	switch idType {
-	case "customer_id": // NEW Search Option
-		query = "SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers WHERE customer_id = ?"
-	case "aadhar":
-		query = "SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers WHERE aadharID = ?"
-	case "passport":
-		query = "SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers WHERE passportID = ?"
-	case "driving_license":
-		query = "SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers WHERE drivingLicenseID = ?"
+	case LookupAadhar, LookupPassport, LookupDrivingLicense:
 	default:
-		respondWithError(w, http.StatusBadRequest, "Invalid ID type. Use: customer_id, aadhar, passport, or driving_license")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "Invalid ID type. Use: customer_id, aadhar, passport, or driving_license")
 		return
 	}
 
-	var customer Customer
-	err := db.QueryRow(query, idValue).Scan(
-		&customer.CustomerID, &customer.Name, &customer.Age, &customer.Address,
-		&customer.PhoneNumber, &customer.Email, &customer.PassportID,
-		&customer.AadharID, &customer.DrivingLicenseID, &customer.CreatedAt,
-	)
+	// Masking happens per request, so cache hits are shaped the same way.
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
 
-	if err == sql.ErrNoRows {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+	// The cached JSON carries the version, so cache hits can answer
+	// conditional GETs with 304 too.
+	customer, err := s.lookupCustomer(r.Context(), idType, idValue)
+	if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
 		return
 	} else if err != nil {
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve customer")
+		respondWithStoreError(w, "Failed to retrieve customer", err)
 		return
 	}
 
-	cacheCustomer(customer)
-
-	respondWithJSON(w, http.StatusOK, customer)
+	respondWithCustomer(w, r, shape, customer)
 }
 
-// addProduct: (Unchanged)
-func addProduct(w http.ResponseWriter, r *http.Request) {
+// addProduct handles POST /api/products for a live customer.
+func (s *server) addProduct(w http.ResponseWriter, r *http.Request) {
 	var product Product
 	if err := json.NewDecoder(r.Body).Decode(&product); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
-		return
-	}
-
-	if product.CustomerID <= 0 || product.ProductName == "" || product.Quantity <= 0 || product.Price <= 0 {
-		respondWithError(w, http.StatusBadRequest, "All product fields are required and must be valid")
+		respondWithDecodeError(w, err)
 		return
 	}
 
-	tx, err := db.Begin()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to start transaction")
+	var verr *ValidationError
+	if errors.As(validateProduct(&product), &verr) {
+		respondWithValidationError(w, verr)
 		return
 	}
-	defer tx.Rollback()
-
-	var exists bool
-	err = tx.QueryRow("SELECT EXISTS(SELECT 1 FROM customers WHERE customer_id = ?)", product.CustomerID).Scan(&exists)
-	if err != nil || !exists {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
-		return
-	}
-
-	query := `INSERT INTO products (customer_id, product_name, quantity, price) VALUES (?, ?, ?, ?)`
-	result, err := tx.Exec(query, product.CustomerID, product.ProductName, product.Quantity, product.Price)
 
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to add product")
+	if err := s.store.AddProduct(r.Context(), &product); err != nil {
+		switch {
+		case errors.Is(err, ErrCustomerNotFound):
+			respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		case errors.Is(err, ErrCatalogItemNotFound):
+			respondWithValidationError(w, &ValidationError{Fields: []FieldError{
+				{Field: "sku", Code: CodeUnknown, Message: "is not in the catalog"},
+			}})
+		case errors.Is(err, ErrCatalogItemInactive):
+			respondWithValidationError(w, &ValidationError{Fields: []FieldError{
+				{Field: "sku", Code: CodeInactive, Message: "is no longer sold"},
+			}})
+		default:
+			respondWithStoreError(w, "Failed to add product", err)
+		}
 		return
 	}
 
-	id, _ := result.LastInsertId()
-	product.ProductID = int(id)
-
-	if err := tx.Commit(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to commit product transaction")
-		return
-	}
+	s.invalidateProducts(product.CustomerID)
 
 	respondWithJSON(w, http.StatusCreated, SuccessResponse{
 		Message: "Product added successfully",
+		Product: &product,
 	})
 }
 
-// getProductsByCustomer: (Unchanged)
-func getProductsByCustomer(w http.ResponseWriter, r *http.Request) {
+// getProductsByCustomer lists the customer's live products through the
+// cache, with their totals per currency.
+func (s *server) getProductsByCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	customerID := vars["customer_id"]
-
-	query := `SELECT product_id, customer_id, product_name, quantity, price FROM products WHERE customer_id = ?`
-	rows, err := db.Query(query, customerID)
+	customerID, err := parseCustomerID(vars["customer_id"])
 	if err != nil {
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to retrieve products")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
-	defer rows.Close()
 
-	products := []Product{}
-	for rows.Next() {
-		var product Product
-		if err := rows.Scan(&product.ProductID, &product.CustomerID, &product.ProductName, &product.Quantity, &product.Price); err != nil {
-			log.Printf("Scan error: %v", err)
-			continue
-		}
-		products = append(products, product)
+	products, err := s.customerProducts(w, r, customerID)
+	if err != nil {
+		respondWithStoreError(w, "Failed to retrieve products", err)
+		return
 	}
 
+	totals, err := productTotals(products)
+	if err != nil {
+		respondWithInternalError(w, "Failed to total products", err)
+		return
+	}
 	respondWithJSON(w, http.StatusOK, SuccessResponse{
 		Products: products,
+		Totals:   totals,
 	})
 }
 
-// updateCustomer: (Unchanged logic, uses customer_id from URL)
-func updateCustomer(w http.ResponseWriter, r *http.Request) {
+// updateCustomer: uses customer_id from URL; requires If-Match with the
+// customer's current ETag so concurrent edits cannot silently overwrite each other.
+func (s *server) updateCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["customer_id"]
 
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := parseCustomerID(idStr)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID format")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 
-	var customer Customer
-	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid request payload")
+	expectedVersion, err := parseIfMatch(r)
+	if errors.Is(err, errIfMatchMissing) {
+		respondWithError(w, http.StatusPreconditionRequired, ProblemPreconditionRequired, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 
-	customer.CustomerID = id
-
-	if customer.Name == "" || customer.Age <= 0 || customer.Address == "" {
-		respondWithError(w, http.StatusBadRequest, "Name, age, and address are mandatory")
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 
-	if customer.AadharID == nil && customer.PassportID == nil && customer.DrivingLicenseID == nil {
-		respondWithError(w, http.StatusBadRequest, "At least one ID document is required")
+	var customer Customer
+	if err := json.NewDecoder(r.Body).Decode(&customer); err != nil {
+		respondWithDecodeError(w, err)
 		return
 	}
 
-	query := `UPDATE customers SET 
-                name = ?, age = ?, address = ?, phoneNumber = ?, 
-                email = ?, passportID = ?, aadharID = ?, drivingLicenseID = ? 
-              WHERE customer_id = ?`
-
-	result, err := db.Exec(query,
-		customer.Name, customer.Age, customer.Address, customer.PhoneNumber,
-		customer.Email, customer.PassportID, customer.AadharID, customer.DrivingLicenseID,
-		customer.CustomerID)
+	customer.CustomerID = id
 
-	if err != nil {
-		if strings.Contains(err.Error(), "Duplicate entry") {
-			respondWithError(w, http.StatusConflict, "Updated ID document already exists with another customer")
-			return
-		}
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to update customer")
+	var verr *ValidationError
+	if errors.As(validateCustomer(&customer), &verr) {
+		respondWithValidationError(w, verr)
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+	updatedCustomer, err := s.store.UpdateCustomer(r.Context(), customer, expectedVersion)
+	if errors.Is(err, ErrVersionMismatch) {
+		respondWithVersionMismatch(w, shape, updatedCustomer)
 		return
-	}
-
-	var updatedCustomer Customer
-	fetchQuery := "SELECT customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at FROM customers WHERE customer_id = ?"
-	err = db.QueryRow(fetchQuery, customer.CustomerID).Scan(
-		&updatedCustomer.CustomerID, &updatedCustomer.Name, &updatedCustomer.Age, &updatedCustomer.Address,
-		&updatedCustomer.PhoneNumber, &updatedCustomer.Email, &updatedCustomer.PassportID,
-		&updatedCustomer.AadharID, &updatedCustomer.DrivingLicenseID, &updatedCustomer.CreatedAt,
-	)
-	if err != nil {
-		log.Printf("Failed to re-fetch customer data after update: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Customer updated, but failed to retrieve latest data")
+	} else if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to update customer", err)
 		return
 	}
 
-	deleteCustomerCache(customer.CustomerID)
-	cacheCustomer(updatedCustomer)
+	// Overwriting the entry is enough: pointers from documents the update
+	// changed no longer match it and are dropped on their next read.
+	s.cacheCustomer(updatedCustomer)
 
-	respondWithJSON(w, http.StatusOK, updatedCustomer)
+	setCustomerETag(w, shape, updatedCustomer)
+	respondWithJSON(w, http.StatusOK, shape.view(updatedCustomer))
 }
 
-// deleteCustomer: (Unchanged logic, uses customer_id from URL and transaction)
-func deleteCustomer(w http.ResponseWriter, r *http.Request) {
+// deleteCustomer soft-deletes the customer with its products and orders;
+// they stay in the database until the purger removes them (see purge.go).
+func (s *server) deleteCustomer(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	idStr := vars["customer_id"]
 
-	id, err := strconv.ParseInt(idStr, 10, 64)
+	id, err := parseCustomerID(idStr)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID format")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 
-	// Must delete cache BEFORE beginning the transaction, but we need the current IDs for the cache keys
-	// Perform lookup for cache invalidation before deletion
-	var aadharID, passportID, drivingLicenseID sql.NullString
-	err = db.QueryRow("SELECT aadharID, passportID, drivingLicenseID FROM customers WHERE customer_id = ?", id).Scan(
-		&aadharID, &passportID, &drivingLicenseID)
-
-	if err == sql.ErrNoRows {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+	// The store returns the row as it was before deletion so we know every
+	// cache key to clear.
+	deleted, err := s.store.DeleteCustomer(r.Context(), id)
+	if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
 		return
 	} else if err != nil {
-		log.Printf("Cache lookup pre-delete failed: %v", err)
-		// Continue with deletion, but log the cache failure
+		respondWithStoreError(w, "Failed to delete customer", err)
+		return
 	}
 
-	// Start transaction for atomic deletion
-	tx, err := db.Begin()
+	s.invalidateCustomer(deleted)
+
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Customer ID %d and associated products deleted successfully", id),
+	})
+}
+
+// restoreCustomer handles POST /api/customers/{customer_id}/restore, undoing a
+// soft delete (and bringing back the products deleted with it) before the
+// purger removes the row for good.
+func (s *server) restoreCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := parseCustomerID(mux.Vars(r)["customer_id"])
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to start transaction")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
-	defer tx.Rollback()
 
-	// 1. Delete customer (assuming ON DELETE CASCADE handles products)
-	result, err := tx.Exec("DELETE FROM customers WHERE customer_id = ?", id)
+	shape, err := newResponseShape(r)
 	if err != nil {
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete customer")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		respondWithError(w, http.StatusNotFound, "Customer not found")
+	restored, err := s.store.RestoreCustomer(r.Context(), id)
+	if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found (it may already have been purged)")
 		return
-	}
-
-	if err := tx.Commit(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to commit delete transaction")
+	} else if errors.Is(err, ErrCustomerNotDeleted) {
+		respondWithError(w, http.StatusConflict, ProblemNotDeleted, "Customer is not deleted")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to restore customer", err)
 		return
 	}
 
-	// 2. Clear cache using the IDs fetched BEFORE deletion
-	deleteCustomerCacheFromIDs(id, aadharID, passportID, drivingLicenseID)
+	s.cacheCustomer(restored)
+	s.invalidateProducts(restored.CustomerID)
+	setCustomerETag(w, shape, restored)
 
 	respondWithJSON(w, http.StatusOK, SuccessResponse{
-		Message: fmt.Sprintf("Customer ID %d and associated products deleted successfully", id),
+		Message:  fmt.Sprintf("Customer ID %d and associated products restored successfully", id),
+		Customer: shape.view(restored),
 	})
 }
 
-// deleteProduct: (Unchanged)
-func deleteProduct(w http.ResponseWriter, r *http.Request) {
+// deleteProduct removes one product of the customer.
+func (s *server) deleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	customerIDStr := vars["customer_id"]
 	productIDStr := vars["product_id"]
 
-	customerID, err := strconv.ParseInt(customerIDStr, 10, 64)
+	customerID, err := parseCustomerID(customerIDStr)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid customer ID format")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
 		return
 	}
 	productID, err := strconv.Atoi(productIDStr)
 	if err != nil {
-		respondWithError(w, http.StatusBadRequest, "Invalid product ID format")
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "Invalid product ID format")
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM products WHERE customer_id = ? AND product_id = ?", customerID, productID)
-
-	if err != nil {
-		log.Printf("Database error: %v", err)
-		respondWithError(w, http.StatusInternalServerError, "Failed to delete product")
+	err = s.store.DeleteProduct(r.Context(), customerID, productID)
+	if errors.Is(err, ErrProductNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Product not found for the given customer")
 		return
-	}
-
-	rowsAffected, _ := result.RowsAffected()
-	if rowsAffected == 0 {
-		respondWithError(w, http.StatusNotFound, "Product not found for the given customer")
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to delete product", err)
 		return
 	}
 
+	s.invalidateProducts(customerID)
+
 	respondWithJSON(w, http.StatusOK, SuccessResponse{
 		Message: fmt.Sprintf("Product ID %d for Customer ID %d deleted successfully", productID, customerID),
 	})
 }
 
-// flushData: (Unchanged)
-func flushData(w http.ResponseWriter, r *http.Request) {
-	tx, err := db.Begin()
-	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to start transaction")
+// flushData removes every customer, product and order and clears the cache.
+func (s *server) flushData(w http.ResponseWriter, r *http.Request) {
+	if err := s.store.Flush(r.Context()); err != nil {
+		log.Printf("Flush error: %v", err)
+		respondWithStoreError(w, "Failed to flush data", err)
 		return
 	}
-	defer tx.Rollback()
 
-	if _, err := tx.Exec("SET FOREIGN_KEY_CHECKS=0"); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to disable FK checks: %v", err))
-		return
-	}
+	// A failed flush is logged by the cache; stale entries expire with CACHE_TTL.
+	s.cache.Flush()
 
-	if _, err := tx.Exec("TRUNCATE TABLE products"); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to truncate products: %v", err))
-		return
-	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: "All customer and product data successfully flushed.",
+	})
+}
 
-	if _, err := tx.Exec("TRUNCATE TABLE customers"); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to truncate customers: %v", err))
-		return
-	}
+// --- Cache Functions ---
 
-	if _, err := tx.Exec("SET FOREIGN_KEY_CHECKS=1"); err != nil {
-		respondWithError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to re-enable FK checks: %v", err))
-		return
-	}
+// Each cached customer is one entry under its customer_id key. The keys for
+// its ID documents hold a customerPointer to that entry, so a customer's data
+// is cached once and a write only has to replace that one entry.
 
-	if err := tx.Commit(); err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Failed to commit flush transaction")
-		return
+// customerCacheKey builds the cache key for a lookup. ID documents appear
+// only as their keyed hash, so document numbers never reach the cache.
+func (s *server) customerCacheKey(lookupType, value string) string {
+	if lookupType != LookupCustomerID {
+		value = s.pii.hash(lookupType, value)
 	}
+	return fmt.Sprintf("customer:%s:%s", lookupType, value)
+}
 
-	if err := mc.FlushAll(); err != nil {
-		log.Printf("Warning: Failed to flush Memcached: %v", err)
-	}
+func (s *server) customerEntryKey(customerID int64) string {
+	return s.customerCacheKey(LookupCustomerID, strconv.FormatInt(customerID, 10))
+}
 
-	respondWithJSON(w, http.StatusOK, SuccessResponse{
-		Message: "All customer and product data successfully flushed.",
-	})
+// customerPointer is the value cached under an ID document key. Version is
+// the customer's version when the pointer was written.
+type customerPointer struct {
+	CustomerID int64 `json:"customer_id"`
+	Version    int   `json:"version"`
 }
 
-// --- Cache Functions ---
+// cachedCustomer answers a lookup from the cache. A pointer whose version
+// differs from the entry's may predate a change of that document; it is
+// only followed if the entry still carries the document, and dropped
+// otherwise.
+func (s *server) cachedCustomer(lookupType, value string) (Customer, bool) {
+	if lookupType == LookupCustomerID {
+		id, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Customer{}, false
+		}
+		return s.cachedCustomerEntry(id)
+	}
 
-// Helper function to delete cache using known IDs
-func deleteCustomerCacheFromIDs(customerID int64, aadharID, passportID, drivingLicenseID sql.NullString) {
-	if aadharID.Valid {
-		mc.Delete(fmt.Sprintf("customer:aadhar:%s", aadharID.String))
+	key := s.customerCacheKey(lookupType, value)
+	data, err := s.cache.Get(key)
+	if err != nil {
+		return Customer{}, false
+	}
+	var ptr customerPointer
+	if json.Unmarshal(data, &ptr) != nil {
+		return Customer{}, false
 	}
-	if passportID.Valid {
-		mc.Delete(fmt.Sprintf("customer:passport:%s", passportID.String))
+	customer, ok := s.cachedCustomerEntry(ptr.CustomerID)
+	if !ok {
+		return Customer{}, false
 	}
-	if drivingLicenseID.Valid {
-		mc.Delete(fmt.Sprintf("customer:driving_license:%s", drivingLicenseID.String))
+	if ptr.Version != customer.Version {
+		if doc := *customerDocuments(&customer)[lookupType]; doc == nil || *doc != value {
+			s.cache.Delete(key)
+			return Customer{}, false
+		}
+		s.cachePointer(key, customer)
 	}
-	mc.Delete(fmt.Sprintf("customer:customer_id:%d", customerID))
+	return customer, true
 }
 
-// deleteCustomerCache: Fetches IDs and invalidates cache
-func deleteCustomerCache(customerID int64) {
-	var aadharID, passportID, drivingLicenseID sql.NullString
-
-	err := db.QueryRow("SELECT aadharID, passportID, drivingLicenseID FROM customers WHERE customer_id = ?", customerID).Scan(
-		&aadharID, &passportID, &drivingLicenseID)
+// lookupCustomer finds a customer through the cache. Concurrent misses for
+// the same key share one query, so an expired popular entry does not send a
+// burst of requests to the database. The query outlives a caller that gives
+// up, since others may be waiting.
+func (s *server) lookupCustomer(ctx context.Context, lookupType, value string) (Customer, error) {
+	if customer, ok := s.cachedCustomer(lookupType, value); ok {
+		return customer, nil
+	}
+	ctx = context.WithoutCancel(ctx)
+	found, err := s.lookups.Do(s.customerCacheKey(lookupType, value), func() (interface{}, error) {
+		customer, err := s.store.GetCustomer(ctx, lookupType, value)
+		if err == nil {
+			s.cacheCustomer(customer)
+		}
+		return customer, err
+	})
+	customer, _ := found.(Customer)
+	return customer, err
+}
 
-	if err == sql.ErrNoRows {
-		return
-	} else if err != nil {
-		log.Printf("Cache deletion lookup failed for ID %d: %v", customerID, err)
-		return
+func (s *server) cachedCustomerEntry(customerID int64) (Customer, bool) {
+	cached, err := s.cache.Get(s.customerEntryKey(customerID))
+	if err != nil {
+		return Customer{}, false
 	}
-
-	deleteCustomerCacheFromIDs(customerID, aadharID, passportID, drivingLicenseID)
+	var customer Customer
+	data, err := s.pii.openJSON(cached)
+	if err != nil || json.Unmarshal(data, &customer) != nil {
+		return Customer{}, false
+	}
+	return customer, true
 }
 
-// cacheCustomer: Caches by all ID types including customer_id
-func cacheCustomer(customer Customer) {
+// cacheCustomer stores the customer's entry and a pointer for each of its ID
+// documents.
+func (s *server) cacheCustomer(customer Customer) {
 	data, err := json.Marshal(customer)
 	if err != nil {
 		return
 	}
+	// The cached copy is sealed like the database row.
+	s.cache.Set(s.customerEntryKey(customer.CustomerID), s.pii.sealJSON(data), s.cacheTTL)
+
+	for lookupType, doc := range customerDocuments(&customer) {
+		if *doc != nil {
+			s.cachePointer(s.customerCacheKey(lookupType, **doc), customer)
+		}
+	}
+}
+
+func (s *server) cachePointer(key string, customer Customer) {
+	data, _ := json.Marshal(customerPointer{CustomerID: customer.CustomerID, Version: customer.Version})
+	s.cache.Set(key, data, s.cacheTTL)
+}
+
+// invalidateCustomer removes the customer's entry, its product list and the
+// pointers for the documents it had, as known to the caller.
+func (s *server) invalidateCustomer(customer Customer) {
+	s.cache.Delete(s.customerEntryKey(customer.CustomerID))
+	s.invalidateProducts(customer.CustomerID)
+	for lookupType, doc := range customerDocuments(&customer) {
+		if *doc != nil {
+			s.cache.Delete(s.customerCacheKey(lookupType, **doc))
+		}
+	}
+}
 
-	const cacheExpiration = 3600 // 1 hour TTL
+// productsCacheKey holds the customer's product list as JSON. Product
+// fields are not personal data, so the list is cached unsealed.
+func productsCacheKey(customerID int64) string {
+	return fmt.Sprintf("products:%d", customerID)
+}
+
+// cacheBypassHeader, sent as "Cache-Control: no-cache", makes a read skip the
+// cache and refresh it from the database. The X-Cache response header says
+// whether a read was a HIT, MISS or BYPASS.
+const cacheBypassHeader = "Cache-Control"
+
+func cacheBypassed(r *http.Request) bool {
+	return strings.Contains(r.Header.Get(cacheBypassHeader), "no-cache")
+}
 
-	// Cache by ID documents
-	if customer.AadharID != nil {
-		mc.Set(&memcache.Item{
-			Key:        fmt.Sprintf("customer:aadhar:%s", *customer.AadharID),
-			Value:      data,
-			Expiration: cacheExpiration,
-		})
+// customerProducts lists the customer's products through the cache.
+func (s *server) customerProducts(w http.ResponseWriter, r *http.Request, customerID int64) ([]Product, error) {
+	key := productsCacheKey(customerID)
+	status := "BYPASS"
+	if !cacheBypassed(r) {
+		status = "MISS"
+		if cached, err := s.cache.Get(key); err == nil {
+			var products []Product
+			if json.Unmarshal(cached, &products) == nil {
+				w.Header().Set("X-Cache", "HIT")
+				return products, nil
+			}
+		}
 	}
-	if customer.PassportID != nil {
-		mc.Set(&memcache.Item{
-			Key:        fmt.Sprintf("customer:passport:%s", *customer.PassportID),
-			Value:      data,
-			Expiration: cacheExpiration,
-		})
+
+	products, err := s.store.ListProducts(r.Context(), customerID)
+	if err != nil {
+		return nil, err
 	}
-	if customer.DrivingLicenseID != nil {
-		mc.Set(&memcache.Item{
-			Key:        fmt.Sprintf("customer:driving_license:%s", *customer.DrivingLicenseID),
-			Value:      data,
-			Expiration: cacheExpiration,
-		})
+	if data, err := json.Marshal(products); err == nil {
+		s.cache.Set(key, data, s.cacheTTL)
 	}
+	w.Header().Set("X-Cache", status)
+	return products, nil
+}
 
-	// Cache by CustomerID for the search tab's primary key lookup
-	mc.Set(&memcache.Item{
-		Key:        fmt.Sprintf("customer:customer_id:%d", customer.CustomerID),
-		Value:      data,
-		Expiration: cacheExpiration,
+// invalidateProducts drops the cached product list and summary of the
+// customer.
+func (s *server) invalidateProducts(customerID int64) {
+	s.cache.Delete(productsCacheKey(customerID))
+	s.cache.Delete(summaryCacheKey(customerID))
+}
+
+// getCacheStats handles GET /api/cache/stats with the hit, miss and error
+// counts of each cache tier since startup.
+func (s *server) getCacheStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Cache: &CacheReport{Caches: cacheStats(s.cache), Coalesced: s.lookups.coalesced.Load()},
 	})
 }
 
@@ -678,10 +721,6 @@ func healthCheck(w http.ResponseWriter, r *http.Request) {
 	respondWithJSON(w, http.StatusOK, map[string]string{"status": "healthy"})
 }
 
-func respondWithError(w http.ResponseWriter, code int, message string) {
-	respondWithJSON(w, code, ErrorResponse{Error: message})
-}
-
 func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	response, _ := json.Marshal(payload)
 	w.Header().Set("Content-Type", "application/json")
@@ -689,46 +728,171 @@ func respondWithJSON(w http.ResponseWriter, code int, payload interface{}) {
 	w.Write(response)
 }
 
-func main() {
-	if err := initDB(); err != nil {
-		log.Fatal("Failed to connect to database:", err)
-	}
-	defer db.Close()
-
-	initMemcached()
-
+// routes builds the API router wrapped in the CORS handler.
+func (s *server) routes() http.Handler {
 	router := mux.NewRouter()
+	router.Use(requestIDMiddleware)
+	router.NotFoundHandler = requestIDMiddleware(http.HandlerFunc(notFoundHandler))
+	router.MethodNotAllowedHandler = requestIDMiddleware(http.HandlerFunc(methodNotAllowedHandler))
 
 	// Health Check
 	router.HandleFunc("/api/health", healthCheck).Methods("GET")
 
 	// Customer Endpoints
-	router.HandleFunc("/api/customers", createCustomer).Methods("POST")
+	router.HandleFunc("/api/customers", s.require(RoleOperator, s.createCustomer)).Methods("POST")
 	// ✅ NEW ROUTE: Get all customers for the 'View All' tab
-	router.HandleFunc("/api/customers/all", getAllCustomers).Methods("GET")
+	router.HandleFunc("/api/customers/all", s.require(RoleViewer, s.getAllCustomers)).Methods("GET")
 	// ✅ ADJUSTED ROUTE: Search handles customer_id, aadhar, passport, or driving_license
-	router.HandleFunc("/api/customers/search", getCustomerByID).Methods("GET")
+	router.HandleFunc("/api/customers/search", s.require(RoleViewer, s.getCustomerByID)).Methods("GET")
+	// Free-text search on name, address, email and phone number
+	router.HandleFunc("/api/customers/find", s.require(RoleViewer, s.searchCustomers)).Methods("GET")
 	// Existing routes using customer_id
-	router.HandleFunc("/api/customers/{customer_id}", updateCustomer).Methods("PUT")
-	router.HandleFunc("/api/customers/{customer_id}", deleteCustomer).Methods("DELETE")
+	router.HandleFunc("/api/customers/{customer_id}", s.require(RoleOperator, s.updateCustomer)).Methods("PUT")
+	// Partial update with an RFC 7396 merge patch
+	router.HandleFunc("/api/customers/{customer_id}", s.require(RoleOperator, s.patchCustomer)).Methods("PATCH")
+	router.HandleFunc("/api/customers/{customer_id}", s.require(RoleAdmin, s.deleteCustomer)).Methods("DELETE")
+	router.HandleFunc("/api/customers/{customer_id}/restore", s.require(RoleAdmin, s.restoreCustomer)).Methods("POST")
+	// Bulk import from CSV or NDJSON, with ?dry_run=true
+	router.HandleFunc("/api/customers/import", s.require(RoleOperator, s.importCustomersHandler)).Methods("POST")
+	// Streaming CSV/NDJSON/Parquet export with the listing filters
+	router.HandleFunc("/api/customers/export", s.require(RoleOperator, s.exportCustomersHandler)).Methods("GET")
+	// Audit trail of every mutation touching the customer or its products
+	router.HandleFunc("/api/customers/{customer_id}/history", s.require(RoleOperator, s.getCustomerHistory)).Methods("GET")
+	// The customer with product count, quantity, spend, purchase dates and top products
+	router.HandleFunc("/api/customers/{customer_id}/summary", s.require(RoleViewer, s.getCustomerSummary)).Methods("GET")
 
 	// Product Endpoints
-	router.HandleFunc("/api/products", addProduct).Methods("POST")
-	router.HandleFunc("/api/products/{customer_id}", getProductsByCustomer).Methods("GET")
-	router.HandleFunc("/api/products/{customer_id}/{product_id}", deleteProduct).Methods("DELETE")
-
-	// Utility/Maintenance Endpoint
-	router.HandleFunc("/api/flush", flushData).Methods("POST")
-
-	// CORS
-	handler := cors.New(cors.Options{
-		AllowedOrigins:   []string{"*"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
-		AllowCredentials: true,
+	router.HandleFunc("/api/products", s.require(RoleOperator, s.addProduct)).Methods("POST")
+	router.HandleFunc("/api/products/{customer_id}", s.require(RoleViewer, s.getProductsByCustomer)).Methods("GET")
+	router.HandleFunc("/api/products/{customer_id}/{product_id}", s.require(RoleOperator, s.updateProduct)).Methods("PUT")
+	router.HandleFunc("/api/products/{customer_id}/{product_id}", s.require(RoleOperator, s.patchProduct)).Methods("PATCH")
+	router.HandleFunc("/api/products/{customer_id}/{product_id}", s.require(RoleOperator, s.deleteProduct)).Methods("DELETE")
+	// Atomically change the quantity by {"delta": n}; refuses to go below zero
+	router.HandleFunc("/api/products/{customer_id}/{product_id}/adjust", s.require(RoleOperator, s.adjustProductQuantity)).Methods("POST")
+
+	// Catalog Endpoints
+	router.HandleFunc("/api/catalog", s.require(RoleViewer, s.listCatalog)).Methods("GET")
+	router.HandleFunc("/api/catalog", s.require(RoleAdmin, s.createCatalogItem)).Methods("POST")
+	// Match free-text products to catalog items by name, with ?dry_run=true
+	router.HandleFunc("/api/catalog/link-products", s.require(RoleAdmin, s.linkProductsToCatalog)).Methods("POST")
+	router.HandleFunc("/api/catalog/{sku}", s.require(RoleViewer, s.getCatalogItem)).Methods("GET")
+	router.HandleFunc("/api/catalog/{sku}", s.require(RoleAdmin, s.updateCatalogItem)).Methods("PUT")
+	router.HandleFunc("/api/catalog/{sku}", s.require(RoleAdmin, s.deleteCatalogItem)).Methods("DELETE")
+
+	// Order Endpoints
+	router.HandleFunc("/api/orders", s.require(RoleOperator, s.createOrder)).Methods("POST")
+	router.HandleFunc("/api/orders/{customer_id}", s.require(RoleViewer, s.listOrders)).Methods("GET")
+	router.HandleFunc("/api/orders/{customer_id}/{order_id}", s.require(RoleViewer, s.getOrder)).Methods("GET")
+	// Move an order along draft -> placed -> paid -> shipped, or cancel it, with {"status": "..."}
+	router.HandleFunc("/api/orders/{customer_id}/{order_id}/status", s.require(RoleOperator, s.transitionOrder)).Methods("POST")
+
+	// Utility/Maintenance Endpoints
+	router.HandleFunc("/api/flush", s.require(RoleAdmin, s.flushData)).Methods("POST")
+	router.HandleFunc("/api/cache/stats", s.require(RoleAdmin, s.getCacheStats)).Methods("GET")
+
+	// CORS: only the configured origins. Credentials travel in headers, not
+	// cookies, so the browser never needs to send cookies.
+	return cors.New(cors.Options{
+		AllowedOrigins: s.corsOrigins,
+		AllowedMethods: []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders: []string{"Content-Type", "Authorization", "X-API-Key", requestIDHeader, "If-Match", "If-None-Match", idempotencyKeyHeader, cacheBypassHeader},
+		ExposedHeaders: []string{requestIDHeader, "ETag", idempotentReplayedHeader, "X-Cache"},
 	}).Handler(router)
+}
+
+// openStoreFromEnv builds the Store selected by STORE_BACKEND together with
+// the key ring protecting ID documents. The returned func releases its
+// resources.
+func openStoreFromEnv() (Store, *piiKeyRing, func()) {
+	pii, err := piiKeyRingFromEnv()
+	if err != nil {
+		log.Fatal("Invalid PII encryption configuration: ", err)
+	}
+	checkCustomerIDs = getEnv("CUSTOMER_ID_CHECK_DIGIT", "false") == "true"
+	defaultCurrency = strings.ToUpper(getEnv("DEFAULT_CURRENCY", defaultCurrency))
+	if _, known := currencyDigits[defaultCurrency]; !known {
+		log.Fatalf("Unknown DEFAULT_CURRENCY %q", defaultCurrency)
+	}
+
+	// STORE_BACKEND=memory runs the API without MariaDB (demos, local tests).
+	switch backend := getEnv("STORE_BACKEND", "mysql"); backend {
+	case "mysql":
+		if pii == nil {
+			log.Fatal("PII_ENCRYPTION_KEYS and PII_HASH_KEY are required to store ID documents in MySQL")
+		}
+		db, err := initDB()
+		if err != nil {
+			log.Fatal("Failed to connect to database:", err)
+		}
+		store := newMySQLStore(db, pii)
+		if store.ids, err = idGeneratorFromEnv(store.allocateIDs); err != nil {
+			log.Fatal("Invalid customer ID configuration: ", err)
+		}
+		return store, pii, func() { db.Close() }
+	case "memory":
+		log.Println("Using in-memory store; data will not survive a restart.")
+		if pii == nil {
+			pii = newEphemeralPIIKeyRing()
+		}
+		store := newMemoryStore()
+		if store.ids, err = idGeneratorFromEnv(memorySequence()); err != nil {
+			log.Fatal("Invalid customer ID configuration: ", err)
+		}
+		return store, pii, func() {}
+	default:
+		log.Fatalf("Unknown STORE_BACKEND %q (use mysql or memory)", backend)
+		return nil, nil, nil
+	}
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "import":
+			runImportCommand(os.Args[2:])
+			return
+		case "export":
+			runExportCommand(os.Args[2:])
+			return
+		case "rekey-pii":
+			runRekeyCommand(os.Args[2:])
+			return
+		case "link-catalog":
+			runLinkCatalogCommand(os.Args[2:])
+			return
+		case "migrate-money":
+			runMigrateMoneyCommand(os.Args[2:])
+			return
+		}
+	}
+
+	store, pii, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	auth, err := newAuthenticatorFromEnv()
+	if err != nil {
+		log.Fatal("Failed to configure authentication: ", err)
+	}
+
+	cache, cacheTTL, err := cacheFromEnv()
+	if err != nil {
+		log.Fatal("Invalid cache configuration: ", err)
+	}
+	s := newServer(store, cache, auth, pii)
+	s.cacheTTL = cacheTTL
+	if s.corsOrigins, err = corsOriginsFromEnv(); err != nil {
+		log.Fatal("Invalid CORS configuration: ", err)
+	}
+
+	retention, interval, err := purgeConfigFromEnv()
+	if err != nil {
+		log.Fatal("Invalid purge configuration: ", err)
+	}
+	if interval > 0 {
+		go s.runPurger(context.Background(), retention, interval)
+	}
 
 	port := getEnv("PORT", "8080")
 	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, handler))
+	log.Fatal(http.ListenAndServe(":"+port, s.routes()))
 }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestServer runs the API on the in-memory store and LRU cache with
+// authentication disabled, so every request is an unmasked admin.
+func newTestServer(t *testing.T) http.Handler {
+	t.Helper()
+	return newServer(newMemoryStore(), newLRUCache(100), openAccess{}, newEphemeralPIIKeyRing()).routes()
+}
+
+// call sends a request with an optional JSON body and header name/value pairs.
+func call(t *testing.T, h http.Handler, method, path, body string, headers ...string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, want int) {
+	t.Helper()
+	if rec.Code != want {
+		t.Fatalf("status = %d, want %d; body: %s", rec.Code, want, rec.Body.String())
+	}
+}
+
+// createTestCustomer creates a customer holding passport and returns it.
+func createTestCustomer(t *testing.T, h http.Handler, name, passport string) Customer {
+	t.Helper()
+	rec := call(t, h, "POST", "/api/customers",
+		fmt.Sprintf(`{"name": %q, "age": 30, "address": "12 MG Road", "passport_id": %q}`, name, passport))
+	expectStatus(t, rec, http.StatusCreated)
+	var resp struct {
+		Customer Customer `json:"customer"`
+	}
+	decode(t, rec, &resp)
+	return resp.Customer
+}
+
+func customerPath(id int64) string {
+	return "/api/customers/" + strconv.FormatInt(id, 10)
+}
+
+func getCustomerPath(id int64) string {
+	return "/api/customers/search?type=customer_id&value=" + strconv.FormatInt(id, 10)
+}
+
+func TestCreateAndGetCustomer(t *testing.T) {
+	h := newTestServer(t)
+	created := createTestCustomer(t, h, "Asha Rao", "K1234567")
+	if !validCustomerID(created.CustomerID) {
+		t.Errorf("customer_id %d has no valid check digit", created.CustomerID)
+	}
+	if created.Version != 1 {
+		t.Errorf("version = %d, want 1", created.Version)
+	}
+
+	rec := call(t, h, "GET", getCustomerPath(created.CustomerID), "")
+	expectStatus(t, rec, http.StatusOK)
+	var got Customer
+	decode(t, rec, &got)
+	if got.Name != "Asha Rao" || got.PassportID == nil || *got.PassportID != "K1234567" {
+		t.Errorf("got %+v, want the created customer", got)
+	}
+
+	rec = call(t, h, "GET", "/api/customers/search?type=passport&value=K1234567", "")
+	expectStatus(t, rec, http.StatusOK)
+	decode(t, rec, &got)
+	if got.CustomerID != created.CustomerID {
+		t.Errorf("passport lookup found %d, want %d", got.CustomerID, created.CustomerID)
+	}
+}
+
+func TestCreateCustomerRejectsInvalidAndDuplicate(t *testing.T) {
+	h := newTestServer(t)
+
+	rec := call(t, h, "POST", "/api/customers", `{"name": "", "age": 30, "address": "12 MG Road"}`)
+	expectStatus(t, rec, http.StatusBadRequest)
+	var problem Problem
+	decode(t, rec, &problem)
+	if problem.Code != ProblemValidationFailed || len(problem.Violations) == 0 {
+		t.Errorf("got %+v, want validation_failed with violations", problem)
+	}
+
+	createTestCustomer(t, h, "Asha Rao", "K1234567")
+	rec = call(t, h, "POST", "/api/customers", `{"name": "Ravi Rao", "age": 40, "address": "1 Park St", "passport_id": "K1234567"}`)
+	expectStatus(t, rec, http.StatusConflict)
+	decode(t, rec, &problem)
+	if problem.Code != ProblemDuplicateIDDocument {
+		t.Errorf("code = %q, want %q", problem.Code, ProblemDuplicateIDDocument)
+	}
+}
+
+func TestGetCustomerNotFound(t *testing.T) {
+	h := newTestServer(t)
+	expectStatus(t, call(t, h, "GET", getCustomerPath(withCheckDigit(123456789)), ""), http.StatusNotFound)
+	expectStatus(t, call(t, h, "GET", "/api/customers/search?type=customer_id&value=abc", ""), http.StatusBadRequest)
+}
+
+func TestUpdateCustomerIfMatch(t *testing.T) {
+	h := newTestServer(t)
+	created := createTestCustomer(t, h, "Asha Rao", "K1234567")
+	path := customerPath(created.CustomerID)
+	body := `{"name": "Asha Menon", "age": 31, "address": "12 MG Road", "passport_id": "K1234567"}`
+
+	rec := call(t, h, "GET", getCustomerPath(created.CustomerID), "")
+	expectStatus(t, rec, http.StatusOK)
+	etag := rec.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("ETag = %q, want %q", etag, `"1"`)
+	}
+	expectStatus(t, call(t, h, "GET", getCustomerPath(created.CustomerID), "", "If-None-Match", etag), http.StatusNotModified)
+
+	expectStatus(t, call(t, h, "PUT", path, body), http.StatusPreconditionRequired)
+
+	rec = call(t, h, "PUT", path, body, "If-Match", etag)
+	expectStatus(t, rec, http.StatusOK)
+	var updated Customer
+	decode(t, rec, &updated)
+	if updated.Name != "Asha Menon" || updated.Version != 2 {
+		t.Errorf("got name %q version %d, want Asha Menon version 2", updated.Name, updated.Version)
+	}
+	if got := rec.Header().Get("ETag"); got != `"2"` {
+		t.Errorf("ETag after update = %q, want %q", got, `"2"`)
+	}
+
+	// The first ETag is stale now.
+	rec = call(t, h, "PUT", path, body, "If-Match", etag)
+	expectStatus(t, rec, http.StatusPreconditionFailed)
+	var failed struct {
+		Problem
+		Customer Customer `json:"customer"`
+	}
+	decode(t, rec, &failed)
+	if failed.Code != ProblemVersionMismatch || failed.Customer.Version != 2 {
+		t.Errorf("got code %q version %d, want %q with the current customer", failed.Code, failed.Customer.Version, ProblemVersionMismatch)
+	}
+
+	// The cached entry was replaced, so reads see the update.
+	rec = call(t, h, "GET", getCustomerPath(created.CustomerID), "")
+	expectStatus(t, rec, http.StatusOK)
+	var got Customer
+	decode(t, rec, &got)
+	if got.Name != "Asha Menon" {
+		t.Errorf("name after update = %q, want Asha Menon", got.Name)
+	}
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	h := newTestServer(t)
+	created := createTestCustomer(t, h, "Asha Rao", "K1234567")
+
+	// Fill the cache first, so the delete has to invalidate it.
+	expectStatus(t, call(t, h, "GET", getCustomerPath(created.CustomerID), ""), http.StatusOK)
+	expectStatus(t, call(t, h, "DELETE", customerPath(created.CustomerID), ""), http.StatusOK)
+
+	expectStatus(t, call(t, h, "GET", getCustomerPath(created.CustomerID), ""), http.StatusNotFound)
+	expectStatus(t, call(t, h, "GET", "/api/customers/search?type=passport&value=K1234567", ""), http.StatusNotFound)
+	expectStatus(t, call(t, h, "DELETE", customerPath(created.CustomerID), ""), http.StatusNotFound)
+}
+
+func TestListCustomersPaging(t *testing.T) {
+	h := newTestServer(t)
+	want := make(map[int64]bool)
+	for i := 0; i < 5; i++ {
+		c := createTestCustomer(t, h, fmt.Sprintf("Customer %d", i), fmt.Sprintf("K123456%d", i))
+		want[c.CustomerID] = true
+	}
+
+	seen := make(map[int64]bool)
+	path := "/api/customers/all?limit=2&sort=name"
+	var names []string
+	for pages := 1; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("more than 3 pages for 5 customers")
+		}
+		rec := call(t, h, "GET", path, "")
+		expectStatus(t, rec, http.StatusOK)
+		var resp struct {
+			Customers []Customer `json:"customers"`
+			Paging    PageInfo   `json:"paging"`
+		}
+		decode(t, rec, &resp)
+		if len(resp.Customers) > 2 {
+			t.Fatalf("page %d has %d customers, limit is 2", pages, len(resp.Customers))
+		}
+		for _, c := range resp.Customers {
+			if seen[c.CustomerID] {
+				t.Errorf("customer %d listed twice", c.CustomerID)
+			}
+			seen[c.CustomerID] = true
+			names = append(names, c.Name)
+		}
+		if resp.Paging.NextCursor == "" {
+			break
+		}
+		path = "/api/customers/all?limit=2&sort=name&cursor=" + resp.Paging.NextCursor
+	}
+
+	if len(seen) != len(want) {
+		t.Errorf("listed %d customers, want %d", len(seen), len(want))
+	}
+	for i, name := range names {
+		if want := fmt.Sprintf("Customer %d", i); name != want {
+			t.Errorf("customer %d is %q, want %q", i, name, want)
+		}
+	}
+
+	expectStatus(t, call(t, h, "GET", "/api/customers/all?limit=0", ""), http.StatusBadRequest)
+}
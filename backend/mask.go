@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// --- PII Masking and Field Projection ---
+
+// unmaskedPIIRole is the lowest role that sees full ID document numbers,
+// e-mail addresses and phone numbers in API responses.
+const unmaskedPIIRole = RoleOperator
+
+// customerJSONFields are the names accepted by ?fields=.
+var customerJSONFields = map[string]bool{
+	"customer_id": true, "name": true, "age": true, "address": true,
+	"phone_number": true, "email": true, "passport_id": true, "aadhar_id": true,
+	"driving_license_id": true, "created_at": true, "deleted_at": true, "version": true,
+}
+
+// maskTail replaces every letter and digit except the last four with X,
+// keeping separators so the value's shape stays recognisable.
+func maskTail(v string) string {
+	r := []rune(v)
+	keep := 0
+	for i := len(r) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(r[i]) && !unicode.IsDigit(r[i]) {
+			continue
+		}
+		if keep < 4 && len(r) > 4 {
+			keep++
+			continue
+		}
+		r[i] = 'X'
+	}
+	return string(r)
+}
+
+// maskAadhar renders a 12-digit Aadhar number as XXXX-XXXX-1234.
+func maskAadhar(v string) string {
+	digits := strings.Map(func(r rune) rune {
+		if unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, v)
+	if len(digits) != 12 {
+		return maskTail(v)
+	}
+	return "XXXX-XXXX-" + digits[8:]
+}
+
+// maskEmail keeps the first character of the local part and the domain.
+func maskEmail(v string) string {
+	at := strings.LastIndex(v, "@")
+	if at <= 0 {
+		return maskTail(v)
+	}
+	return v[:1] + "***" + v[at:]
+}
+
+func maskOptional(v *string, mask func(string) string) *string {
+	if v == nil {
+		return nil
+	}
+	masked := mask(*v)
+	return &masked
+}
+
+// maskCustomerPII hides identifying values while keeping enough of each to
+// recognise it: ID documents and phone numbers keep their last four
+// characters, e-mail addresses their first letter and domain.
+func maskCustomerPII(c Customer) Customer {
+	c.AadharID = maskOptional(c.AadharID, maskAadhar)
+	c.PassportID = maskOptional(c.PassportID, maskTail)
+	c.DrivingLicenseID = maskOptional(c.DrivingLicenseID, maskTail)
+	c.PhoneNumber = maskOptional(c.PhoneNumber, maskTail)
+	c.Email = maskOptional(c.Email, maskEmail)
+	return c
+}
+
+// responseShape describes how customers are rendered for one request: masked
+// unless the caller's role has unmaskedPIIRole, and limited to ?fields= when
+// given.
+type responseShape struct {
+	mask   bool
+	fields map[string]bool
+}
+
+// newResponseShape reads the caller's role and the fields= parameter.
+func newResponseShape(r *http.Request) (responseShape, error) {
+	p := principalFrom(r.Context())
+	shape := responseShape{mask: p == nil || !p.Role.allows(unmaskedPIIRole)}
+
+	if v := r.URL.Query().Get("fields"); v != "" {
+		shape.fields = map[string]bool{"customer_id": true}
+		var unknown []string
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			if !customerJSONFields[name] {
+				unknown = append(unknown, name)
+			}
+			shape.fields[name] = true
+		}
+		if len(unknown) > 0 {
+			sort.Strings(unknown)
+			return shape, fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+		}
+	}
+	return shape, nil
+}
+
+// CustomerView is a Customer as rendered for one caller. Build it with
+// responseShape.view so masking can't be skipped.
+type CustomerView struct {
+	customer Customer
+	fields   map[string]bool
+}
+
+func (v CustomerView) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(v.customer)
+	if err != nil || v.fields == nil {
+		return data, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for name := range all {
+		if !v.fields[name] {
+			delete(all, name)
+		}
+	}
+	return json.Marshal(all)
+}
+
+func (s responseShape) view(c Customer) *CustomerView {
+	if s.mask {
+		c = maskCustomerPII(c)
+	}
+	return &CustomerView{customer: c, fields: s.fields}
+}
+
+func (s responseShape) views(customers []Customer) []*CustomerView {
+	views := make([]*CustomerView, len(customers))
+	for i, c := range customers {
+		views[i] = s.view(c)
+	}
+	return views
+}
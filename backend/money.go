@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// --- Money ---
+
+// currencyDigits lists the ISO 4217 currencies accepted by the API with the
+// number of decimal places of their minor unit.
+var currencyDigits = map[string]int{
+	"INR": 2, "USD": 2, "EUR": 2, "GBP": 2, "AED": 2, "AUD": 2, "CAD": 2, "CHF": 2,
+	"CNY": 2, "HKD": 2, "SAR": 2, "SGD": 2, "NZD": 2, "ZAR": 2, "LKR": 2, "NPR": 2,
+	"JPY": 0, "KRW": 0,
+	"BHD": 3, "KWD": 3, "OMR": 3,
+}
+
+// defaultCurrency is assumed for prices sent as a bare number, as clients did
+// before prices carried a currency. Set from DEFAULT_CURRENCY.
+var defaultCurrency = "INR"
+
+// maxMoneyMinor bounds a single price so that multiplying it by any quantity
+// and summing a customer's products is checked rather than silently wrapped.
+const maxMoneyMinor = 1e15
+
+var errMoneyOverflow = errors.New("amount out of range")
+
+// Money is an exact amount in the minor unit of Currency (paise for INR).
+// It is encoded as {"amount": "1200.50", "currency": "INR"}; the amount is a
+// string so clients never see it go through a float.
+//
+// Decoding also accepts the amount as a JSON number, and a bare number or
+// string in place of the object for defaultCurrency. Amounts with more
+// decimal places than the currency has are never rounded: the value keeps the
+// problem in invalid and validateMoney reports it against the field.
+type Money struct {
+	Minor    int64
+	Currency string
+
+	invalid *FieldError
+}
+
+func (m Money) IsZero() bool {
+	return m.Minor == 0 && m.Currency == "" && m.invalid == nil
+}
+
+// Amount formats m in major units with exactly the currency's decimal places.
+func (m Money) Amount() string {
+	digits := currencyDigits[m.Currency]
+	sign, minor := "", m.Minor
+	if minor < 0 {
+		sign, minor = "-", -minor
+	}
+	s := strconv.FormatInt(minor, 10)
+	if digits == 0 {
+		return sign + s
+	}
+	if len(s) <= digits {
+		s = strings.Repeat("0", digits-len(s)+1) + s
+	}
+	return sign + s[:len(s)-digits] + "." + s[len(s)-digits:]
+}
+
+func (m Money) String() string {
+	return m.Amount() + " " + m.Currency
+}
+
+// Times returns m multiplied by n, failing instead of overflowing.
+func (m Money) Times(n int) (Money, error) {
+	if n != 0 && (m.Minor > math.MaxInt64/int64(abs(n)) || m.Minor < math.MinInt64/int64(abs(n))) {
+		return Money{}, errMoneyOverflow
+	}
+	return Money{Minor: m.Minor * int64(n), Currency: m.Currency}, nil
+}
+
+// Plus adds two amounts of the same currency, failing instead of overflowing.
+func (m Money) Plus(o Money) (Money, error) {
+	if m.Currency != o.Currency {
+		return Money{}, fmt.Errorf("cannot add %s to %s", o.Currency, m.Currency)
+	}
+	if (o.Minor > 0 && m.Minor > math.MaxInt64-o.Minor) || (o.Minor < 0 && m.Minor < math.MinInt64-o.Minor) {
+		return Money{}, errMoneyOverflow
+	}
+	return Money{Minor: m.Minor + o.Minor, Currency: m.Currency}, nil
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+type moneyJSON struct {
+	Amount   json.RawMessage `json:"amount"`
+	Currency string          `json:"currency"`
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Amount   string `json:"amount"`
+		Currency string `json:"currency"`
+	}{m.Amount(), m.Currency})
+}
+
+func (m *Money) UnmarshalJSON(data []byte) error {
+	*m = Money{}
+	data = bytes.TrimSpace(data)
+	if string(data) == "null" {
+		return nil
+	}
+
+	raw := moneyJSON{Amount: data, Currency: defaultCurrency}
+	if len(data) > 0 && data[0] == '{' {
+		raw = moneyJSON{}
+		if err := json.Unmarshal(data, &raw); err != nil {
+			return err
+		}
+	}
+	m.Currency = strings.ToUpper(strings.TrimSpace(raw.Currency))
+	digits, known := currencyDigits[m.Currency]
+	if !known {
+		return nil
+	}
+
+	text := string(raw.Amount)
+	if len(raw.Amount) > 0 && raw.Amount[0] == '"' {
+		if err := json.Unmarshal(raw.Amount, &text); err != nil {
+			return err
+		}
+	}
+	m.Minor, m.invalid = parseMinor(strings.TrimSpace(text), digits)
+	return nil
+}
+
+// parseMinor converts a plain decimal string such as "-12.5" into minor units
+// of a currency with the given decimal places. It never rounds; the second
+// result says why text was rejected.
+func parseMinor(text string, digits int) (int64, *FieldError) {
+	if text == "" {
+		return 0, &FieldError{Code: CodeRequired, Message: "is required"}
+	}
+	neg := strings.HasPrefix(text, "-")
+	whole, frac, _ := strings.Cut(strings.TrimPrefix(text, "-"), ".")
+	if whole == "" || strings.Trim(whole, "0123456789") != "" || strings.Trim(frac, "0123456789") != "" {
+		return 0, &FieldError{Code: CodeFormat, Message: "must be a decimal number such as 1200.50"}
+	}
+	if trimmed := strings.TrimRight(frac, "0"); len(trimmed) > digits {
+		return 0, &FieldError{Code: CodeFormat, Message: fmt.Sprintf("must have at most %d decimal places", digits)}
+	}
+	if len(frac) > digits {
+		frac = frac[:digits]
+	}
+	minor, err := strconv.ParseInt(whole+frac+strings.Repeat("0", digits-len(frac)), 10, 64)
+	if err != nil || minor > maxMoneyMinor {
+		return 0, &FieldError{Code: CodeRange, Message: "is too large"}
+	}
+	if neg {
+		minor = -minor
+	}
+	return minor, nil
+}
+
+// moneyFromFloat converts a legacy DOUBLE price. The float is first written as
+// the shortest decimal that reads back as the same float (so 1.005 stays
+// "1.005" instead of 1.00499999...), then rounded half away from zero to the
+// currency's minor unit. exact is false when rounding changed the value.
+func moneyFromFloat(f float64, currency string) (m Money, exact bool, err error) {
+	digits, known := currencyDigits[currency]
+	if !known {
+		return Money{}, false, fmt.Errorf("unknown currency %q", currency)
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f) >= maxMoneyMinor/math.Pow10(digits) {
+		return Money{}, false, fmt.Errorf("%v: %w", f, errMoneyOverflow)
+	}
+	text := strconv.FormatFloat(math.Abs(f), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(text, ".")
+	exact = len(frac) <= digits
+	roundUp := !exact && frac[digits] >= '5'
+	if len(frac) > digits {
+		frac = frac[:digits]
+	}
+	minor, _ := strconv.ParseInt(whole+frac+strings.Repeat("0", digits-len(frac)), 10, 64)
+	if roundUp {
+		minor++
+	}
+	if f < 0 {
+		minor = -minor
+	}
+	return Money{Minor: minor, Currency: currency}, exact, nil
+}
+
+// validateMoney checks an amount that must be strictly positive.
+func validateMoney(errs *ValidationError, field string, m Money) {
+	if _, known := currencyDigits[m.Currency]; !known {
+		if m.Currency == "" {
+			errs.add(field+".currency", CodeRequired, "is required")
+		} else {
+			errs.add(field+".currency", CodeUnknown, "is not a supported ISO 4217 currency")
+		}
+		return
+	}
+	if m.invalid != nil {
+		errs.add(field+".amount", m.invalid.Code, m.invalid.Message)
+	} else if m.Minor <= 0 {
+		errs.add(field+".amount", CodeRange, "must be greater than 0")
+	}
+}
+
+// productTotals sums quantity × price of products per currency, in currency
+// order. Products bought in different currencies are never converted.
+func productTotals(products []Product) ([]Money, error) {
+	byCurrency := make(map[string]Money)
+	for _, p := range products {
+		line, err := p.Price.Times(p.Quantity)
+		if err != nil {
+			return nil, fmt.Errorf("product %d: %w", p.ProductID, err)
+		}
+		total, ok := byCurrency[line.Currency]
+		if !ok {
+			total = Money{Currency: line.Currency}
+		}
+		if byCurrency[line.Currency], err = total.Plus(line); err != nil {
+			return nil, fmt.Errorf("product %d: %w", p.ProductID, err)
+		}
+	}
+	totals := make([]Money, 0, len(byCurrency))
+	for _, total := range byCurrency {
+		totals = append(totals, total)
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Currency < totals[j].Currency })
+	return totals, nil
+}
+
+// --- DOUBLE to minor-unit migration ---
+
+// MoneyChange is a row whose legacy DOUBLE price was not a whole number of
+// minor units and was rounded by moneyFromFloat. Before is nil for a NULL
+// price, which becomes zero.
+type MoneyChange struct {
+	Table  string   `json:"table"`
+	Key    string   `json:"key"`
+	Before *float64 `json:"before"`
+	After  Money    `json:"after"`
+}
+
+// MoneyMigrationReport is the outcome of MigrateMoney. Changed lists every
+// row whose value moved, so it can be reviewed or corrected by hand.
+type MoneyMigrationReport struct {
+	DryRun    bool          `json:"dry_run"`
+	Currency  string        `json:"currency"`
+	Converted int           `json:"converted"`
+	Changed   []MoneyChange `json:"changed"`
+}
+
+// runMigrateMoneyCommand implements `customerDB migrate-money`, which fills in
+// price_minor and currency of rows created while prices were DOUBLE. It
+// prints the report as JSON.
+func runMigrateMoneyCommand(args []string) {
+	fs := flag.NewFlagSet("migrate-money", flag.ExitOnError)
+	currency := fs.String("currency", getEnv("DEFAULT_CURRENCY", defaultCurrency), "ISO 4217 currency of the existing prices")
+	batchSize := fs.Int("batch", 500, "rows per transaction")
+	dryRun := fs.Bool("dry-run", false, "report the conversions without writing")
+	fs.Parse(args)
+
+	*currency = strings.ToUpper(*currency)
+	if _, known := currencyDigits[*currency]; !known {
+		log.Fatalf("Unknown currency %q", *currency)
+	}
+
+	store, _, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	migrator, ok := store.(interface {
+		MigrateMoney(ctx context.Context, currency string, batchSize int, dryRun bool) (MoneyMigrationReport, error)
+	})
+	if !ok {
+		closeStore()
+		log.Fatal("migrate-money only applies to STORE_BACKEND=mysql")
+	}
+	report, err := migrator.MigrateMoney(context.Background(), *currency, *batchSize, *dryRun)
+	if err != nil {
+		closeStore()
+		log.Fatalf("Migration failed after %d rows: %v", report.Converted, err)
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.Encode(report)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseMinor(t *testing.T) {
+	for _, tc := range []struct {
+		text     string
+		digits   int
+		want     int64
+		wantCode string
+	}{
+		{"1200.50", 2, 120050, ""},
+		{"1200.5", 2, 120050, ""},
+		{"1200", 2, 120000, ""},
+		{"1.", 2, 100, ""},
+		{"12.340", 2, 1234, ""}, // trailing zeros lose nothing
+		{"-12.5", 2, -1250, ""},
+		{"0.01", 2, 1, ""},
+		{"5", 0, 5, ""},
+		{"5.000", 0, 5, ""},
+		{"1.234", 3, 1234, ""},
+		{"10000000000000.00", 2, 1e15, ""},
+		{"12.345", 2, 0, CodeFormat}, // never rounded
+		{"5.5", 0, 0, CodeFormat},
+		{".5", 2, 0, CodeFormat},
+		{"+1", 2, 0, CodeFormat},
+		{"1e3", 2, 0, CodeFormat},
+		{"1,000", 2, 0, CodeFormat},
+		{"1.2.3", 2, 0, CodeFormat},
+		{"abc", 2, 0, CodeFormat},
+		{"", 2, 0, CodeRequired},
+		{"10000000000000.01", 2, 0, CodeRange},
+		{"99999999999999999999", 2, 0, CodeRange},
+	} {
+		got, ferr := parseMinor(tc.text, tc.digits)
+		var code string
+		if ferr != nil {
+			code = ferr.Code
+		}
+		if got != tc.want || code != tc.wantCode {
+			t.Errorf("parseMinor(%q, %d) = %d, %q; want %d, %q", tc.text, tc.digits, got, code, tc.want, tc.wantCode)
+		}
+	}
+}
+
+func TestMoneyFromFloat(t *testing.T) {
+	for _, tc := range []struct {
+		f         float64
+		currency  string
+		want      int64
+		wantExact bool
+	}{
+		{1200.5, "INR", 120050, true},
+		{0.1, "INR", 10, true},
+		{0.29, "INR", 29, true}, // 0.29*100 is 28.999999999999996 as a float
+		{1.005, "INR", 101, false},
+		{19.995, "INR", 2000, false}, // the README example
+		{19.994, "INR", 1999, false},
+		{-2.345, "INR", -235, false}, // half away from zero
+		{12, "JPY", 12, true},
+		{12.5, "JPY", 13, false},
+		{1.2345, "KWD", 1235, false},
+		{0, "INR", 0, true},
+	} {
+		m, exact, err := moneyFromFloat(tc.f, tc.currency)
+		if err != nil || m.Minor != tc.want || m.Currency != tc.currency || exact != tc.wantExact {
+			t.Errorf("moneyFromFloat(%v, %s) = %v, %v, %v; want %d, exact %v", tc.f, tc.currency, m.Minor, exact, err, tc.want, tc.wantExact)
+		}
+	}
+}
+
+func TestMoneyFromFloatRejects(t *testing.T) {
+	for _, tc := range []struct {
+		f        float64
+		currency string
+		overflow bool
+	}{
+		{1, "XXX", false},
+		{math.NaN(), "INR", true},
+		{math.Inf(1), "INR", true},
+		{1e13, "INR", true}, // 10^15 paise
+		{-1e13, "INR", true},
+	} {
+		_, _, err := moneyFromFloat(tc.f, tc.currency)
+		if err == nil || errors.Is(err, errMoneyOverflow) != tc.overflow {
+			t.Errorf("moneyFromFloat(%v, %s): err = %v, want overflow %v", tc.f, tc.currency, err, tc.overflow)
+		}
+	}
+}
+
+func TestMoneyJSON(t *testing.T) {
+	for _, tc := range []struct {
+		json string
+		want Money
+	}{
+		{`{"amount": "1200.50", "currency": "INR"}`, Money{Minor: 120050, Currency: "INR"}},
+		{`{"amount": 1200.5, "currency": " usd "}`, Money{Minor: 120050, Currency: "USD"}},
+		{`{"amount": "1.234", "currency": "KWD"}`, Money{Minor: 1234, Currency: "KWD"}},
+		{`1200.5`, Money{Minor: 120050, Currency: defaultCurrency}},
+		{`"99"`, Money{Minor: 9900, Currency: defaultCurrency}},
+	} {
+		var m Money
+		if err := json.Unmarshal([]byte(tc.json), &m); err != nil || m != tc.want {
+			t.Errorf("Unmarshal(%s) = %+v, %v; want %+v", tc.json, m, err, tc.want)
+			continue
+		}
+		data, _ := json.Marshal(m)
+		var back Money
+		if err := json.Unmarshal(data, &back); err != nil || back != m {
+			t.Errorf("%s does not read back as %+v", data, m)
+		}
+	}
+
+	// Too many decimals are kept for validateMoney to report, not rounded.
+	var m Money
+	if err := json.Unmarshal([]byte(`{"amount": "12.345", "currency": "INR"}`), &m); err != nil {
+		t.Fatal(err)
+	}
+	errs := &ValidationError{}
+	validateMoney(errs, "price", m)
+	if len(errs.Fields) != 1 || errs.Fields[0].Field != "price.amount" || errs.Fields[0].Code != CodeFormat {
+		t.Errorf("validateMoney of 12.345 INR = %+v, want invalid_format on price.amount", errs.Fields)
+	}
+}
+
+func TestMoneyAmount(t *testing.T) {
+	for _, tc := range []struct {
+		m    Money
+		want string
+	}{
+		{Money{Minor: 120050, Currency: "INR"}, "1200.50"},
+		{Money{Minor: 5, Currency: "INR"}, "0.05"},
+		{Money{Minor: -5, Currency: "INR"}, "-0.05"},
+		{Money{Minor: 1234, Currency: "KWD"}, "1.234"},
+		{Money{Minor: 12, Currency: "JPY"}, "12"},
+	} {
+		if got := tc.m.Amount(); got != tc.want {
+			t.Errorf("%+v.Amount() = %q, want %q", tc.m, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"regexp"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// --- MySQL Error Translation ---
+
+// Server error numbers translated by translateMySQLError.
+const (
+	mysqlErrDupEntry           = 1062 // ER_DUP_ENTRY
+	mysqlErrDeadlock           = 1213 // ER_LOCK_DEADLOCK
+	mysqlErrRowIsReferenced    = 1451 // ER_ROW_IS_REFERENCED_2
+	mysqlErrNoReferencedRow    = 1452 // ER_NO_REFERENCED_ROW_2
+	mysqlErrCheckViolated      = 3819 // ER_CHECK_CONSTRAINT_VIOLATED (MySQL 8)
+	mariadbErrConstraintFailed = 4025 // ER_CONSTRAINT_FAILED (MariaDB)
+)
+
+// uniqueKeyFields maps each table's unique index names to the JSON field
+// they guard. The plaintext document indexes only exist on databases that
+// have not finished `rekey-pii`.
+var uniqueKeyFields = map[string]map[string]string{
+	"customers": {
+		"PRIMARY":              "customer_id",
+		"passport_hash":        "passport_id",
+		"aadhar_hash":          "aadhar_id",
+		"driving_license_hash": "driving_license_id",
+		"passportID":           "passport_id",
+		"aadharID":             "aadhar_id",
+		"drivingLicenseID":     "driving_license_id",
+	},
+	"catalog_items": {
+		"PRIMARY": "sku",
+	},
+}
+
+// checkConstraintFields maps named CHECK constraints in schema.sql to the
+// request field they guard.
+var checkConstraintFields = map[string]string{
+	"chk_customers_id_document": "id_documents",
+	"chk_orders_status":         "status",
+}
+
+var (
+	// "Duplicate entry '...' for key 'customers.passport_hash'" (MySQL 8
+	// prefixes the table name, MariaDB does not).
+	duplicateKeyPattern = regexp.MustCompile(`for key '(?:([^'.]*)\.)?([^']+)'\s*$`)
+	// "... a foreign key constraint fails (`db`.`products`, CONSTRAINT
+	// `products_ibfk_1` FOREIGN KEY (`customer_id`) REFERENCES ..."
+	foreignKeyPattern = regexp.MustCompile("\\(`[^`]*`\\.`([^`]+)`, CONSTRAINT `[^`]+` FOREIGN KEY \\(`([^`]+)`\\)")
+	// "Check constraint 'name' is violated." (MySQL) or
+	// "CONSTRAINT `name` failed for `db`.`table`" (MariaDB).
+	checkConstraintPattern = regexp.MustCompile("(?:Check constraint '([^']+)'|CONSTRAINT `([^`]+)` failed)")
+)
+
+// translateMySQLError turns constraint violations into the typed errors of
+// store.go, so handlers can say which field collided without parsing driver
+// messages. Other errors are returned unchanged. A duplicate key is looked up
+// under the table the message names, or under customers when it names none.
+func translateMySQLError(err error) error {
+	return translateTableError("customers", err)
+}
+
+// translateTableError is translateMySQLError for a statement on table.
+// MariaDB leaves the table out of duplicate-key messages, so callers
+// inserting into any table but customers must say which one it was.
+func translateTableError(table string, err error) error {
+	var myErr *mysql.MySQLError
+	if !errors.As(err, &myErr) {
+		return err
+	}
+	switch myErr.Number {
+	case mysqlErrDupEntry:
+		key := "unknown"
+		if m := duplicateKeyPattern.FindStringSubmatch(myErr.Message); m != nil {
+			if m[1] != "" {
+				table = m[1]
+			}
+			key = m[2]
+		}
+		if field, ok := uniqueKeyFields[table][key]; ok {
+			return &DuplicateKeyError{Field: field}
+		}
+		return &DuplicateKeyError{Field: key}
+	case mysqlErrRowIsReferenced, mysqlErrNoReferencedRow:
+		fkErr := &ForeignKeyError{Referenced: myErr.Number == mysqlErrRowIsReferenced}
+		if m := foreignKeyPattern.FindStringSubmatch(myErr.Message); m != nil {
+			fkErr.Table, fkErr.Column = m[1], m[2]
+		}
+		return fkErr
+	case mysqlErrCheckViolated, mariadbErrConstraintFailed:
+		checkErr := &CheckViolationError{}
+		if m := checkConstraintPattern.FindStringSubmatch(myErr.Message); m != nil {
+			checkErr.Constraint = m[1] + m[2]
+			checkErr.Field = checkConstraintFields[checkErr.Constraint]
+		}
+		return checkErr
+	}
+	return err
+}
+
+func isDeadlock(err error) bool {
+	var myErr *mysql.MySQLError
+	return errors.As(err, &myErr) && myErr.Number == mysqlErrDeadlock
+}
+
+// maxTxAttempts bounds how often inTx runs a transaction that keeps being
+// chosen as the deadlock victim.
+const maxTxAttempts = 3
+
+// errRollback is returned by a transaction function that wants its work
+// discarded, such as a dry-run import. inTx passes it back unchanged.
+var errRollback = errors.New("rollback requested")
+
+// inTx runs fn in a transaction and commits it. InnoDB rolls back the whole
+// transaction of a deadlock victim, so fn is run again from the start, up to
+// maxTxAttempts times; fn must reset any state it built on an earlier
+// attempt. Errors are passed through translateMySQLError.
+func (s *mysqlStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	for attempt := 1; ; attempt++ {
+		err := s.runTx(ctx, fn)
+		if !isDeadlock(err) {
+			return translateMySQLError(err)
+		}
+		if attempt == maxTxAttempts {
+			return fmt.Errorf("%w (%d attempts): %v", ErrDeadlock, attempt, err)
+		}
+		// Jittered backoff so the competing transactions don't collide again.
+		backoff := time.Duration(attempt*20+rand.Intn(30)) * time.Millisecond
+		log.Printf("Deadlock on attempt %d, retrying in %v", attempt, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *mysqlStore) runTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
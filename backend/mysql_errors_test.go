@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestTranslateDuplicateKey(t *testing.T) {
+	for _, tc := range []struct {
+		table   string
+		message string
+		want    string
+	}{
+		// MySQL 8 names the table, MariaDB does not.
+		{"customers", "Duplicate entry '1000000008' for key 'customers.PRIMARY'", "customer_id"},
+		{"customers", "Duplicate entry '1000000008' for key 'PRIMARY'", "customer_id"},
+		{"customers", "Duplicate entry 'ab12' for key 'customers.passport_hash'", "passport_id"},
+		{"customers", "Duplicate entry 'K1234567' for key 'passportID'", "passport_id"},
+		{"catalog_items", "Duplicate entry 'SKU-1' for key 'catalog_items.PRIMARY'", "sku"},
+		{"catalog_items", "Duplicate entry 'SKU-1' for key 'PRIMARY'", "sku"},
+		{"customers", "Duplicate entry 'SKU-1' for key 'catalog_items.PRIMARY'", "sku"}, // the message wins
+		{"customers", "Duplicate entry 'x' for key 'customers.email_idx'", "email_idx"},
+	} {
+		err := translateTableError(tc.table, &mysql.MySQLError{Number: mysqlErrDupEntry, Message: tc.message})
+		var dupErr *DuplicateKeyError
+		if !errors.As(err, &dupErr) || dupErr.Field != tc.want {
+			t.Errorf("translateTableError(%q, %q) = %v, want a duplicate %s", tc.table, tc.message, err, tc.want)
+		}
+	}
+}
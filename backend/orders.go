@@ -0,0 +1,335 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Orders ---
+
+// Order statuses. An order starts as a draft (or is placed straight away)
+// and moves along orderTransitions; shipped and cancelled are final.
+const (
+	OrderDraft     = "draft"
+	OrderPlaced    = "placed"
+	OrderPaid      = "paid"
+	OrderShipped   = "shipped"
+	OrderCancelled = "cancelled"
+)
+
+// orderTransitions lists the statuses each status may move to.
+var orderTransitions = map[string][]string{
+	OrderDraft:     {OrderPlaced, OrderCancelled},
+	OrderPlaced:    {OrderPaid, OrderCancelled},
+	OrderPaid:      {OrderShipped, OrderCancelled},
+	OrderShipped:   nil,
+	OrderCancelled: nil,
+}
+
+// maxOrderLines bounds the lines of one order.
+const maxOrderLines = 100
+
+var (
+	ErrOrderNotFound         = errors.New("order not found for the given customer")
+	ErrOrderCurrencyMismatch = errors.New("all lines of an order must be in the same currency")
+)
+
+// OrderTransitionError reports a status change orderTransitions does not
+// allow.
+type OrderTransitionError struct {
+	From, To string
+}
+
+func (e *OrderTransitionError) Error() string {
+	return fmt.Sprintf("an order cannot go from %s to %s", e.From, e.To)
+}
+
+// OrderLineError attributes an error found while pricing an order to the
+// line at Index, e.g. ErrCatalogItemNotFound for its SKU.
+type OrderLineError struct {
+	Index int
+	Err   error
+}
+
+func (e *OrderLineError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.Index, e.Err)
+}
+
+func (e *OrderLineError) Unwrap() error { return e.Err }
+
+// Order is one purchase by a customer: a set of lines priced in a single
+// currency, moving through the statuses above. Lines never change once the
+// order is created. Orders are soft-deleted, restored and purged together
+// with their customer, like products.
+type Order struct {
+	OrderID    int64       `json:"order_id"`
+	CustomerID int64       `json:"customer_id"`
+	Status     string      `json:"status"`
+	Total      Money       `json:"total"`
+	Lines      []OrderLine `json:"lines"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+// OrderLine is one item of an order. Lines with a SKU take the name and list
+// price of the catalog item when the order is created, like products.
+type OrderLine struct {
+	LineNo      int     `json:"line_no"`
+	SKU         *string `json:"sku,omitempty"`
+	ProductName string  `json:"product_name"`
+	Quantity    int     `json:"quantity"`
+	UnitPrice   Money   `json:"unit_price"`
+	LineTotal   Money   `json:"line_total"`
+}
+
+// OrderStore covers orders and their lines.
+type OrderStore interface {
+	// CreateOrder prices and persists the order, filling in OrderID, line
+	// numbers and totals, and the timestamps. It fails with
+	// ErrCustomerNotFound if the customer is not live, and with an
+	// *OrderLineError for an unknown or inactive SKU or a line in another
+	// currency.
+	CreateOrder(ctx context.Context, order *Order) error
+	// ListOrders returns the customer's live orders, newest first. A
+	// non-empty status keeps only orders in that status.
+	ListOrders(ctx context.Context, customerID int64, status string) ([]Order, error)
+	GetOrder(ctx context.Context, customerID, orderID int64) (Order, error)
+	// TransitionOrder moves the order to status, failing with an
+	// *OrderTransitionError if orderTransitions does not allow it.
+	TransitionOrder(ctx context.Context, customerID, orderID int64, status string) (Order, error)
+}
+
+// checkOrderTransition returns an *OrderTransitionError unless from may move
+// to to.
+func checkOrderTransition(from, to string) error {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &OrderTransitionError{From: from, To: to}
+}
+
+// priceOrder numbers the lines, copies the name and list price of catalog
+// items into lines with a SKU, and computes line totals and the order total.
+// lookup reads a catalog item inside the caller's transaction.
+func priceOrder(order *Order, lookup func(sku string) (CatalogItem, error)) error {
+	order.Total = Money{}
+	for i := range order.Lines {
+		line := &order.Lines[i]
+		line.LineNo = i + 1
+		if line.SKU != nil {
+			item, err := lookup(*line.SKU)
+			if err == nil && !item.Active {
+				err = ErrCatalogItemInactive
+			}
+			if err != nil {
+				return &OrderLineError{Index: i, Err: err}
+			}
+			line.ProductName, line.UnitPrice = item.Name, item.ListPrice
+		}
+
+		if i == 0 {
+			order.Total = Money{Currency: line.UnitPrice.Currency}
+		} else if line.UnitPrice.Currency != order.Total.Currency {
+			return &OrderLineError{Index: i, Err: ErrOrderCurrencyMismatch}
+		}
+		var err error
+		if line.LineTotal, err = line.UnitPrice.Times(line.Quantity); err == nil {
+			order.Total, err = order.Total.Plus(line.LineTotal)
+		}
+		if err != nil {
+			return &OrderLineError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// cloneOrder copies the lines so callers never share them with the store.
+func cloneOrder(o Order) Order {
+	lines := make([]OrderLine, len(o.Lines))
+	for i, line := range o.Lines {
+		line.SKU = copyString(line.SKU)
+		lines[i] = line
+	}
+	o.Lines = lines
+	return o
+}
+
+func orderAuditEntry(ctx context.Context, action string, before, after *Order) AuditEntry {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	customerID := subject.CustomerID
+	var b, a interface{}
+	if before != nil {
+		b = before
+	}
+	if after != nil {
+		a = after
+	}
+	return newAuditEntry(ctx, action, "order", strconv.FormatInt(subject.OrderID, 10), &customerID, b, a)
+}
+
+// --- Handlers ---
+
+// parseOrderPath reads {customer_id} and {order_id}, answering 400 if either
+// is malformed.
+func parseOrderPath(w http.ResponseWriter, r *http.Request) (int64, int64, bool) {
+	vars := mux.Vars(r)
+	customerID, err := parseCustomerID(vars["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return 0, 0, false
+	}
+	orderID, err := strconv.ParseInt(vars["order_id"], 10, 64)
+	if err != nil || orderID <= 0 {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "Invalid order ID format")
+		return 0, 0, false
+	}
+	return customerID, orderID, true
+}
+
+// createOrder handles POST /api/orders. The body names the customer, the
+// lines (each a sku, or a product_name and unit_price, with a quantity) and
+// optionally "status": "placed" to skip the draft stage.
+func (s *server) createOrder(w http.ResponseWriter, r *http.Request) {
+	var order Order
+	if err := json.NewDecoder(r.Body).Decode(&order); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	var verr *ValidationError
+	if errors.As(validateOrder(&order), &verr) {
+		respondWithValidationError(w, verr)
+		return
+	}
+
+	err := s.store.CreateOrder(r.Context(), &order)
+	var lineErr *OrderLineError
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrCustomerNotFound):
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		return
+	case errors.As(err, &lineErr):
+		respondWithValidationError(w, orderLineValidationError(lineErr))
+		return
+	default:
+		respondWithStoreError(w, "Failed to create order", err)
+		return
+	}
+
+	respondWithJSON(w, http.StatusCreated, SuccessResponse{
+		Message: fmt.Sprintf("Order %d created successfully", order.OrderID),
+		Order:   &order,
+	})
+}
+
+// orderLineValidationError reports a line the store could not price against
+// the field that caused it.
+func orderLineValidationError(lineErr *OrderLineError) *ValidationError {
+	prefix := fmt.Sprintf("lines[%d].", lineErr.Index)
+	errs := &ValidationError{}
+	switch {
+	case errors.Is(lineErr, ErrCatalogItemNotFound):
+		errs.add(prefix+"sku", CodeUnknown, "is not in the catalog")
+	case errors.Is(lineErr, ErrCatalogItemInactive):
+		errs.add(prefix+"sku", CodeInactive, "is no longer sold")
+	case errors.Is(lineErr, ErrOrderCurrencyMismatch):
+		errs.add(prefix+"unit_price.currency", CodeMismatch, "must match the currency of the first line")
+	default:
+		errs.add(prefix+"quantity", CodeRange, "makes the order total too large")
+	}
+	return errs
+}
+
+// listOrders handles GET /api/orders/{customer_id}?status=...
+func (s *server) listOrders(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parseCustomerID(mux.Vars(r)["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if _, known := orderTransitions[status]; status != "" && !known {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "status must be one of draft, placed, paid, shipped, cancelled")
+		return
+	}
+
+	orders, err := s.store.ListOrders(r.Context(), customerID, status)
+	if err != nil {
+		respondWithStoreError(w, "Failed to retrieve orders", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Retrieved %d orders for Customer ID %d", len(orders), customerID),
+		Orders:  orders,
+	})
+}
+
+func (s *server) getOrder(w http.ResponseWriter, r *http.Request) {
+	customerID, orderID, ok := parseOrderPath(w, r)
+	if !ok {
+		return
+	}
+	order, err := s.store.GetOrder(r.Context(), customerID, orderID)
+	if errors.Is(err, ErrOrderNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Order not found for the given customer")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to retrieve order", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{Order: &order})
+}
+
+// transitionOrder handles POST /api/orders/{customer_id}/{order_id}/status
+// with {"status": "paid"}. A move orderTransitions does not allow is
+// answered with 409.
+func (s *server) transitionOrder(w http.ResponseWriter, r *http.Request) {
+	customerID, orderID, ok := parseOrderPath(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	if _, known := orderTransitions[body.Status]; !known {
+		respondWithValidationError(w, &ValidationError{Fields: []FieldError{
+			{Field: "status", Code: CodeFormat, Message: "must be one of draft, placed, paid, shipped, cancelled"},
+		}})
+		return
+	}
+
+	order, err := s.store.TransitionOrder(r.Context(), customerID, orderID, body.Status)
+	var transErr *OrderTransitionError
+	switch {
+	case errors.Is(err, ErrOrderNotFound):
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Order not found for the given customer")
+		return
+	case errors.As(err, &transErr):
+		respondWithError(w, http.StatusConflict, ProblemInvalidTransition, "Cannot change status: "+transErr.Error())
+		return
+	case err != nil:
+		respondWithStoreError(w, "Failed to change order status", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Order %d is now %s", orderID, order.Status),
+		Order:   &order,
+	})
+}
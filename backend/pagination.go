@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// --- Customer Listing: Pagination, Sorting and Filtering ---
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// customerSortColumns maps the public sort keys to their database columns.
+var customerSortColumns = map[string]string{
+	"customer_id": "customer_id",
+	"name":        "name",
+	"age":         "age",
+	"created_at":  "created_at",
+}
+
+// CustomerFilter narrows a customer listing. Nil fields are not applied.
+// CreatedAfter is inclusive and CreatedBefore exclusive.
+type CustomerFilter struct {
+	MinAge        *int
+	MaxAge        *int
+	CreatedAfter  *time.Time
+	CreatedBefore *time.Time
+	HasEmail      *bool
+	HasPhone      *bool
+	HasPassport   *bool
+}
+
+// CustomerListOptions is what the stores need to produce one page.
+type CustomerListOptions struct {
+	Limit  int
+	Sort   string
+	Desc   bool
+	Filter CustomerFilter
+	Cursor *listCursor
+}
+
+// CustomerPage is one page of a keyset-paginated listing.
+type CustomerPage struct {
+	Customers  []Customer
+	NextCursor string
+	PrevCursor string
+}
+
+// PageInfo is the paging metadata returned next to the `customers` array.
+type PageInfo struct {
+	Limit      int    `json:"limit"`
+	Sort       string `json:"sort"`
+	Order      string `json:"order"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// listCursor marks the boundary row of a page. It is handed to clients as
+// opaque base64 and pins the sort it was issued for, so it cannot be replayed
+// against a different ordering. Back means "rows before this one".
+type listCursor struct {
+	Sort string `json:"s"`
+	Desc bool   `json:"d"`
+	Key  string `json:"k,omitempty"`
+	ID   int64  `json:"i"`
+	Back bool   `json:"b,omitempty"`
+}
+
+func (c listCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeListCursor(s string) (*listCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	var c listCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	if _, ok := customerSortColumns[c.Sort]; !ok {
+		return nil, errors.New("invalid cursor")
+	}
+	if _, err := c.pivot(); err != nil {
+		return nil, errors.New("invalid cursor")
+	}
+	return &c, nil
+}
+
+// cursorFor builds the cursor pointing at customer c under the given sort.
+func cursorFor(c Customer, sort string, desc, back bool) string {
+	cur := listCursor{Sort: sort, Desc: desc, ID: c.CustomerID, Back: back}
+	switch sort {
+	case "name":
+		cur.Key = c.Name
+	case "age":
+		cur.Key = strconv.Itoa(c.Age)
+	case "created_at":
+		cur.Key = c.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cur.encode()
+}
+
+// pivot rebuilds the boundary customer so stores can compare rows against it.
+func (c listCursor) pivot() (Customer, error) {
+	pivot := Customer{CustomerID: c.ID}
+	switch c.Sort {
+	case "name":
+		pivot.Name = c.Key
+	case "age":
+		age, err := strconv.Atoi(c.Key)
+		if err != nil {
+			return pivot, err
+		}
+		pivot.Age = age
+	case "created_at":
+		t, err := time.Parse(time.RFC3339Nano, c.Key)
+		if err != nil {
+			return pivot, err
+		}
+		pivot.CreatedAt = t
+	}
+	return pivot, nil
+}
+
+// keyArg returns the cursor's sort value typed for use as a SQL argument.
+func (c listCursor) keyArg() interface{} {
+	pivot, _ := c.pivot()
+	switch c.Sort {
+	case "name":
+		return pivot.Name
+	case "age":
+		return pivot.Age
+	case "created_at":
+		return pivot.CreatedAt
+	}
+	return pivot.CustomerID
+}
+
+// compareCustomers orders a and b by the sort key, breaking ties on
+// customer_id. Names compare case-insensitively like the utf8mb4_unicode_ci
+// collation.
+func compareCustomers(a, b Customer, sort string) int {
+	cmp := 0
+	switch sort {
+	case "name":
+		cmp = strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name))
+	case "age":
+		cmp = a.Age - b.Age
+	case "created_at":
+		cmp = a.CreatedAt.Compare(b.CreatedAt)
+	}
+	if cmp != 0 {
+		return cmp
+	}
+	switch {
+	case a.CustomerID < b.CustomerID:
+		return -1
+	case a.CustomerID > b.CustomerID:
+		return 1
+	}
+	return 0
+}
+
+// scanDesc reports the direction rows must be read in. Walking backwards
+// from a cursor reads the opposite direction and the page is flipped after.
+func (o CustomerListOptions) scanDesc() bool {
+	if o.Cursor != nil && o.Cursor.Back {
+		return !o.Desc
+	}
+	return o.Desc
+}
+
+// newCustomerPage turns up to Limit+1 rows, read in scanDesc order, into a
+// page with its neighbouring cursors.
+func newCustomerPage(rows []Customer, opts CustomerListOptions) CustomerPage {
+	hasMore := len(rows) > opts.Limit
+	if hasMore {
+		rows = rows[:opts.Limit]
+	}
+	back := opts.Cursor != nil && opts.Cursor.Back
+	if back {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+
+	page := CustomerPage{Customers: rows}
+	if len(rows) == 0 {
+		return page
+	}
+	first, last := rows[0], rows[len(rows)-1]
+	if back {
+		if hasMore {
+			page.PrevCursor = cursorFor(first, opts.Sort, opts.Desc, true)
+		}
+		page.NextCursor = cursorFor(last, opts.Sort, opts.Desc, false)
+	} else {
+		if hasMore {
+			page.NextCursor = cursorFor(last, opts.Sort, opts.Desc, false)
+		}
+		if opts.Cursor != nil {
+			page.PrevCursor = cursorFor(first, opts.Sort, opts.Desc, true)
+		}
+	}
+	return page
+}
+
+// pageInfo describes page for the JSON response.
+func (o CustomerListOptions) pageInfo(page CustomerPage) *PageInfo {
+	order := "asc"
+	if o.Desc {
+		order = "desc"
+	}
+	return &PageInfo{
+		Limit:      o.Limit,
+		Sort:       o.Sort,
+		Order:      order,
+		HasMore:    page.NextCursor != "",
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
+}
+
+// --- Query Parameter Parsing ---
+
+// parseCustomerListOptions reads limit, cursor, sort, order and the filter
+// parameters. Errors are suitable for returning to the client as-is.
+func parseCustomerListOptions(r *http.Request) (CustomerListOptions, error) {
+	q := r.URL.Query()
+	opts := CustomerListOptions{Limit: defaultPageLimit, Sort: "customer_id", Desc: true}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return opts, errors.New("limit must be a positive integer")
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		opts.Limit = limit
+	}
+
+	sort, order := q.Get("sort"), strings.ToLower(q.Get("order"))
+	if sort != "" {
+		if _, ok := customerSortColumns[sort]; !ok {
+			return opts, errors.New("Invalid sort key. Use: customer_id, name, age, or created_at")
+		}
+		opts.Sort = sort
+		// Ascending is the natural default for everything but the primary key.
+		opts.Desc = sort == "customer_id"
+	}
+	switch order {
+	case "":
+	case "asc":
+		opts.Desc = false
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, errors.New("order must be asc or desc")
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cur, err := decodeListCursor(v)
+		if err != nil {
+			return opts, err
+		}
+		if (sort != "" && sort != cur.Sort) || (order != "" && (order == "desc") != cur.Desc) {
+			return opts, errors.New("cursor was issued for a different sort or order")
+		}
+		opts.Sort, opts.Desc, opts.Cursor = cur.Sort, cur.Desc, cur
+	}
+
+	filter, err := parseCustomerFilter(q)
+	if err != nil {
+		return opts, err
+	}
+	opts.Filter = filter
+	return opts, nil
+}
+
+func parseCustomerFilter(q map[string][]string) (CustomerFilter, error) {
+	var f CustomerFilter
+	get := func(key string) string {
+		if v := q[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	for key, dst := range map[string]**int{"min_age": &f.MinAge, "max_age": &f.MaxAge} {
+		if v := get(key); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an integer", key)
+			}
+			*dst = &n
+		}
+	}
+
+	for key, dst := range map[string]**time.Time{"created_after": &f.CreatedAfter, "created_before": &f.CreatedBefore} {
+		if v := get(key); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be an RFC 3339 timestamp or YYYY-MM-DD date", key)
+			}
+			*dst = &t
+		}
+	}
+
+	for key, dst := range map[string]**bool{"has_email": &f.HasEmail, "has_phone": &f.HasPhone, "has_passport": &f.HasPassport} {
+		if v := get(key); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				return f, fmt.Errorf("%s must be true or false", key)
+			}
+			*dst = &b
+		}
+	}
+	return f, nil
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// matches applies the filter to a single customer (used by memoryStore).
+func (f CustomerFilter) matches(c Customer) bool {
+	if f.MinAge != nil && c.Age < *f.MinAge {
+		return false
+	}
+	if f.MaxAge != nil && c.Age > *f.MaxAge {
+		return false
+	}
+	if f.CreatedAfter != nil && c.CreatedAt.Before(*f.CreatedAfter) {
+		return false
+	}
+	if f.CreatedBefore != nil && !c.CreatedAt.Before(*f.CreatedBefore) {
+		return false
+	}
+	has := func(want *bool, v *string) bool {
+		return want == nil || *want == (v != nil && *v != "")
+	}
+	return has(f.HasEmail, c.Email) && has(f.HasPhone, c.PhoneNumber) && has(f.HasPassport, c.PassportID)
+}
+
+// sqlConditions renders the filter as WHERE conditions (used by mysqlStore).
+// table qualifies every column, e.g. "c." when customers is joined under
+// that alias; pass "" for queries on customers alone.
+func (f CustomerFilter) sqlConditions(table string) ([]string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	if f.MinAge != nil {
+		conds = append(conds, table+"age >= ?")
+		args = append(args, *f.MinAge)
+	}
+	if f.MaxAge != nil {
+		conds = append(conds, table+"age <= ?")
+		args = append(args, *f.MaxAge)
+	}
+	if f.CreatedAfter != nil {
+		conds = append(conds, table+"created_at >= ?")
+		args = append(args, *f.CreatedAfter)
+	}
+	if f.CreatedBefore != nil {
+		conds = append(conds, table+"created_at < ?")
+		args = append(args, *f.CreatedBefore)
+	}
+	has := func(want *bool, column string) {
+		if want == nil {
+			return
+		}
+		column = table + column
+		if *want {
+			conds = append(conds, fmt.Sprintf("(%s IS NOT NULL AND %s <> '')", column, column))
+		} else {
+			conds = append(conds, fmt.Sprintf("(%s IS NULL OR %s = '')", column, column))
+		}
+	}
+	has(f.HasEmail, "email")
+	has(f.HasPhone, "phoneNumber")
+	has(f.HasPassport, "passportID")
+	return conds, args
+}
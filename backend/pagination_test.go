@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// ExportCustomers joins products, which has its own created_at, so every
+// filter column must carry the customers alias.
+func TestCustomerFilterSQLConditionsQualifiesColumns(t *testing.T) {
+	age, yes := 18, true
+	at := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := CustomerFilter{
+		MinAge: &age, MaxAge: &age, CreatedAfter: &at, CreatedBefore: &at,
+		HasEmail: &yes, HasPhone: &yes, HasPassport: &yes,
+	}
+
+	conds, args := f.sqlConditions("c.")
+	if len(conds) != 7 || len(args) != 4 {
+		t.Fatalf("got %d conditions and %d args, want 7 and 4", len(conds), len(args))
+	}
+	for _, cond := range conds {
+		if !strings.HasPrefix(strings.TrimPrefix(cond, "("), "c.") {
+			t.Errorf("condition %q is not qualified with c.", cond)
+		}
+	}
+
+	conds, _ = f.sqlConditions("")
+	for _, cond := range conds {
+		if strings.Contains(cond, ".") {
+			t.Errorf("condition %q is qualified without a table", cond)
+		}
+	}
+}
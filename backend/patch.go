@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Partial Updates (JSON Merge Patch, RFC 7396) ---
+
+// patchableCustomerFields are the Customer JSON keys a merge patch may touch.
+var patchableCustomerFields = map[string]bool{
+	"name":               true,
+	"age":                true,
+	"address":            true,
+	"phone_number":       true,
+	"email":              true,
+	"passport_id":        true,
+	"aadhar_id":          true,
+	"driving_license_id": true,
+}
+
+// mergePatch applies patch to target following RFC 7396: objects merge
+// recursively, null removes a member, anything else replaces it.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = map[string]interface{}{}
+	}
+	for key, value := range patchObj {
+		if value == nil {
+			delete(targetObj, key)
+		} else {
+			targetObj[key] = mergePatch(targetObj[key], value)
+		}
+	}
+	return targetObj
+}
+
+// applyCustomerPatch returns current with the merge patch applied. Only
+// patchableCustomerFields may appear in the patch.
+func applyCustomerPatch(current Customer, patch map[string]interface{}) (Customer, error) {
+	var rejected []string
+	for key := range patch {
+		if !patchableCustomerFields[key] {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		errs := &ValidationError{}
+		for _, key := range rejected {
+			errs.add(key, CodeReadOnly, "cannot be patched")
+		}
+		return Customer{}, errs
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return Customer{}, err
+	}
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return Customer{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return Customer{}, err
+	}
+	var patched Customer
+	if err := json.Unmarshal(merged, &patched); err != nil {
+		return Customer{}, fmt.Errorf("Patched customer has invalid field types: %w", err)
+	}
+	return patched, nil
+}
+
+// acceptMergePatch answers 415 unless the request is a merge patch, sent as
+// application/merge-patch+json or plain application/json. Other JSON types
+// such as application/json-patch+json (RFC 6902) are refused rather than
+// misread as a merge patch.
+func acceptMergePatch(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && (mediaType == "application/merge-patch+json" || mediaType == "application/json") {
+		return true
+	}
+	w.Header().Set("Accept-Patch", "application/merge-patch+json")
+	respondWithError(w, http.StatusUnsupportedMediaType, ProblemUnsupportedMedia, "Use Content-Type: application/merge-patch+json")
+	return false
+}
+
+// patchCustomer handles PATCH /api/customers/{customer_id} with an RFC 7396
+// merge patch. `null` clears an optional field; the merged customer must pass
+// the same validation as PUT. If-Match is required, as for PUT.
+func (s *server) patchCustomer(w http.ResponseWriter, r *http.Request) {
+	id, err := parseCustomerID(mux.Vars(r)["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	if !acceptMergePatch(w, r) {
+		return
+	}
+
+	expectedVersion, err := parseIfMatch(r)
+	if errors.Is(err, errIfMatchMissing) {
+		respondWithError(w, http.StatusPreconditionRequired, ProblemPreconditionRequired, err.Error())
+		return
+	} else if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	var patch map[string]interface{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&patch); err != nil || patch == nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidJSON, "Invalid request payload: a merge patch must be a JSON object")
+		return
+	}
+
+	current, err := s.store.GetCustomer(r.Context(), LookupCustomerID, strconv.FormatInt(id, 10))
+	if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to retrieve customer", err)
+		return
+	}
+
+	// "If-Match: *" still has to guard the read-modify-write against the
+	// version we just merged onto.
+	if expectedVersion == 0 {
+		expectedVersion = current.Version
+	}
+
+	patched, err := applyCustomerPatch(current, patch)
+	var verr *ValidationError
+	if errors.As(err, &verr) {
+		respondWithValidationError(w, verr)
+		return
+	} else if err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	patched.CustomerID = id
+
+	if errors.As(validateCustomer(&patched), &verr) {
+		respondWithValidationError(w, verr)
+		return
+	}
+
+	updated, err := s.store.UpdateCustomer(r.Context(), patched, expectedVersion)
+	if errors.Is(err, ErrVersionMismatch) {
+		respondWithVersionMismatch(w, shape, updated)
+		return
+	} else if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to update customer", err)
+		return
+	}
+
+	// Pointers from documents the patch changed or removed no longer match
+	// the new entry and are dropped on their next read.
+	s.cacheCustomer(updated)
+
+	setCustomerETag(w, shape, updated)
+	respondWithJSON(w, http.StatusOK, shape.view(updated))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestPatchCustomerContentType(t *testing.T) {
+	h := newTestServer(t)
+	c := createTestCustomer(t, h, "Asha Rao", "K1234567")
+
+	version := 1
+	for _, tc := range []struct {
+		contentType string
+		want        int
+	}{
+		{"application/merge-patch+json", http.StatusOK},
+		{"application/json; charset=utf-8", http.StatusOK},
+		{"Application/Merge-Patch+JSON", http.StatusOK},
+		{"application/json-patch+json", http.StatusUnsupportedMediaType},
+		{"application/jsonx", http.StatusUnsupportedMediaType},
+		{"text/plain", http.StatusUnsupportedMediaType},
+		{"", http.StatusUnsupportedMediaType},
+	} {
+		rec := call(t, h, "PATCH", customerPath(c.CustomerID), `{"address": "14 MG Road"}`,
+			"Content-Type", tc.contentType, "If-Match", fmt.Sprintf(`"%d"`, version))
+		if rec.Code != tc.want {
+			t.Errorf("Content-Type %q: status %d, want %d; body: %s", tc.contentType, rec.Code, tc.want, rec.Body.String())
+		}
+		if rec.Code == http.StatusOK {
+			version++
+		} else if got := rec.Header().Get("Accept-Patch"); got != "application/merge-patch+json" {
+			t.Errorf("Content-Type %q: Accept-Patch = %q", tc.contentType, got)
+		}
+	}
+}
+
+func TestPatchProductContentType(t *testing.T) {
+	h := newTestServer(t)
+	c := createTestCustomer(t, h, "Asha Rao", "K1234567")
+	rec := call(t, h, "POST", "/api/products", fmt.Sprintf(
+		`{"customer_id": %d, "product_name": "Laptop", "quantity": 1, "price": {"amount": "1200.00", "currency": "INR"}}`, c.CustomerID))
+	expectStatus(t, rec, http.StatusCreated)
+	var created struct {
+		Product Product `json:"product"`
+	}
+	decode(t, rec, &created)
+	path := fmt.Sprintf("/api/products/%d/%d", c.CustomerID, created.Product.ProductID)
+
+	expectStatus(t, call(t, h, "PATCH", path, `[{"op": "replace", "path": "/quantity", "value": 2}]`,
+		"Content-Type", "application/json-patch+json"), http.StatusUnsupportedMediaType)
+	rec = call(t, h, "PATCH", path, `{"quantity": 2}`, "Content-Type", "application/merge-patch+json")
+	expectStatus(t, rec, http.StatusOK)
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+// --- Field-Level Encryption of ID Documents ---
+
+// sealedPrefix marks an envelope-encrypted value. Anything without it is a
+// legacy plaintext value written before encryption was enabled.
+const sealedPrefix = "enc1:"
+
+// documentJSONFields maps the Customer JSON keys holding ID documents to the
+// field names used as AES-GCM associated data and hash domain.
+var documentJSONFields = map[string]string{
+	"aadhar_id":          LookupAadhar,
+	"passport_id":        LookupPassport,
+	"driving_license_id": LookupDrivingLicense,
+}
+
+var errUnknownPIIKey = errors.New("ID document was encrypted with a key that is not in PII_ENCRYPTION_KEYS")
+
+// piiKeyRing encrypts ID documents with per-value data keys wrapped by a
+// key-encryption key (envelope encryption), and derives the deterministic
+// HMAC used for uniqueness, exact lookups and cache keys.
+//
+// Only the active key encrypts; every key in the ring decrypts, so a key can
+// be rotated by making a new one active and running `customerDB rekey-pii`.
+// Retired keys must stay in the ring while audit_log entries sealed with
+// them are still needed, since the audit log cannot be rewritten.
+type piiKeyRing struct {
+	active  string
+	keys    map[string]cipher.AEAD
+	hashKey []byte
+}
+
+// piiKeyRingFromEnv reads PII_ENCRYPTION_KEYS ("id:base64key,...", active
+// key first, 32-byte keys) and PII_HASH_KEY (base64, at least 32 bytes). It
+// returns nil when neither is set.
+func piiKeyRingFromEnv() (*piiKeyRing, error) {
+	spec, hashKey := os.Getenv("PII_ENCRYPTION_KEYS"), os.Getenv("PII_HASH_KEY")
+	if spec == "" && hashKey == "" {
+		return nil, nil
+	}
+	if spec == "" || hashKey == "" {
+		return nil, errors.New("PII_ENCRYPTION_KEYS and PII_HASH_KEY must be set together")
+	}
+
+	ring := &piiKeyRing{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(spec, ",") {
+		id, encoded, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEYS entry %q is not id:base64key", entry)
+		}
+		if _, dup := ring.keys[id]; dup {
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEYS lists key %q twice", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("PII_ENCRYPTION_KEYS key %q must be 32 bytes, base64-encoded", id)
+		}
+		aead, err := newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+		ring.keys[id] = aead
+		if ring.active == "" {
+			ring.active = id
+		}
+	}
+
+	key, err := base64.StdEncoding.DecodeString(hashKey)
+	if err != nil || len(key) < 32 {
+		return nil, errors.New("PII_HASH_KEY must be at least 32 bytes, base64-encoded")
+	}
+	ring.hashKey = key
+	return ring, nil
+}
+
+// newEphemeralPIIKeyRing generates throwaway keys. The in-memory store never
+// persists anything, so it only needs stable hashes for its cache keys.
+func newEphemeralPIIKeyRing() *piiKeyRing {
+	key, hashKey := make([]byte, 32), make([]byte, 32)
+	rand.Read(key)
+	rand.Read(hashKey)
+	aead, _ := newGCM(key)
+	return &piiKeyRing{active: "ephemeral", keys: map[string]cipher.AEAD{"ephemeral": aead}, hashKey: hashKey}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func gcmSeal(aead cipher.AEAD, plaintext, aad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	rand.Read(nonce)
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func gcmOpen(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed value is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// seal encrypts value as "enc1:<key id>:<wrapped data key>:<ciphertext>".
+// The field name is bound as associated data, so a value cannot be moved to
+// another column. Empty strings are stored as-is.
+func (k *piiKeyRing) seal(field, value string) string {
+	if value == "" {
+		return ""
+	}
+	dataKey := make([]byte, 32)
+	rand.Read(dataKey)
+	aead, _ := newGCM(dataKey)
+
+	wrapped := gcmSeal(k.keys[k.active], dataKey, []byte(k.active))
+	ciphertext := gcmSeal(aead, []byte(value), []byte(field))
+	return sealedPrefix + k.active + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext)
+}
+
+// open reverses seal. Legacy plaintext values are returned unchanged.
+func (k *piiKeyRing) open(field, value string) (string, error) {
+	if !strings.HasPrefix(value, sealedPrefix) {
+		return value, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(value, sealedPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted %s value", field)
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", fmt.Errorf("%w (key %q)", errUnknownPIIKey, parts[0])
+	}
+	wrapped, err1 := base64.RawStdEncoding.DecodeString(parts[1])
+	ciphertext, err2 := base64.RawStdEncoding.DecodeString(parts[2])
+	if err1 != nil || err2 != nil {
+		return "", fmt.Errorf("malformed encrypted %s value", field)
+	}
+	dataKey, err := gcmOpen(kek, wrapped, []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("cannot unwrap %s data key: %w", field, err)
+	}
+	aead, err := newGCM(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := gcmOpen(aead, ciphertext, []byte(field))
+	if err != nil {
+		return "", fmt.Errorf("cannot decrypt %s: %w", field, err)
+	}
+	return string(plaintext), nil
+}
+
+// current reports whether value is already sealed with the active key.
+func (k *piiKeyRing) current(value string) bool {
+	return value == "" || strings.HasPrefix(value, sealedPrefix+k.active+":")
+}
+
+// hash is the deterministic keyed hash of an ID document, hex-encoded. The
+// field name keeps equal numbers of different document types apart.
+func (k *piiKeyRing) hash(field, value string) string {
+	mac := hmac.New(sha256.New, k.hashKey)
+	mac.Write([]byte(field))
+	mac.Write([]byte{0})
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// openCustomer decrypts the ID documents of c in place.
+func (k *piiKeyRing) openCustomer(c *Customer) error {
+	for field, doc := range customerDocuments(c) {
+		if *doc != nil {
+			plain, err := k.open(field, **doc)
+			if err != nil {
+				return fmt.Errorf("customer %d: %w", c.CustomerID, err)
+			}
+			*doc = &plain
+		}
+	}
+	return nil
+}
+
+func customerDocuments(c *Customer) map[string]**string {
+	return map[string]**string{
+		LookupAadhar:         &c.AadharID,
+		LookupPassport:       &c.PassportID,
+		LookupDrivingLicense: &c.DrivingLicenseID,
+	}
+}
+
+// sealJSON and openJSON encrypt/decrypt the ID document members of a JSON
+// object such as an audit snapshot or a cached customer. Other members are
+// left untouched.
+func (k *piiKeyRing) sealJSON(data []byte) []byte {
+	out, _ := k.transformJSON(data, func(field, v string) (string, error) { return k.seal(field, v), nil })
+	return out
+}
+
+func (k *piiKeyRing) openJSON(data []byte) ([]byte, error) {
+	return k.transformJSON(data, k.open)
+}
+
+func (k *piiKeyRing) transformJSON(data []byte, fn func(field, value string) (string, error)) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if len(data) == 0 || json.Unmarshal(data, &doc) != nil {
+		return data, nil
+	}
+	changed := false
+	for key, field := range documentJSONFields {
+		var value string
+		raw, ok := doc[key]
+		if !ok || string(raw) == "null" || json.Unmarshal(raw, &value) != nil {
+			continue
+		}
+		out, err := fn(field, value)
+		if err != nil {
+			return nil, err
+		}
+		doc[key], _ = json.Marshal(out)
+		changed = true
+	}
+	if !changed {
+		return data, nil
+	}
+	return json.Marshal(doc)
+}
+
+// runRekeyCommand implements `customerDB rekey-pii [-batch N]`: after a new
+// key is put first in PII_ENCRYPTION_KEYS, it re-encrypts stored ID documents
+// under that key. It also encrypts and hashes rows written before
+// encryption was enabled.
+func runRekeyCommand(args []string) {
+	fs := flag.NewFlagSet("rekey-pii", flag.ExitOnError)
+	batchSize := fs.Int("batch", 500, "rows per transaction")
+	fs.Parse(args)
+
+	store, _, closeStore := openStoreFromEnv()
+	defer closeStore()
+
+	rekeyer, ok := store.(interface {
+		RekeyPII(ctx context.Context, batchSize int) (int, error)
+	})
+	if !ok {
+		closeStore()
+		log.Fatal("rekey-pii only applies to STORE_BACKEND=mysql")
+	}
+	n, err := rekeyer.RekeyPII(context.Background(), *batchSize)
+	if err != nil {
+		closeStore()
+		log.Fatalf("Rekey failed after %d customers: %v", n, err)
+	}
+	log.Printf("Re-encrypted the ID documents of %d customers", n)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+)
+
+// --- Problem Details (RFC 7807) ---
+
+const problemContentType = "application/problem+json"
+
+// Problem codes reported in Problem.Code. Clients should branch on these
+// rather than on Detail, which is meant for people.
+const (
+	ProblemInvalidJSON          = "invalid_json"
+	ProblemInvalidParameter     = "invalid_parameter"
+	ProblemValidationFailed     = "validation_failed"
+	ProblemNotFound             = "not_found"
+	ProblemMethodNotAllowed     = "method_not_allowed"
+	ProblemDuplicateIDDocument  = "duplicate_id_document"
+	ProblemDuplicateKey         = "duplicate_key"
+	ProblemForeignKey           = "foreign_key_violation"
+	ProblemBusy                 = "database_busy"
+	ProblemVersionMismatch      = "version_mismatch"
+	ProblemPreconditionRequired = "precondition_required"
+	ProblemUnsupportedMedia     = "unsupported_media_type"
+	ProblemNotDeleted           = "customer_not_deleted"
+	ProblemQuantityBelowZero    = "quantity_below_zero"
+	ProblemInvalidTransition    = "invalid_transition"
+	ProblemImportAborted        = "import_aborted"
+	ProblemIdempotencyInFlight  = "idempotency_in_flight"
+	ProblemIdempotencyKeyReused = "idempotency_key_reused"
+	ProblemUnauthorized         = "unauthorized"
+	ProblemForbidden            = "forbidden"
+	ProblemInternal             = "internal_error"
+)
+
+// Problem is the body of every error response. Type is always "about:blank",
+// so Title is the HTTP status text and Code carries the specific error.
+// Violations lists each failing request field.
+//
+// Error repeats Detail for clients written against the earlier
+// {"error": "..."} body.
+type Problem struct {
+	Type       string       `json:"type"`
+	Title      string       `json:"title"`
+	Status     int          `json:"status"`
+	Detail     string       `json:"detail"`
+	Code       string       `json:"code"`
+	RequestID  string       `json:"request_id,omitempty"`
+	Violations []FieldError `json:"violations,omitempty"`
+	Error      string       `json:"error"`
+}
+
+func newProblem(status int, code, detail string) Problem {
+	return Problem{Status: status, Code: code, Detail: detail}
+}
+
+// writeProblem fills in the members common to every problem and writes body,
+// which is p itself or a pointer to a response struct embedding it.
+func writeProblem(w http.ResponseWriter, p *Problem, body interface{}) {
+	p.Type = "about:blank"
+	p.Title = http.StatusText(p.Status)
+	p.Error = p.Detail
+	// requestIDMiddleware has already echoed the ID in the response headers.
+	p.RequestID = w.Header().Get(requestIDHeader)
+
+	response, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.Status)
+	w.Write(response)
+}
+
+func respondWithProblem(w http.ResponseWriter, p Problem) {
+	writeProblem(w, &p, &p)
+}
+
+func respondWithError(w http.ResponseWriter, status int, code, detail string) {
+	respondWithProblem(w, newProblem(status, code, detail))
+}
+
+func respondWithValidationError(w http.ResponseWriter, err *ValidationError) {
+	p := newProblem(http.StatusBadRequest, ProblemValidationFailed, err.Error())
+	p.Violations = err.Fields
+	respondWithProblem(w, p)
+}
+
+// respondWithInternalError hides err from the client; the request ID in the
+// response lets it be found in the log.
+func respondWithInternalError(w http.ResponseWriter, detail string, err error) {
+	log.Printf("%s (request %s): %v", detail, w.Header().Get(requestIDHeader), err)
+	respondWithError(w, http.StatusInternalServerError, ProblemInternal, detail)
+}
+
+// respondWithDecodeError reports a request body that is not the expected
+// JSON. A value of the wrong type is reported as a violation of its field.
+func respondWithDecodeError(w http.ResponseWriter, err error) {
+	p := newProblem(http.StatusBadRequest, ProblemInvalidJSON, "Invalid request payload")
+	var typeErr *json.UnmarshalTypeError
+	var syntaxErr *json.SyntaxError
+	switch {
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		want := jsonTypeName(typeErr.Type)
+		p.Detail = fmt.Sprintf("Invalid request payload: %s must be a %s", typeErr.Field, want)
+		p.Violations = []FieldError{{
+			Field:   typeErr.Field,
+			Code:    CodeFormat,
+			Message: fmt.Sprintf("must be a %s, not a %s", want, typeErr.Value),
+		}}
+	case errors.As(err, &syntaxErr):
+		p.Detail = fmt.Sprintf("Invalid request payload: malformed JSON at byte %d", syntaxErr.Offset)
+	case errors.Is(err, io.EOF):
+		p.Detail = "Invalid request payload: the body is empty"
+	}
+	respondWithProblem(w, p)
+}
+
+// jsonTypeName names the JSON type a Go field decodes from.
+func jsonTypeName(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	}
+	return t.Kind().String()
+}
+
+// respondWithStoreError answers a failed store call. Constraint violations
+// translated by the store become 4xx problems naming the field; anything
+// else is an internal error described by detail.
+func respondWithStoreError(w http.ResponseWriter, detail string, err error) {
+	var dupErr *DuplicateKeyError
+	var fkErr *ForeignKeyError
+	var checkErr *CheckViolationError
+	switch {
+	case errors.As(err, &dupErr):
+		code := ProblemDuplicateKey
+		if errors.Is(err, ErrDuplicateIDDocument) {
+			code = ProblemDuplicateIDDocument
+		}
+		p := newProblem(http.StatusConflict, code, dupErr.Error())
+		p.Violations = []FieldError{{Field: dupErr.Field, Code: CodeDuplicate, Message: dupErr.reason()}}
+		respondWithProblem(w, p)
+	case errors.As(err, &fkErr):
+		respondWithError(w, http.StatusConflict, ProblemForeignKey, fkErr.Error())
+	case errors.As(err, &checkErr):
+		p := newProblem(http.StatusBadRequest, ProblemValidationFailed, checkErr.Error())
+		if checkErr.Field != "" {
+			p.Violations = []FieldError{{Field: checkErr.Field, Code: CodeConstraint, Message: "rejected by the database"}}
+		}
+		respondWithProblem(w, p)
+	case errors.Is(err, ErrDeadlock):
+		log.Printf("%s (request %s): %v", detail, w.Header().Get(requestIDHeader), err)
+		w.Header().Set("Retry-After", "1")
+		respondWithError(w, http.StatusServiceUnavailable, ProblemBusy, detail+": the database is busy, please retry")
+	default:
+		respondWithInternalError(w, detail, err)
+	}
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, http.StatusNotFound, ProblemNotFound, fmt.Sprintf("No route for %s %s", r.Method, r.URL.Path))
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, http.StatusMethodNotAllowed, ProblemMethodNotAllowed, fmt.Sprintf("%s is not allowed on %s", r.Method, r.URL.Path))
+}
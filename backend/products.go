@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Product Updates ---
+
+// patchableProductFields are the Product JSON keys a merge patch may touch.
+// product_name is refused later for products bought by SKU.
+var patchableProductFields = map[string]bool{
+	"product_name": true,
+	"quantity":     true,
+	"price":        true,
+}
+
+// QuantityAdjustment is the body of POST .../adjust.
+type QuantityAdjustment struct {
+	Delta *int `json:"delta"`
+}
+
+// parseProductPath reads {customer_id} and {product_id}, answering 400 if
+// either is malformed.
+func parseProductPath(w http.ResponseWriter, r *http.Request) (int64, int, bool) {
+	vars := mux.Vars(r)
+	customerID, err := parseCustomerID(vars["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return 0, 0, false
+	}
+	productID, err := strconv.Atoi(vars["product_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, "Invalid product ID format")
+		return 0, 0, false
+	}
+	return customerID, productID, true
+}
+
+// replaceProduct applies a PUT body to the stored product. The SKU cannot
+// change, and a product bought by SKU keeps its catalog name when the body
+// leaves product_name out.
+func replaceProduct(current *Product, body Product) error {
+	if body.SKU != nil && (current.SKU == nil || normalizeSKU(*body.SKU) != *current.SKU) {
+		return &ValidationError{Fields: []FieldError{{Field: "sku", Code: CodeReadOnly, Message: "cannot be changed"}}}
+	}
+	if body.ProductName != "" || current.SKU == nil {
+		current.ProductName = body.ProductName
+	}
+	current.Quantity, current.Price = body.Quantity, body.Price
+	return nil
+}
+
+// applyProductPatch returns current with the merge patch applied. Only
+// patchableProductFields may appear in the patch. A price patch merges into
+// the stored price, so {"price": {"amount": "10.00"}} keeps the currency.
+func applyProductPatch(current Product, patch map[string]interface{}) (Product, error) {
+	var rejected []string
+	for key := range patch {
+		if !patchableProductFields[key] {
+			rejected = append(rejected, key)
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		errs := &ValidationError{}
+		for _, key := range rejected {
+			errs.add(key, CodeReadOnly, "cannot be patched")
+		}
+		return Product{}, errs
+	}
+
+	data, err := json.Marshal(current)
+	if err != nil {
+		return Product{}, err
+	}
+	var doc map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		return Product{}, err
+	}
+
+	merged, err := json.Marshal(mergePatch(doc, patch))
+	if err != nil {
+		return Product{}, err
+	}
+	var patched Product
+	if err := json.Unmarshal(merged, &patched); err != nil {
+		return Product{}, fmt.Errorf("Patched product has invalid field types: %w", err)
+	}
+	return patched, nil
+}
+
+// updateProduct handles PUT /api/products/{customer_id}/{product_id}. The
+// body carries product_name, quantity and price; the product keeps its ID,
+// owner and SKU.
+func (s *server) updateProduct(w http.ResponseWriter, r *http.Request) {
+	customerID, productID, ok := parseProductPath(w, r)
+	if !ok {
+		return
+	}
+	var body Product
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+
+	updated, err := s.store.UpdateProduct(r.Context(), customerID, productID, func(p *Product) error {
+		before := *p
+		if err := replaceProduct(p, body); err != nil {
+			return err
+		}
+		return validateProductUpdate(p, before)
+	})
+	s.respondWithUpdatedProduct(w, updated, err)
+}
+
+// patchProduct handles PATCH /api/products/{customer_id}/{product_id} with an
+// RFC 7396 merge patch of product_name, quantity and price. The patch is
+// merged onto the row while it is locked.
+func (s *server) patchProduct(w http.ResponseWriter, r *http.Request) {
+	customerID, productID, ok := parseProductPath(w, r)
+	if !ok {
+		return
+	}
+	if !acceptMergePatch(w, r) {
+		return
+	}
+
+	var patch map[string]interface{}
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&patch); err != nil || patch == nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidJSON, "Invalid request payload: a merge patch must be a JSON object")
+		return
+	}
+
+	var decodeErr error
+	updated, err := s.store.UpdateProduct(r.Context(), customerID, productID, func(p *Product) error {
+		patched, err := applyProductPatch(*p, patch)
+		if err != nil {
+			var verr *ValidationError
+			if !errors.As(err, &verr) {
+				decodeErr = err
+			}
+			return err
+		}
+		before := *p
+		*p = patched
+		return validateProductUpdate(p, before)
+	})
+	if decodeErr != nil {
+		respondWithDecodeError(w, decodeErr)
+		return
+	}
+	s.respondWithUpdatedProduct(w, updated, err)
+}
+
+// adjustProductQuantity handles POST
+// /api/products/{customer_id}/{product_id}/adjust with {"delta": n}. The new
+// quantity is computed from the locked row, so concurrent adjustments add up
+// instead of overwriting each other, and one that would take the quantity
+// below zero is refused with 409. One that would take it above MaxInt32 is a
+// validation error.
+func (s *server) adjustProductQuantity(w http.ResponseWriter, r *http.Request) {
+	customerID, productID, ok := parseProductPath(w, r)
+	if !ok {
+		return
+	}
+	var adj QuantityAdjustment
+	if err := json.NewDecoder(r.Body).Decode(&adj); err != nil {
+		respondWithDecodeError(w, err)
+		return
+	}
+	if adj.Delta == nil || *adj.Delta == 0 {
+		respondWithValidationError(w, &ValidationError{Fields: []FieldError{
+			{Field: "delta", Code: CodeRequired, Message: "must be a non-zero integer"},
+		}})
+		return
+	}
+
+	var available int
+	updated, err := s.store.UpdateProduct(r.Context(), customerID, productID, func(p *Product) error {
+		// Compare before adding: a large delta would overflow the sum.
+		if *adj.Delta < -p.Quantity {
+			available = p.Quantity
+			return ErrQuantityBelowZero
+		}
+		if *adj.Delta > math.MaxInt32-p.Quantity {
+			return &ValidationError{Fields: []FieldError{{Field: "delta", Code: CodeRange,
+				Message: fmt.Sprintf("would take the quantity above %d", math.MaxInt32)}}}
+		}
+		before := *p
+		p.Quantity += *adj.Delta
+		return validateProductUpdate(p, before)
+	})
+	if errors.Is(err, ErrQuantityBelowZero) {
+		respondWithError(w, http.StatusConflict, ProblemQuantityBelowZero,
+			fmt.Sprintf("Cannot adjust quantity %d by %d: it would go below zero", available, *adj.Delta))
+		return
+	}
+	s.respondWithUpdatedProduct(w, updated, err)
+}
+
+// respondWithUpdatedProduct answers PUT, PATCH and adjust with the stored
+// product, and drops the owner's cached product list.
+func (s *server) respondWithUpdatedProduct(w http.ResponseWriter, updated Product, err error) {
+	var verr *ValidationError
+	switch {
+	case errors.As(err, &verr):
+		respondWithValidationError(w, verr)
+		return
+	case errors.Is(err, ErrProductNotFound):
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Product not found for the given customer")
+		return
+	case err != nil:
+		respondWithStoreError(w, "Failed to update product", err)
+		return
+	}
+
+	s.invalidateProducts(updated.CustomerID)
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message: fmt.Sprintf("Product ID %d for Customer ID %d updated successfully", updated.ProductID, updated.CustomerID),
+		Product: &updated,
+	})
+}
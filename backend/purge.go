@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// --- Soft-Delete Retention ---
+
+// purgeBatchSize bounds how many customers a single purge transaction removes.
+const purgeBatchSize = 500
+
+// purgeConfigFromEnv reads SOFT_DELETE_RETENTION (default 30 days) and
+// PURGE_INTERVAL (default 1h; "0" disables the background purger).
+func purgeConfigFromEnv() (retention, interval time.Duration, err error) {
+	retention, err = time.ParseDuration(getEnv("SOFT_DELETE_RETENTION", "720h"))
+	if err != nil || retention <= 0 {
+		return 0, 0, fmt.Errorf("SOFT_DELETE_RETENTION must be a positive duration such as 720h")
+	}
+	interval, err = time.ParseDuration(getEnv("PURGE_INTERVAL", "1h"))
+	if err != nil || interval < 0 {
+		return 0, 0, fmt.Errorf("PURGE_INTERVAL must be a duration such as 1h, or 0 to disable")
+	}
+	return retention, interval, nil
+}
+
+// runPurger permanently removes customers soft-deleted more than retention
+// ago, and expired idempotency keys, once per interval, until ctx is
+// cancelled.
+func (s *server) runPurger(ctx context.Context, retention, interval time.Duration) {
+	log.Printf("Purging soft-deleted customers older than %v every %v", retention, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := s.purgeDeleted(ctx, time.Now().Add(-retention)); err != nil {
+			log.Printf("Purge failed after removing %d customers: %v", n, err)
+		} else if n > 0 {
+			log.Printf("Purged %d soft-deleted customers", n)
+		}
+		if n, err := s.store.PurgeIdempotencyKeys(ctx, time.Now().Add(-idempotencyTTL)); err != nil {
+			log.Printf("Purging expired idempotency keys failed: %v", err)
+		} else if n > 0 {
+			log.Printf("Purged %d expired idempotency keys", n)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// purgeDeleted works through the backlog in batches, invalidating the cache
+// keys of each purged customer as it goes.
+func (s *server) purgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	total := 0
+	for {
+		purged, err := s.store.PurgeDeletedCustomers(ctx, deletedBefore, purgeBatchSize)
+		if err != nil {
+			return total, err
+		}
+		for _, c := range purged {
+			s.invalidateCustomer(c)
+		}
+		total += len(purged)
+		if len(purged) < purgeBatchSize {
+			return total, nil
+		}
+	}
+}
@@ -21,20 +21,42 @@ CREATE TABLE IF NOT EXISTS customers (
     email VARCHAR(100),
 
     -- ID documents: Match Go struct and DESCRIBE output field names (passportID, aadharID, drivingLicenseID)
-    -- They are defined as UNIQUE keys, as per your DESCRIBE output.
-    passportID VARCHAR(50) UNIQUE,
-    aadharID VARCHAR(50) UNIQUE,
-    drivingLicenseID VARCHAR(50) UNIQUE,
+    -- Stored envelope-encrypted (AES-GCM, "enc1:<key id>:..."), so the ciphertext is random.
+    -- Uniqueness and exact lookups use the keyed HMAC in the matching *_hash column instead.
+    passportID VARCHAR(512),
+    aadharID VARCHAR(512),
+    drivingLicenseID VARCHAR(512),
+    passport_hash CHAR(64) UNIQUE,
+    aadhar_hash CHAR(64) UNIQUE,
+    driving_license_hash CHAR(64) UNIQUE,
     
     -- Missing fields from DESCRIBE but present in Go struct / common practice:
     -- If your Go struct uses 'CreatedAt', you should explicitly add it.
     -- Assuming created_at is desired (from your original schema, though not in the DESCRIBE output)
     created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP, 
 
+    -- Soft delete: set by DELETE /api/customers/{id}, cleared by .../restore,
+    -- and rows older than SOFT_DELETE_RETENTION are purged by the backend.
+    -- ID documents stay UNIQUE while a customer is soft-deleted so a restore can never collide.
+    deleted_at TIMESTAMP(6) NULL DEFAULT NULL,
+    INDEX idx_customers_deleted_at (deleted_at),
+
+    -- Optimistic concurrency: bumped on every change and exposed as the ETag
+    version INT(11) NOT NULL DEFAULT 1,
+
+    -- Keyset pagination indexes for GET /api/customers/all (sort key + customer_id tie-breaker)
+    INDEX idx_customers_name (name, customer_id),
+    INDEX idx_customers_age (age, customer_id),
+    INDEX idx_customers_created_at (created_at, customer_id),
+
+    -- Free-text search for GET /api/customers/find (prefix matching in boolean mode)
+    FULLTEXT INDEX ft_customers_text (name, address, email),
+
     -- Removed the pan_card field, as it was not present in your DESCRIBE output.
     
-    -- The CHECK constraint from your original schema is good practice to enforce ID requirement:
-    CHECK (
+    -- The CHECK constraint from your original schema is good practice to enforce ID requirement.
+    -- It is named so the backend can report which field it guards (mysql_errors.go).
+    CONSTRAINT chk_customers_id_document CHECK (
         aadharID IS NOT NULL OR 
         passportID IS NOT NULL OR 
         drivingLicenseID IS NOT NULL
@@ -43,6 +65,21 @@ CREATE TABLE IF NOT EXISTS customers (
     
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
+-- Product catalog. Products bought by SKU copy name and list_price at purchase
+-- time; items that have been bought can only be deactivated, not deleted.
+CREATE TABLE IF NOT EXISTS catalog_items (
+    sku VARCHAR(64) NOT NULL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    category VARCHAR(50) NOT NULL,
+    list_price_minor BIGINT(20) NOT NULL,   -- in minor units of currency, like products.price_minor
+    currency CHAR(3) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+
+    INDEX idx_catalog_items_category (category, sku)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
 -- 3. Create products table (MISSING TABLE ADDED)
 -- Remodeled CREATE TABLE statement for the 'products' table
 
@@ -53,21 +90,161 @@ CREATE TABLE IF NOT EXISTS products (
     -- Foreign Key: Matches the desired schema (customer_id BIGINT(20) MUL)
     customer_id BIGINT(20), 
     
+    -- Catalog item the product was bought as; NULL for free-text products
+    -- created before the catalog (see `main link-catalog`).
+    sku VARCHAR(64) NULL,
+
     -- Data Fields: Match the desired schema's types and lengths
     product_name VARCHAR(100),
     quantity INT(11),
-    price DOUBLE,
+    -- Exact unit price in minor units (paise for INR) of an ISO 4217 currency.
+    -- Replaces the DOUBLE price column, whose sums drifted by fractions of a paisa.
+    price_minor BIGINT(20) NOT NULL,
+    currency CHAR(3) NOT NULL,
+
+    -- When the product was bought; NULL for products recorded before this column existed.
+    created_at TIMESTAMP NULL DEFAULT CURRENT_TIMESTAMP,
+
+    -- Stamped with the owning customer's deleted_at when the customer is soft-deleted
+    deleted_at TIMESTAMP(6) NULL DEFAULT NULL,
     
     -- Define Foreign Key relationship: 
     -- CRITICAL FIX: Reference the correct column name (customer_id) in the 'customers' table.
     FOREIGN KEY (customer_id) 
         REFERENCES customers(customer_id) -- Ensures it points to the customers table's primary key
         ON DELETE CASCADE 
-        ON UPDATE CASCADE -- Added ON UPDATE CASCADE as good practice for FKs
-        
+        ON UPDATE CASCADE, -- Added ON UPDATE CASCADE as good practice for FKs
+    CONSTRAINT fk_products_sku FOREIGN KEY (sku) REFERENCES catalog_items(sku)
+
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Orders. Like products they are stamped with the customer's deleted_at on
+-- soft delete and removed with the customer on purge. Lines are written once
+-- when the order is created; total_minor is the sum of their line totals.
+CREATE TABLE IF NOT EXISTS orders (
+    order_id BIGINT(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    customer_id BIGINT(20) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    total_minor BIGINT(20) NOT NULL,        -- in minor units of currency, like products.price_minor
+    currency CHAR(3) NOT NULL,              -- every line of an order is in this currency
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    deleted_at TIMESTAMP(6) NULL DEFAULT NULL,
+
+    INDEX idx_orders_customer (customer_id, order_id),
+    CONSTRAINT fk_orders_customer FOREIGN KEY (customer_id) REFERENCES customers(customer_id)
+        ON DELETE CASCADE
+        ON UPDATE CASCADE,
+    CONSTRAINT chk_orders_status CHECK (status IN ('draft', 'placed', 'paid', 'shipped', 'cancelled'))
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+CREATE TABLE IF NOT EXISTS order_lines (
+    order_id BIGINT(20) NOT NULL,
+    line_no INT(11) NOT NULL,
+    sku VARCHAR(64) NULL,                   -- NULL for free-text lines, as in products
+    product_name VARCHAR(100) NOT NULL,
+    quantity INT(11) NOT NULL,
+    unit_price_minor BIGINT(20) NOT NULL,   -- in orders.currency
+    line_total_minor BIGINT(20) NOT NULL,
+
+    PRIMARY KEY (order_id, line_no),
+    CONSTRAINT fk_order_lines_order FOREIGN KEY (order_id) REFERENCES orders(order_id)
+        ON DELETE CASCADE,
+    CONSTRAINT fk_order_lines_sku FOREIGN KEY (sku) REFERENCES catalog_items(sku)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Append-only audit trail of every customer/product/order mutation, written
+-- in the same transaction as the change. No foreign key: history must outlive
+-- the customer it describes.
+CREATE TABLE IF NOT EXISTS audit_log (
+    audit_id BIGINT(20) NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    occurred_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    actor VARCHAR(100) NOT NULL,
+    actor_role VARCHAR(20),
+    action VARCHAR(50) NOT NULL,            -- e.g. customer.update, product.delete, data.flush
+    entity_type VARCHAR(20) NOT NULL,       -- customer | product | catalog_item | order | system
+    entity_id VARCHAR(64) NOT NULL,
+    customer_id BIGINT(20),                 -- owning customer, for GET /api/customers/{id}/history
+    before_data JSON,
+    after_data JSON,
+    request_id VARCHAR(64),
+
+    INDEX idx_audit_customer (customer_id, audit_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+
+-- Reject any attempt to rewrite history.
+CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+    FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+-- Counter for CUSTOMER_ID_STRATEGY=sequence. The backend reserves blocks of
+-- CUSTOMER_ID_BLOCK_SIZE bodies from next_value and appends a check digit.
+CREATE TABLE IF NOT EXISTS customer_id_seq (
+    name VARCHAR(50) NOT NULL PRIMARY KEY,
+    next_value BIGINT(20) NOT NULL
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
+INSERT IGNORE INTO customer_id_seq (name, next_value) VALUES ('customer', 100000000);
+
+-- First responses to requests sent with an Idempotency-Key header, replayed
+-- to retries for 24 hours. status is NULL while the first request is in flight.
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    idempotency_key CHAR(64) NOT NULL PRIMARY KEY,  -- keyed hash of caller and header value
+    fingerprint CHAR(64) NOT NULL,                  -- keyed hash of method, path and body
+    status INT(11),
+    headers JSON,
+    body MEDIUMTEXT,                                -- sealed like the ID documents
+    created_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+
+    INDEX idx_idempotency_created_at (created_at)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
 
 -- Sample queries for testing
 -- INSERT INTO customers (name, age, address, aadhar) VALUES ('John Doe', 30, '123 Main St', '123456789012');
 -- SELECT * FROM customers WHERE aadhar = '123456789012';
--- SELECT * FROM customers WHERE passport = 'A1234567';
\ No newline at end of file
+-- SELECT * FROM customers WHERE passport = 'A1234567';
+
+-- Upgrades for databases created from an earlier version of this file.
+-- docker-entrypoint-initdb.d only runs on an empty volume, so apply these by hand;
+-- every statement is idempotent.
+-- Customer IDs issued before check digits were introduced mostly fail the check, so keep
+-- CUSTOMER_ID_CHECK_DIGIT unset (off) on upgraded databases.
+CREATE INDEX IF NOT EXISTS idx_customers_name ON customers (name, customer_id);
+CREATE INDEX IF NOT EXISTS idx_customers_age ON customers (age, customer_id);
+CREATE INDEX IF NOT EXISTS idx_customers_created_at ON customers (created_at, customer_id);
+CREATE FULLTEXT INDEX IF NOT EXISTS ft_customers_text ON customers (name, address, email);
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(6) NULL DEFAULT NULL;
+CREATE INDEX IF NOT EXISTS idx_customers_deleted_at ON customers (deleted_at);
+ALTER TABLE products ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP(6) NULL DEFAULT NULL;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS version INT(11) NOT NULL DEFAULT 1;
+-- Field-level encryption of ID documents. After applying these, run `main rekey-pii`
+-- to encrypt existing rows and fill in their hashes.
+ALTER TABLE customers MODIFY passportID VARCHAR(512), MODIFY aadharID VARCHAR(512), MODIFY drivingLicenseID VARCHAR(512);
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS passport_hash CHAR(64) NULL;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS aadhar_hash CHAR(64) NULL;
+ALTER TABLE customers ADD COLUMN IF NOT EXISTS driving_license_hash CHAR(64) NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS passport_hash ON customers (passport_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS aadhar_hash ON customers (aadhar_hash);
+CREATE UNIQUE INDEX IF NOT EXISTS driving_license_hash ON customers (driving_license_hash);
+-- Name the ID document CHECK constraint (MariaDB called it CONSTRAINT_1) so violations map to a field.
+ALTER TABLE customers DROP CONSTRAINT IF EXISTS CONSTRAINT_1, DROP CONSTRAINT IF EXISTS chk_customers_id_document,
+    ADD CONSTRAINT chk_customers_id_document CHECK (aadharID IS NOT NULL OR passportID IS NOT NULL OR drivingLicenseID IS NOT NULL);
+-- Product catalog (the catalog_items table above). After applying these, load the
+-- catalog and run `main link-catalog` to set the SKU of existing products by name.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS sku VARCHAR(64) NULL AFTER customer_id;
+ALTER TABLE products ADD CONSTRAINT fk_products_sku FOREIGN KEY IF NOT EXISTS (sku) REFERENCES catalog_items(sku);
+ALTER TABLE audit_log MODIFY entity_id VARCHAR(64) NOT NULL;
+-- Exact money. Add the minor-unit columns, then run `main migrate-money -currency INR` before
+-- starting the new backend; it converts the DOUBLE prices and prints every row rounding changed
+-- (try -dry-run first).
+ALTER TABLE products ADD COLUMN IF NOT EXISTS price_minor BIGINT(20) NULL AFTER quantity,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NULL AFTER price_minor;
+ALTER TABLE catalog_items ADD COLUMN IF NOT EXISTS list_price_minor BIGINT(20) NULL AFTER category,
+    ADD COLUMN IF NOT EXISTS currency CHAR(3) NULL AFTER list_price_minor;
+-- Once `main migrate-money` has finished, apply schema_post_migrate_money.sql to make the new
+-- columns NOT NULL and drop the DOUBLE ones.
+-- Purchase times for the customer summary. Existing products keep NULL: when they were bought is unknown.
+ALTER TABLE products ADD COLUMN IF NOT EXISTS created_at TIMESTAMP NULL DEFAULT NULL AFTER currency;
+ALTER TABLE products ALTER COLUMN created_at SET DEFAULT CURRENT_TIMESTAMP;
+-- Only once `main rekey-pii` has finished (plaintext rows still rely on these):
+ALTER TABLE customers DROP INDEX IF EXISTS passportID, DROP INDEX IF EXISTS aadharID, DROP INDEX IF EXISTS drivingLicenseID;
@@ -0,0 +1,10 @@
+-- Finishes the exact-money upgrade of schema.sql on databases created while prices were DOUBLE.
+-- Run this only once `main migrate-money` (without -dry-run) has finished: it makes the
+-- minor-unit columns mandatory and drops the old DOUBLE columns, so rows not yet converted
+-- would fail the MODIFY and their old prices would be lost with the DROP.
+-- New databases already have the final columns and do not need it. Every statement is idempotent.
+USE customerDB;
+
+ALTER TABLE products MODIFY price_minor BIGINT(20) NOT NULL, MODIFY currency CHAR(3) NOT NULL, DROP COLUMN IF EXISTS price;
+ALTER TABLE catalog_items MODIFY list_price_minor BIGINT(20) NOT NULL, MODIFY currency CHAR(3) NOT NULL,
+    DROP COLUMN IF EXISTS list_price;
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// --- Free-Text Customer Search ---
+
+// CustomerSearch is a ranked, offset-paginated free-text query over name,
+// address, email and phone number.
+type CustomerSearch struct {
+	Query  string
+	Limit  int
+	Offset int
+}
+
+// SearchInfo is the paging metadata returned with search results.
+type SearchInfo struct {
+	Query      string `json:"query"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	HasMore    bool   `json:"has_more"`
+	NextOffset *int   `json:"next_offset,omitempty"`
+}
+
+const minSearchQueryLength = 2
+
+// searchTerms lower-cases q and splits it into words. Characters with a
+// meaning in MySQL boolean full-text syntax are treated as separators so user
+// input can never change the query semantics.
+func searchTerms(q string) []string {
+	return strings.FieldsFunc(strings.ToLower(q), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '.' && r != '_'
+	})
+}
+
+// searchDigits returns the digits of q when q looks like a phone number
+// fragment (only digits, spaces, dashes, parentheses and a leading +).
+func searchDigits(q string) string {
+	var b strings.Builder
+	for _, r := range q {
+		switch {
+		case unicode.IsDigit(r):
+			b.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '+':
+		default:
+			return ""
+		}
+	}
+	return b.String()
+}
+
+// likeEscaper escapes user input for a LIKE pattern, so a "%" or "_" in the
+// query matches itself instead of any text.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// Relevance bonuses for whole-query prefix, phone and phonetic matches.
+// Both stores add the prefix and phone bonuses; only MySQL uses the phonetic
+// one, since scoreCustomer handles typos with edit distance instead.
+const (
+	searchWeightPhone       = 5.0
+	searchWeightNamePrefix  = 4.0
+	searchWeightEmailPrefix = 3.0
+	searchWeightFuzzyName   = 1.0
+)
+
+// scoreCustomer ranks c against the search terms (used by memoryStore).
+// A term scores on a word that equals it, starts with it, or is within a
+// small edit distance of it; name hits count more than email and address.
+func scoreCustomer(c Customer, q string) float64 {
+	terms := searchTerms(q)
+	score := 0.0
+
+	fields := []struct {
+		words  []string
+		weight float64
+	}{
+		{searchTerms(c.Name), 3},
+		{searchTerms(derefString(c.Email)), 2},
+		{searchTerms(c.Address), 1},
+	}
+	for _, term := range terms {
+		for _, f := range fields {
+			score += f.weight * bestWordMatch(term, f.words)
+		}
+	}
+
+	if strings.HasPrefix(strings.ToLower(c.Name), strings.ToLower(strings.TrimSpace(q))) {
+		score += searchWeightNamePrefix
+	}
+	if c.Email != nil && strings.HasPrefix(strings.ToLower(*c.Email), strings.ToLower(strings.TrimSpace(q))) {
+		score += searchWeightEmailPrefix
+	}
+	if digits := searchDigits(q); len(digits) >= 3 && c.PhoneNumber != nil &&
+		strings.Contains(searchDigits(*c.PhoneNumber), digits) {
+		score += searchWeightPhone
+	}
+	return score
+}
+
+// bestWordMatch scores the closest word to term: 1 exact, 0.75 prefix,
+// 0.5 for a typo-level edit distance, else 0.
+func bestWordMatch(term string, words []string) float64 {
+	best := 0.0
+	for _, w := range words {
+		switch {
+		case w == term:
+			return 1
+		case strings.HasPrefix(w, term):
+			best = max(best, 0.75)
+		case len(term) >= 4 && levenshtein(term, w) <= fuzzyDistance(term):
+			best = max(best, 0.5)
+		}
+	}
+	return best
+}
+
+func fuzzyDistance(term string) int {
+	if len(term) >= 7 {
+		return 2
+	}
+	return 1
+}
+
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func derefString(p *string) string {
+	if p == nil {
+		return ""
+	}
+	return *p
+}
+
+// rankCustomers scores, filters and orders candidates for a memory search.
+func rankCustomers(candidates []Customer, q CustomerSearch) []Customer {
+	type scored struct {
+		c     Customer
+		score float64
+	}
+	var hits []scored
+	for _, c := range candidates {
+		if s := scoreCustomer(c, q.Query); s > 0 {
+			hits = append(hits, scored{c, s})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].score != hits[j].score {
+			return hits[i].score > hits[j].score
+		}
+		return hits[i].c.CustomerID > hits[j].c.CustomerID
+	})
+
+	results := []Customer{}
+	for i := q.Offset; i < len(hits) && i < q.Offset+q.Limit+1; i++ {
+		results = append(results, hits[i].c)
+	}
+	return results
+}
+
+// parseCustomerSearch reads q, limit and offset.
+func parseCustomerSearch(r *http.Request) (CustomerSearch, error) {
+	params := r.URL.Query()
+	search := CustomerSearch{Query: strings.TrimSpace(params.Get("q")), Limit: defaultPageLimit}
+
+	if len([]rune(search.Query)) < minSearchQueryLength {
+		return search, fmt.Errorf("q must be at least %d characters", minSearchQueryLength)
+	}
+	if len(searchTerms(search.Query)) == 0 {
+		return search, errors.New("q must contain letters or digits")
+	}
+	if v := params.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			return search, errors.New("limit must be a positive integer")
+		}
+		search.Limit = min(limit, maxPageLimit)
+	}
+	if v := params.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return search, errors.New("offset must be a non-negative integer")
+		}
+		search.Offset = offset
+	}
+	return search, nil
+}
+
+// searchCustomers handles GET /api/customers/find?q=...
+func (s *server) searchCustomers(w http.ResponseWriter, r *http.Request) {
+	search, err := parseCustomerSearch(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	// Stores return up to Limit+1 rows so we know whether another page exists.
+	customers, err := s.store.SearchCustomers(r.Context(), search)
+	if err != nil {
+		respondWithStoreError(w, "Failed to search customers", err)
+		return
+	}
+
+	info := &SearchInfo{Query: search.Query, Limit: search.Limit, Offset: search.Offset}
+	if len(customers) > search.Limit {
+		customers = customers[:search.Limit]
+		next := search.Offset + search.Limit
+		info.HasMore, info.NextOffset = true, &next
+	}
+
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Message:   fmt.Sprintf("Found %d matching customers", len(customers)),
+		Customers: shape.views(customers),
+		Search:    info,
+	})
+}
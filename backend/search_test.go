@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// The memory store ranks by scoreCustomer and breaks ties on the newer
+// customer_id, so this ordering is fixed for any run.
+func TestMemorySearchCustomersOrdering(t *testing.T) {
+	s := newMemoryStore()
+	s.ids = newSequenceIDGenerator(2, memorySequence())
+	email := "asha.k@example.com"
+	for _, c := range []Customer{
+		{Name: "Mohan Das", Address: "4 Asha Nagar"},              // address word: 1
+		{Name: "Asha Rao", Address: "12 MG Road"},                 // name word and name prefix: 3+4
+		{Name: "Ravi Kumar", Address: "1 Park St", Email: &email}, // email word prefix and email prefix: 1.5+3
+		{Name: "Ravi Ashar", Address: "7 Hill Rd"},                // name word prefix: 2.25
+		{Name: "Usha Menon", Address: "9 Lake View"},              // one edit from a name word: 1.5
+		{Name: "Gopal Das", Address: "5 Asha Nagar"},              // ties with Mohan Das, newer ID
+		{Name: "Ravi Kumar", Address: "3 Church St"},              // no match
+	} {
+		c.Age = 30
+		if err := s.CreateCustomer(context.Background(), &c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		offset, limit int
+		want          []string
+	}{
+		{0, 10, []string{"Asha Rao", "Ravi Kumar", "Ravi Ashar", "Usha Menon", "Gopal Das", "Mohan Das"}},
+		{1, 2, []string{"Ravi Kumar", "Ravi Ashar", "Usha Menon"}}, // Limit+1 rows
+	} {
+		got, err := s.SearchCustomers(context.Background(), CustomerSearch{Query: "asha", Limit: tc.limit, Offset: tc.offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, c := range got {
+			names = append(names, c.Name)
+		}
+		if len(names) != len(tc.want) {
+			t.Fatalf("offset %d limit %d: got %q, want %q", tc.offset, tc.limit, names, tc.want)
+		}
+		for i := range names {
+			if names[i] != tc.want[i] {
+				t.Errorf("offset %d limit %d: got %q, want %q", tc.offset, tc.limit, names, tc.want)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// --- Repository Interfaces ---
+
+// Lookup types accepted by GetCustomer. They match the `type` query parameter
+// of GET /api/customers/search and the middle segment of the cache keys.
+const (
+	LookupCustomerID     = "customer_id"
+	LookupAadhar         = "aadhar"
+	LookupPassport       = "passport"
+	LookupDrivingLicense = "driving_license"
+)
+
+var (
+	ErrCustomerNotFound    = errors.New("customer not found")
+	ErrProductNotFound     = errors.New("product not found for the given customer")
+	ErrDuplicateIDDocument = errors.New("ID document already exists in database")
+	ErrInvalidLookupType   = errors.New("invalid lookup type")
+	ErrCustomerNotDeleted  = errors.New("customer is not deleted")
+	ErrVersionMismatch     = errors.New("customer has been modified since it was read")
+	ErrQuantityBelowZero   = errors.New("quantity cannot go below zero")
+	// ErrDeadlock is returned once a transaction has lost every retry to a
+	// deadlock; the request can be retried later.
+	ErrDeadlock = errors.New("transaction kept deadlocking")
+)
+
+// DuplicateKeyError reports a unique-key collision. Field is the Customer
+// JSON name of the colliding column. For an ID document it matches
+// ErrDuplicateIDDocument under errors.Is.
+type DuplicateKeyError struct {
+	Field string
+}
+
+func (e *DuplicateKeyError) Error() string {
+	return e.Field + " " + e.reason()
+}
+
+func (e *DuplicateKeyError) reason() string {
+	if errors.Is(e, ErrDuplicateIDDocument) {
+		return "already registered to another customer"
+	}
+	return "already exists"
+}
+
+func (e *DuplicateKeyError) Is(target error) bool {
+	_, isDocument := documentJSONFields[e.Field]
+	return target == ErrDuplicateIDDocument && isDocument
+}
+
+// ForeignKeyError reports a broken reference. When Referenced is false, a
+// row in Table points through Column at a parent that does not exist. When
+// it is true, the row being deleted or re-keyed is still referenced from
+// Table.Column. A missing customer matches ErrCustomerNotFound.
+type ForeignKeyError struct {
+	Table      string
+	Column     string
+	Referenced bool
+}
+
+func (e *ForeignKeyError) Error() string {
+	if e.Referenced {
+		return fmt.Sprintf("still referenced by %s.%s", e.Table, e.Column)
+	}
+	return fmt.Sprintf("%s.%s refers to a row that does not exist", e.Table, e.Column)
+}
+
+func (e *ForeignKeyError) Is(target error) bool {
+	return target == ErrCustomerNotFound && !e.Referenced && e.Column == "customer_id"
+}
+
+// CheckViolationError reports a row rejected by a CHECK constraint. Field is
+// the request field the constraint guards, or "" for an unknown constraint.
+type CheckViolationError struct {
+	Constraint string
+	Field      string
+}
+
+func (e *CheckViolationError) Error() string {
+	return fmt.Sprintf("check constraint %s failed", e.Constraint)
+}
+
+// CustomerStore covers every read and write the API performs on customers.
+type CustomerStore interface {
+	// CreateCustomer assigns a new customer_id, persists the customer and
+	// fills in CustomerID and CreatedAt on the passed value.
+	CreateCustomer(ctx context.Context, customer *Customer) error
+	// ImportCustomers creates a batch of already-validated customers in one
+	// transaction, filling in CustomerID and CreatedAt of each created
+	// element. The returned slice has one entry per customer: nil when it was
+	// created, a *DuplicateKeyError matching ErrDuplicateIDDocument when one
+	// of its ID documents is taken (by an existing customer or an earlier
+	// element of the batch). With
+	// dryRun the transaction is rolled back, so the result reports what would
+	// happen without writing anything.
+	ImportCustomers(ctx context.Context, customers []Customer, dryRun bool) ([]error, error)
+	// GetCustomer looks a customer up by one of the Lookup* types.
+	GetCustomer(ctx context.Context, lookupType, value string) (Customer, error)
+	// ListCustomers returns one keyset-paginated, filtered page.
+	ListCustomers(ctx context.Context, opts CustomerListOptions) (CustomerPage, error)
+	// SearchCustomers returns up to Limit+1 customers matching a free-text
+	// query, most relevant first.
+	SearchCustomers(ctx context.Context, search CustomerSearch) ([]Customer, error)
+	// ExportCustomers calls fn for every live customer matching filter, in
+	// customer_id order, together with its live products. Rows are streamed
+	// rather than loaded up front; iteration stops at the first error from fn.
+	ExportCustomers(ctx context.Context, filter CustomerFilter, fn func(Customer, []Product) error) error
+	// UpdateCustomer overwrites all mutable columns, bumps the version and
+	// returns the stored row. If expectedVersion is non-zero and differs from
+	// the stored version it fails with ErrVersionMismatch and returns the
+	// current row instead.
+	UpdateCustomer(ctx context.Context, customer Customer, expectedVersion int) (Customer, error)
+	// DeleteCustomer soft-deletes the customer, its products and its orders,
+	// returning the row as it was before deletion so callers can invalidate
+	// caches.
+	// Soft-deleted rows are invisible to every other read.
+	DeleteCustomer(ctx context.Context, customerID int64) (Customer, error)
+	// RestoreCustomer undoes DeleteCustomer, including the products and
+	// orders deleted with it. It fails with ErrCustomerNotDeleted for a live
+	// customer.
+	RestoreCustomer(ctx context.Context, customerID int64) (Customer, error)
+	// PurgeDeletedCustomers permanently removes up to limit customers (and
+	// their products and orders) soft-deleted before deletedBefore, returning
+	// them.
+	PurgeDeletedCustomers(ctx context.Context, deletedBefore time.Time, limit int) ([]Customer, error)
+}
+
+// ProductStore covers every read and write the API performs on products.
+type ProductStore interface {
+	// AddProduct persists the product and fills in ProductID. It fails with
+	// ErrCustomerNotFound if the owning customer does not exist. A product
+	// with a SKU gets the name and list price of that catalog item, or fails
+	// with ErrCatalogItemNotFound or ErrCatalogItemInactive.
+	AddProduct(ctx context.Context, product *Product) error
+	ListProducts(ctx context.Context, customerID int64) ([]Product, error)
+	// UpdateProduct locks the customer's live product, passes a copy to
+	// update and stores the name, quantity and price it leaves behind. The
+	// read, update and write are one transaction, so concurrent changes
+	// cannot interleave. An error from update aborts the change and is
+	// returned as is; a product of another customer is ErrProductNotFound.
+	UpdateProduct(ctx context.Context, customerID int64, productID int, update func(*Product) error) (Product, error)
+	DeleteProduct(ctx context.Context, customerID int64, productID int) error
+	// SummarizeProducts aggregates the customer's live products, keeping the
+	// top products ranked by quantity. A customer without products, or one
+	// that does not exist, gets an empty summary.
+	SummarizeProducts(ctx context.Context, customerID int64, top int) (ProductSummary, error)
+}
+
+// Store is the full persistence layer used by the HTTP handlers. Every
+// mutation appends to the audit trail in the same transaction as the change.
+type Store interface {
+	CustomerStore
+	ProductStore
+	AuditStore
+	IdempotencyStore
+	CatalogStore
+	OrderStore
+	// Flush removes every customer, product and order. The audit trail is
+	// kept.
+	Flush(ctx context.Context) error
+}
+
+// errIDGenerationExhausted is returned when every candidate ID collided.
+func errIDGenerationExhausted(retries int) error {
+	return fmt.Errorf("failed to generate unique customer ID after %d retries", retries)
+}
@@ -0,0 +1,824 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// --- In-Memory Store ---
+
+// memoryStore is a Store backed by maps. It mirrors the schema's UNIQUE
+// constraints on the ID documents, the ON DELETE CASCADE from products and
+// orders to customers and the references from products and order lines to
+// catalog items, so handlers behave the same as against MariaDB.
+type memoryStore struct {
+	mu            sync.RWMutex
+	customers     map[int64]Customer
+	products      map[int]Product
+	nextProductID int
+	orders        map[int64]Order
+	nextOrderID   int64
+
+	// Soft-delete markers for products and orders; customers carry DeletedAt
+	// themselves.
+	productDeletedAt map[int]time.Time
+	orderDeletedAt   map[int64]time.Time
+
+	// Unique indexes: document value -> customer_id
+	byAadhar         map[string]int64
+	byPassport       map[string]int64
+	byDrivingLicense map[string]int64
+
+	// Append-only; survives Flush like the audit_log table.
+	auditLog []AuditEntry
+	// Keyed by IdempotencyRecord.Key; also survives Flush.
+	idempotency map[string]IdempotencyRecord
+	// Keyed by SKU; also survives Flush.
+	catalog map[string]CatalogItem
+
+	ids IDGenerator
+}
+
+func newMemoryStore() *memoryStore {
+	s := &memoryStore{
+		ids:         randomIDGenerator{},
+		idempotency: make(map[string]IdempotencyRecord),
+		catalog:     make(map[string]CatalogItem),
+	}
+	s.reset()
+	return s
+}
+
+func (s *memoryStore) reset() {
+	s.customers = make(map[int64]Customer)
+	s.products = make(map[int]Product)
+	s.productDeletedAt = make(map[int]time.Time)
+	s.nextProductID = 1
+	s.orders = make(map[int64]Order)
+	s.orderDeletedAt = make(map[int64]time.Time)
+	s.nextOrderID = 1
+	s.byAadhar = make(map[string]int64)
+	s.byPassport = make(map[string]int64)
+	s.byDrivingLicense = make(map[string]int64)
+}
+
+// audit appends an entry. Callers must hold s.mu for writing.
+func (s *memoryStore) audit(entry AuditEntry) {
+	entry.AuditID = int64(len(s.auditLog) + 1)
+	entry.OccurredAt = time.Now().UTC()
+	s.auditLog = append(s.auditLog, entry)
+}
+
+func copyString(p *string) *string {
+	if p == nil {
+		return nil
+	}
+	v := *p
+	return &v
+}
+
+// cloneCustomer deep-copies the optional fields so callers never share
+// pointers with the stored row.
+func cloneCustomer(c Customer) Customer {
+	c.PhoneNumber = copyString(c.PhoneNumber)
+	c.Email = copyString(c.Email)
+	c.PassportID = copyString(c.PassportID)
+	c.AadharID = copyString(c.AadharID)
+	c.DrivingLicenseID = copyString(c.DrivingLicenseID)
+	if c.DeletedAt != nil {
+		t := *c.DeletedAt
+		c.DeletedAt = &t
+	}
+	return c
+}
+
+// live returns the customer if it exists and is not soft-deleted.
+// Callers must hold s.mu.
+func (s *memoryStore) live(customerID int64) (Customer, bool) {
+	c, ok := s.customers[customerID]
+	return c, ok && c.DeletedAt == nil
+}
+
+// conflicts returns a *DuplicateKeyError if an ID document of c is already
+// held by a customer other than c itself. Callers must hold s.mu.
+func (s *memoryStore) conflicts(c Customer) error {
+	for _, doc := range []struct {
+		field string
+		index map[string]int64
+		value *string
+	}{
+		{"passport_id", s.byPassport, c.PassportID},
+		{"aadhar_id", s.byAadhar, c.AadharID},
+		{"driving_license_id", s.byDrivingLicense, c.DrivingLicenseID},
+	} {
+		if doc.value == nil {
+			continue
+		}
+		if owner, ok := doc.index[*doc.value]; ok && owner != c.CustomerID {
+			return &DuplicateKeyError{Field: doc.field}
+		}
+	}
+	return nil
+}
+
+// index adds (add=true) or removes the unique-index entries for c.
+// Callers must hold s.mu.
+func (s *memoryStore) index(c Customer, add bool) {
+	set := func(index map[string]int64, value *string) {
+		if value == nil {
+			return
+		}
+		if add {
+			index[*value] = c.CustomerID
+		} else {
+			delete(index, *value)
+		}
+	}
+	set(s.byAadhar, c.AadharID)
+	set(s.byPassport, c.PassportID)
+	set(s.byDrivingLicense, c.DrivingLicenseID)
+}
+
+func (s *memoryStore) CreateCustomer(ctx context.Context, customer *Customer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.insertCustomer(ctx, customer)
+}
+
+// insertCustomer assigns an ID to customer and stores it. Callers must hold
+// s.mu for writing.
+func (s *memoryStore) insertCustomer(ctx context.Context, customer *Customer) error {
+	var newID int64
+	for attempt := 1; newID == 0; attempt++ {
+		if attempt > maxIDAttempts {
+			return errIDGenerationExhausted(maxIDAttempts)
+		}
+		id, err := s.ids.NextID(ctx)
+		if err != nil {
+			return err
+		}
+		if _, exists := s.customers[id]; !exists {
+			newID = id
+		}
+	}
+
+	customer.CustomerID = newID
+	if err := s.conflicts(*customer); err != nil {
+		customer.CustomerID = 0
+		return err
+	}
+	customer.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	customer.DeletedAt = nil
+	customer.Version = 1
+
+	stored := cloneCustomer(*customer)
+	s.customers[stored.CustomerID] = stored
+	s.index(stored, true)
+	s.audit(customerAuditEntry(ctx, AuditCustomerCreate, nil, &stored))
+	return nil
+}
+
+func (s *memoryStore) ImportCustomers(ctx context.Context, customers []Customer, dryRun bool) ([]error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Undo everything inserted so far, like a rolled-back transaction.
+	auditLen := len(s.auditLog)
+	var inserted []Customer
+	rollback := func() {
+		for _, c := range inserted {
+			delete(s.customers, c.CustomerID)
+			s.index(c, false)
+		}
+		s.auditLog = s.auditLog[:auditLen]
+	}
+
+	rowErrs := make([]error, len(customers))
+	for i := range customers {
+		err := s.insertCustomer(ctx, &customers[i])
+		if errors.Is(err, ErrDuplicateIDDocument) {
+			rowErrs[i] = err
+			continue
+		} else if err != nil {
+			rollback()
+			return nil, err
+		}
+		inserted = append(inserted, customers[i])
+	}
+	if dryRun {
+		rollback()
+	}
+	return rowErrs, nil
+}
+
+func (s *memoryStore) GetCustomer(ctx context.Context, lookupType, value string) (Customer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var id int64
+	var ok bool
+	switch lookupType {
+	case LookupCustomerID:
+		parsed, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return Customer{}, ErrCustomerNotFound
+		}
+		id, ok = parsed, true
+	case LookupAadhar:
+		id, ok = s.byAadhar[value]
+	case LookupPassport:
+		id, ok = s.byPassport[value]
+	case LookupDrivingLicense:
+		id, ok = s.byDrivingLicense[value]
+	default:
+		return Customer{}, ErrInvalidLookupType
+	}
+
+	customer, exists := s.live(id)
+	if !ok || !exists {
+		return Customer{}, ErrCustomerNotFound
+	}
+	return cloneCustomer(customer), nil
+}
+
+func (s *memoryStore) ListCustomers(ctx context.Context, opts CustomerListOptions) (CustomerPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	desc := opts.scanDesc()
+	var pivot *Customer
+	if opts.Cursor != nil {
+		p, _ := opts.Cursor.pivot()
+		pivot = &p
+	}
+
+	// after reports whether a comes strictly after b in scan order.
+	after := func(a, b Customer) bool {
+		cmp := compareCustomers(a, b, opts.Sort)
+		if desc {
+			return cmp < 0
+		}
+		return cmp > 0
+	}
+
+	customers := []Customer{}
+	for _, c := range s.customers {
+		if c.DeletedAt != nil || !opts.Filter.matches(c) || (pivot != nil && !after(c, *pivot)) {
+			continue
+		}
+		customers = append(customers, cloneCustomer(c))
+	}
+	sort.Slice(customers, func(i, j int) bool {
+		return after(customers[j], customers[i])
+	})
+	if len(customers) > opts.Limit+1 {
+		customers = customers[:opts.Limit+1]
+	}
+	return newCustomerPage(customers, opts), nil
+}
+
+func (s *memoryStore) SearchCustomers(ctx context.Context, search CustomerSearch) ([]Customer, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	candidates := make([]Customer, 0, len(s.customers))
+	for _, c := range s.customers {
+		if c.DeletedAt == nil {
+			candidates = append(candidates, cloneCustomer(c))
+		}
+	}
+	return rankCustomers(candidates, search), nil
+}
+
+func (s *memoryStore) ExportCustomers(ctx context.Context, filter CustomerFilter, fn func(Customer, []Product) error) error {
+	s.mu.RLock()
+	ids := make([]int64, 0, len(s.customers))
+	for id, c := range s.customers {
+		if c.DeletedAt == nil && filter.matches(c) {
+			ids = append(ids, id)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	// Take the lock per customer so a slow consumer doesn't block writers.
+	for _, id := range ids {
+		s.mu.RLock()
+		c, ok := s.live(id)
+		c = cloneCustomer(c)
+		products := s.customerProducts(id)
+		s.mu.RUnlock()
+		if !ok {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(c, products); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateCustomer(ctx context.Context, customer Customer, expectedVersion int) (Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.live(customer.CustomerID)
+	if !ok {
+		return Customer{}, ErrCustomerNotFound
+	}
+	if expectedVersion != 0 && existing.Version != expectedVersion {
+		return cloneCustomer(existing), ErrVersionMismatch
+	}
+	if err := s.conflicts(customer); err != nil {
+		return Customer{}, err
+	}
+
+	customer.CreatedAt = existing.CreatedAt
+	customer.DeletedAt = nil
+	customer.Version = existing.Version + 1
+	stored := cloneCustomer(customer)
+	s.index(existing, false)
+	s.index(stored, true)
+	s.customers[stored.CustomerID] = stored
+	s.audit(customerAuditEntry(ctx, AuditCustomerUpdate, &existing, &stored))
+	return cloneCustomer(stored), nil
+}
+
+func (s *memoryStore) DeleteCustomer(ctx context.Context, customerID int64) (Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.live(customerID)
+	if !ok {
+		return Customer{}, ErrCustomerNotFound
+	}
+
+	// Stamp products with the customer's deleted_at, recording each like mysqlStore does
+	deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+	for _, p := range s.customerProducts(customerID) {
+		s.productDeletedAt[p.ProductID] = deletedAt
+		s.audit(productAuditEntry(ctx, AuditProductDelete, &p, nil))
+	}
+	for _, o := range s.customerOrders(customerID, "") {
+		s.orderDeletedAt[o.OrderID] = deletedAt
+		s.audit(orderAuditEntry(ctx, AuditOrderDelete, &o, nil))
+	}
+	deleted := cloneCustomer(existing)
+	deleted.DeletedAt = &deletedAt
+	deleted.Version++
+	s.customers[customerID] = deleted
+	s.audit(customerAuditEntry(ctx, AuditCustomerDelete, &existing, &deleted))
+	return existing, nil
+}
+
+func (s *memoryStore) RestoreCustomer(ctx context.Context, customerID int64) (Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted, ok := s.customers[customerID]
+	if !ok {
+		return Customer{}, ErrCustomerNotFound
+	}
+	if deleted.DeletedAt == nil {
+		return Customer{}, ErrCustomerNotDeleted
+	}
+
+	for id, at := range s.productDeletedAt {
+		if s.products[id].CustomerID == customerID && at.Equal(*deleted.DeletedAt) {
+			delete(s.productDeletedAt, id)
+		}
+	}
+	for id, at := range s.orderDeletedAt {
+		if s.orders[id].CustomerID == customerID && at.Equal(*deleted.DeletedAt) {
+			delete(s.orderDeletedAt, id)
+		}
+	}
+	restored := cloneCustomer(deleted)
+	restored.DeletedAt = nil
+	restored.Version++
+	s.customers[customerID] = restored
+	s.audit(customerAuditEntry(ctx, AuditCustomerRestore, &deleted, &restored))
+	return cloneCustomer(restored), nil
+}
+
+func (s *memoryStore) PurgeDeletedCustomers(ctx context.Context, deletedBefore time.Time, limit int) ([]Customer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purged := []Customer{}
+	for id, c := range s.customers {
+		if len(purged) == limit {
+			break
+		}
+		if c.DeletedAt == nil || !c.DeletedAt.Before(deletedBefore) {
+			continue
+		}
+		// Emulate ON DELETE CASCADE
+		for pid, p := range s.products {
+			if p.CustomerID == id {
+				delete(s.products, pid)
+				delete(s.productDeletedAt, pid)
+			}
+		}
+		for oid, o := range s.orders {
+			if o.CustomerID == id {
+				delete(s.orders, oid)
+				delete(s.orderDeletedAt, oid)
+			}
+		}
+		s.index(c, false)
+		delete(s.customers, id)
+		s.audit(customerAuditEntry(ctx, AuditCustomerPurge, &c, nil))
+		purged = append(purged, c)
+	}
+	return purged, nil
+}
+
+func (s *memoryStore) AddProduct(ctx context.Context, product *Product) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live(product.CustomerID); !ok {
+		return ErrCustomerNotFound
+	}
+	added := *product
+	if product.SKU != nil {
+		item, ok := s.catalog[*product.SKU]
+		if !ok {
+			return ErrCatalogItemNotFound
+		}
+		if err := applyCatalogItem(&added, item); err != nil {
+			return err
+		}
+		added.SKU = copyString(product.SKU)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	added.CreatedAt = &createdAt
+	added.ProductID = s.nextProductID
+	s.nextProductID++
+	s.products[added.ProductID] = added
+	s.audit(productAuditEntry(ctx, AuditProductCreate, nil, &added))
+	*product = added
+	return nil
+}
+
+func (s *memoryStore) ListProducts(ctx context.Context, customerID int64) ([]Product, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.customerProducts(customerID), nil
+}
+
+// customerProducts returns the customer's live products by product_id.
+// Callers must hold s.mu.
+func (s *memoryStore) customerProducts(customerID int64) []Product {
+	products := []Product{}
+	for _, p := range s.products {
+		if _, deleted := s.productDeletedAt[p.ProductID]; !deleted && p.CustomerID == customerID {
+			products = append(products, p)
+		}
+	}
+	sort.Slice(products, func(i, j int) bool {
+		return products[i].ProductID < products[j].ProductID
+	})
+	return products
+}
+
+// liveProduct returns the product if it is live and belongs to the customer.
+// Callers must hold s.mu.
+func (s *memoryStore) liveProduct(customerID int64, productID int) (Product, bool) {
+	p, ok := s.products[productID]
+	_, deleted := s.productDeletedAt[productID]
+	return p, ok && !deleted && p.CustomerID == customerID
+}
+
+func (s *memoryStore) UpdateProduct(ctx context.Context, customerID int64, productID int, update func(*Product) error) (Product, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, ok := s.liveProduct(customerID, productID)
+	if !ok {
+		return Product{}, ErrProductNotFound
+	}
+	after := before
+	after.SKU = copyString(before.SKU)
+	if err := update(&after); err != nil {
+		return Product{}, err
+	}
+	after.ProductID, after.CustomerID, after.SKU = before.ProductID, before.CustomerID, before.SKU
+	after.CreatedAt = before.CreatedAt
+	s.products[productID] = after
+	s.audit(productAuditEntry(ctx, AuditProductUpdate, &before, &after))
+	return after, nil
+}
+
+func (s *memoryStore) SummarizeProducts(ctx context.Context, customerID int64, top int) (ProductSummary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return summarizeProducts(s.customerProducts(customerID), top)
+}
+
+func (s *memoryStore) DeleteProduct(ctx context.Context, customerID int64, productID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	p, ok := s.liveProduct(customerID, productID)
+	if !ok {
+		return ErrProductNotFound
+	}
+	delete(s.products, productID)
+	s.audit(productAuditEntry(ctx, AuditProductDelete, &p, nil))
+	return nil
+}
+
+func (s *memoryStore) Flush(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[string]int64{
+		"customers_deleted": int64(len(s.customers)),
+		"products_deleted":  int64(len(s.products)),
+		"orders_deleted":    int64(len(s.orders)),
+	}
+	s.reset()
+	s.audit(newAuditEntry(ctx, AuditDataFlush, "system", "all", nil, nil, counts))
+	return nil
+}
+
+func (s *memoryStore) CustomerHistory(ctx context.Context, customerID int64, afterID int64, limit int) ([]AuditEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	entries := []AuditEntry{}
+	for _, e := range s.auditLog {
+		if e.AuditID <= afterID || e.CustomerID == nil || *e.CustomerID != customerID {
+			continue
+		}
+		entries = append(entries, e)
+		if len(entries) == limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+func (s *memoryStore) CreateCatalogItem(ctx context.Context, item *CatalogItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.catalog[item.SKU]; exists {
+		return &DuplicateKeyError{Field: "sku"}
+	}
+	item.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	item.UpdatedAt = item.CreatedAt
+	s.catalog[item.SKU] = *item
+	s.audit(catalogAuditEntry(ctx, AuditCatalogCreate, nil, item))
+	return nil
+}
+
+func (s *memoryStore) GetCatalogItem(ctx context.Context, sku string) (CatalogItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	item, ok := s.catalog[sku]
+	if !ok {
+		return CatalogItem{}, ErrCatalogItemNotFound
+	}
+	return item, nil
+}
+
+func (s *memoryStore) ListCatalogItems(ctx context.Context, filter CatalogFilter) ([]CatalogItem, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := []CatalogItem{}
+	for _, item := range s.catalog {
+		if (filter.Category == "" || item.Category == filter.Category) && (item.Active || filter.IncludeInactive) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].SKU < items[j].SKU })
+	return items, nil
+}
+
+func (s *memoryStore) UpdateCatalogItem(ctx context.Context, item CatalogItem) (CatalogItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.catalog[item.SKU]
+	if !ok {
+		return CatalogItem{}, ErrCatalogItemNotFound
+	}
+	item.CreatedAt = existing.CreatedAt
+	item.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.catalog[item.SKU] = item
+	s.audit(catalogAuditEntry(ctx, AuditCatalogUpdate, &existing, &item))
+	return item, nil
+}
+
+func (s *memoryStore) DeleteCatalogItem(ctx context.Context, sku string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	item, ok := s.catalog[sku]
+	if !ok {
+		return ErrCatalogItemNotFound
+	}
+	// Emulate the products.sku and order_lines.sku foreign keys, which see
+	// soft-deleted rows too.
+	for _, p := range s.products {
+		if p.SKU != nil && *p.SKU == sku {
+			return &ForeignKeyError{Table: "products", Column: "sku", Referenced: true}
+		}
+	}
+	for _, o := range s.orders {
+		for _, line := range o.Lines {
+			if line.SKU != nil && *line.SKU == sku {
+				return &ForeignKeyError{Table: "order_lines", Column: "sku", Referenced: true}
+			}
+		}
+	}
+	delete(s.catalog, sku)
+	s.audit(catalogAuditEntry(ctx, AuditCatalogDelete, &item, nil))
+	return nil
+}
+
+func (s *memoryStore) LinkProductsToCatalog(ctx context.Context, dryRun bool) (CatalogLinkReport, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := make([]CatalogItem, 0, len(s.catalog))
+	for _, item := range s.catalog {
+		items = append(items, item)
+	}
+	index := catalogNameIndex(items)
+
+	ids := make([]int, 0, len(s.products))
+	for id, p := range s.products {
+		if p.SKU == nil {
+			ids = append(ids, id)
+		}
+	}
+	sort.Ints(ids)
+
+	report := CatalogLinkReport{DryRun: dryRun}
+	for _, id := range ids {
+		before := s.products[id]
+		sku := report.link(index, before.ProductName)
+		if sku == "" || dryRun {
+			continue
+		}
+		after := before
+		after.SKU = &sku
+		s.products[id] = after
+		s.audit(productAuditEntry(ctx, AuditProductUpdate, &before, &after))
+	}
+	return report, nil
+}
+
+func (s *memoryStore) CreateOrder(ctx context.Context, order *Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.live(order.CustomerID); !ok {
+		return ErrCustomerNotFound
+	}
+	created := cloneOrder(*order)
+	err := priceOrder(&created, func(sku string) (CatalogItem, error) {
+		item, ok := s.catalog[sku]
+		if !ok {
+			return CatalogItem{}, ErrCatalogItemNotFound
+		}
+		return item, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	created.OrderID = s.nextOrderID
+	s.nextOrderID++
+	created.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	created.UpdatedAt = created.CreatedAt
+	s.orders[created.OrderID] = created
+	s.audit(orderAuditEntry(ctx, AuditOrderCreate, nil, &created))
+	*order = cloneOrder(created)
+	return nil
+}
+
+// customerOrders returns the customer's live orders, newest first, keeping
+// only those in status unless it is empty. Callers must hold s.mu.
+func (s *memoryStore) customerOrders(customerID int64, status string) []Order {
+	orders := []Order{}
+	for id, o := range s.orders {
+		if _, deleted := s.orderDeletedAt[id]; deleted || o.CustomerID != customerID {
+			continue
+		}
+		if status == "" || o.Status == status {
+			orders = append(orders, cloneOrder(o))
+		}
+	}
+	sort.Slice(orders, func(i, j int) bool { return orders[i].OrderID > orders[j].OrderID })
+	return orders
+}
+
+// liveOrder returns the order if it is live and belongs to the customer.
+// Callers must hold s.mu.
+func (s *memoryStore) liveOrder(customerID, orderID int64) (Order, bool) {
+	o, ok := s.orders[orderID]
+	_, deleted := s.orderDeletedAt[orderID]
+	return o, ok && !deleted && o.CustomerID == customerID
+}
+
+func (s *memoryStore) ListOrders(ctx context.Context, customerID int64, status string) ([]Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.customerOrders(customerID, status), nil
+}
+
+func (s *memoryStore) GetOrder(ctx context.Context, customerID, orderID int64) (Order, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	o, ok := s.liveOrder(customerID, orderID)
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	return cloneOrder(o), nil
+}
+
+func (s *memoryStore) TransitionOrder(ctx context.Context, customerID, orderID int64, status string) (Order, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	before, ok := s.liveOrder(customerID, orderID)
+	if !ok {
+		return Order{}, ErrOrderNotFound
+	}
+	if err := checkOrderTransition(before.Status, status); err != nil {
+		return Order{}, err
+	}
+	after := cloneOrder(before)
+	after.Status = status
+	after.UpdatedAt = time.Now().UTC().Truncate(time.Second)
+	s.orders[orderID] = after
+	s.audit(orderAuditEntry(ctx, AuditOrderStatus, &before, &after))
+	return cloneOrder(after), nil
+}
+
+func (s *memoryStore) ClaimIdempotencyKey(ctx context.Context, rec IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*IdempotencyRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found, ok := s.idempotency[rec.Key]; ok {
+		stale := expiredBefore
+		if found.Status == 0 {
+			stale = abandonedBefore
+		}
+		if !found.CreatedAt.Before(stale) {
+			return &found, nil
+		}
+	}
+	s.idempotency[rec.Key] = IdempotencyRecord{Key: rec.Key, Fingerprint: rec.Fingerprint, CreatedAt: rec.CreatedAt}
+	return nil, nil
+}
+
+func (s *memoryStore) CompleteIdempotencyKey(ctx context.Context, rec IdempotencyRecord) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found, ok := s.idempotency[rec.Key]; ok && found.Status == 0 {
+		found.Status, found.Headers, found.Body = rec.Status, rec.Headers, rec.Body
+		s.idempotency[rec.Key] = found
+	}
+	return nil
+}
+
+func (s *memoryStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if found, ok := s.idempotency[key]; ok && found.Status == 0 {
+		delete(s.idempotency, key)
+	}
+	return nil
+}
+
+func (s *memoryStore) PurgeIdempotencyKeys(ctx context.Context, createdBefore time.Time) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int64
+	for key, rec := range s.idempotency {
+		if rec.CreatedAt.Before(createdBefore) {
+			delete(s.idempotency, key)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,1463 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// --- MySQL/MariaDB Store ---
+
+const customerColumns = "customer_id, name, age, address, phoneNumber, email, passportID, aadharID, drivingLicenseID, created_at, deleted_at, version"
+
+const productColumns = "product_id, customer_id, sku, product_name, quantity, price_minor, currency, created_at"
+
+const orderColumns = "order_id, customer_id, status, total_minor, currency, created_at, updated_at"
+
+// mysqlStore keeps ID documents envelope-encrypted at rest. Each has a
+// <type>_hash column holding its keyed hash, which carries the UNIQUE
+// constraint and serves exact lookups.
+type mysqlStore struct {
+	db  *sql.DB
+	pii *piiKeyRing
+	ids IDGenerator
+}
+
+func newMySQLStore(db *sql.DB, pii *piiKeyRing) *mysqlStore {
+	return &mysqlStore{db: db, pii: pii, ids: randomIDGenerator{}}
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanCustomer reads a row selected with customerColumns and decrypts its ID
+// documents.
+func (s *mysqlStore) scanCustomer(row rowScanner) (Customer, error) {
+	var customer Customer
+	err := row.Scan(
+		&customer.CustomerID, &customer.Name, &customer.Age, &customer.Address,
+		&customer.PhoneNumber, &customer.Email, &customer.PassportID,
+		&customer.AadharID, &customer.DrivingLicenseID, &customer.CreatedAt, &customer.DeletedAt,
+		&customer.Version,
+	)
+	if err != nil {
+		return customer, err
+	}
+	return customer, s.pii.openCustomer(&customer)
+}
+
+// documentColumns is the column list matching documentArgs.
+const documentColumns = "passportID, passport_hash, aadharID, aadhar_hash, drivingLicenseID, driving_license_hash"
+
+// documentArgs returns the sealed value and keyed hash of each ID document
+// of c, in documentColumns order.
+func (s *mysqlStore) documentArgs(c Customer) []interface{} {
+	args := make([]interface{}, 0, 6)
+	for _, doc := range []struct {
+		field string
+		value *string
+	}{{LookupPassport, c.PassportID}, {LookupAadhar, c.AadharID}, {LookupDrivingLicense, c.DrivingLicenseID}} {
+		if doc.value == nil {
+			args = append(args, nil, nil)
+			continue
+		}
+		args = append(args, s.pii.seal(doc.field, *doc.value), s.pii.hash(doc.field, *doc.value))
+	}
+	return args
+}
+
+// allocateIDs reserves n consecutive customer ID bodies from the
+// customer_id_seq table for a sequenceIDGenerator. It runs in a transaction
+// of its own, so the counter row is locked only briefly and a block is never
+// handed out twice, even to other API instances.
+func (s *mysqlStore) allocateIDs(ctx context.Context, n int) (int64, error) {
+	var start int64
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT next_value FROM customer_id_seq WHERE name = 'customer' FOR UPDATE").Scan(&start)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE customer_id_seq SET next_value = next_value + ? WHERE name = 'customer'", n)
+		return err
+	})
+	return start, err
+}
+
+// insertCustomer inserts customer under a fresh ID from s.ids, drawing again
+// if the ID is already taken, and returns the ID. The failed INSERT only
+// rolls back its own statement, so the transaction carries on.
+func (s *mysqlStore) insertCustomer(ctx context.Context, tx *sql.Tx, customer Customer) (int64, error) {
+	query := `INSERT INTO customers (customer_id, name, age, address, phoneNumber, email, ` + documentColumns + `)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	for attempt := 1; attempt <= maxIDAttempts; attempt++ {
+		newID, err := s.ids.NextID(ctx)
+		if err != nil {
+			return 0, err
+		}
+		args := append([]interface{}{newID, customer.Name, customer.Age, customer.Address,
+			customer.PhoneNumber, customer.Email}, s.documentArgs(customer)...)
+		_, err = tx.ExecContext(ctx, query, args...)
+		if err = translateMySQLError(err); err == nil {
+			return newID, nil
+		} else if !isIDCollision(err) {
+			return 0, err
+		}
+		log.Printf("Generated ID %d already exists. Retrying...", newID)
+	}
+	return 0, errIDGenerationExhausted(maxIDAttempts)
+}
+
+func (s *mysqlStore) CreateCustomer(ctx context.Context, customer *Customer) error {
+	var created Customer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		newID, err := s.insertCustomer(ctx, tx, *customer)
+		if err != nil {
+			return fmt.Errorf("database error: %w", err)
+		}
+
+		// Read back the row so created_at is the database's value
+		if created, err = s.lockCustomer(ctx, tx, newID); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerCreate, nil, &created))
+	})
+	if err != nil {
+		return err
+	}
+	*customer = created
+	return nil
+}
+
+func (s *mysqlStore) ImportCustomers(ctx context.Context, customers []Customer, dryRun bool) ([]error, error) {
+	var rowErrs []error
+	created := make([]Customer, len(customers))
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// A duplicate-key error only rolls back its own statement in InnoDB,
+		// so the rest of the batch carries on in the same transaction.
+		rowErrs = make([]error, len(customers))
+		for i, customer := range customers {
+			id, err := s.insertCustomer(ctx, tx, customer)
+			if errors.Is(err, ErrDuplicateIDDocument) {
+				rowErrs[i] = err
+				continue
+			} else if err != nil {
+				return fmt.Errorf("database error: %w", err)
+			}
+			if dryRun {
+				continue
+			}
+
+			if created[i], err = s.lockCustomer(ctx, tx, id); err != nil {
+				return err
+			}
+			if err := s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerCreate, nil, &created[i])); err != nil {
+				return err
+			}
+		}
+		if dryRun {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && err != errRollback {
+		return nil, err
+	}
+	if !dryRun {
+		for i := range customers {
+			if rowErrs[i] == nil {
+				customers[i] = created[i]
+			}
+		}
+	}
+	return rowErrs, nil
+}
+
+// RekeyPII re-encrypts every ID document that is not sealed with the active
+// key, including legacy plaintext, and fills in missing hash columns. It
+// works through all rows, soft-deleted ones included, batchSize per
+// transaction, and returns how many rows it rewrote. Values and versions are
+// unchanged, so nothing is audited.
+func (s *mysqlStore) RekeyPII(ctx context.Context, batchSize int) (int, error) {
+	rewritten := 0
+	var afterID int64
+	for {
+		n, lastID, err := s.rekeyBatch(ctx, afterID, batchSize)
+		rewritten += n
+		if err != nil || lastID == 0 {
+			return rewritten, err
+		}
+		afterID = lastID
+	}
+}
+
+// rekeyBatch handles the batchSize rows after afterID. lastID is 0 once there
+// are no rows left.
+func (s *mysqlStore) rekeyBatch(ctx context.Context, afterID int64, batchSize int) (rewritten int, lastID int64, err error) {
+	err = s.inTx(ctx, func(tx *sql.Tx) error {
+		rewritten, lastID = 0, 0
+		rows, err := tx.QueryContext(ctx, "SELECT customer_id, "+documentColumns+
+			" FROM customers WHERE customer_id > ? ORDER BY customer_id LIMIT ? FOR UPDATE", afterID, batchSize)
+		if err != nil {
+			return err
+		}
+		var stale []Customer
+		for rows.Next() {
+			var c Customer
+			var hashes [3]sql.NullString
+			if err := rows.Scan(&c.CustomerID, &c.PassportID, &hashes[0], &c.AadharID, &hashes[1],
+				&c.DrivingLicenseID, &hashes[2]); err != nil {
+				rows.Close()
+				return err
+			}
+			lastID = c.CustomerID
+			for i, doc := range []*string{c.PassportID, c.AadharID, c.DrivingLicenseID} {
+				if doc != nil && (!s.pii.current(*doc) || !hashes[i].Valid) {
+					stale = append(stale, c)
+					break
+				}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, c := range stale {
+			if err := s.pii.openCustomer(&c); err != nil {
+				return err
+			}
+			args := append(s.documentArgs(c), c.CustomerID)
+			_, err := tx.ExecContext(ctx, `UPDATE customers SET
+			passportID = ?, passport_hash = ?, aadharID = ?, aadhar_hash = ?,
+			drivingLicenseID = ?, driving_license_hash = ?
+			WHERE customer_id = ?`, args...)
+			if err != nil {
+				return fmt.Errorf("customer %d: %w", c.CustomerID, err)
+			}
+		}
+		rewritten = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return rewritten, lastID, nil
+}
+
+// lockCustomer reads a live (not soft-deleted) customer row with FOR UPDATE
+// inside tx.
+func (s *mysqlStore) lockCustomer(ctx context.Context, tx *sql.Tx, customerID int64) (Customer, error) {
+	query := "SELECT " + customerColumns + " FROM customers WHERE customer_id = ? AND deleted_at IS NULL FOR UPDATE"
+	customer, err := s.scanCustomer(tx.QueryRowContext(ctx, query, customerID))
+	if err == sql.ErrNoRows {
+		return Customer{}, ErrCustomerNotFound
+	}
+	return customer, err
+}
+
+// audit appends an entry to audit_log inside the mutation's transaction, so
+// the change and its record commit or roll back together.
+func (s *mysqlStore) audit(ctx context.Context, tx *sql.Tx, entry AuditEntry) error {
+	// Snapshots are kept forever, so their ID documents are sealed too.
+	if entry.EntityType == "customer" {
+		entry.Before, entry.After = s.pii.sealJSON(entry.Before), s.pii.sealJSON(entry.After)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO audit_log
+		(actor, actor_role, action, entity_type, entity_id, customer_id, before_data, after_data, request_id)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Actor, nullIfEmpty(entry.ActorRole), entry.Action, entry.EntityType, entry.EntityID,
+		entry.CustomerID, nullIfEmptyJSON(entry.Before), nullIfEmptyJSON(entry.After), nullIfEmpty(entry.RequestID))
+	if err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+func nullIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+func nullIfEmptyJSON(b []byte) interface{} {
+	if len(b) == 0 {
+		return nil
+	}
+	return string(b)
+}
+
+func (s *mysqlStore) GetCustomer(ctx context.Context, lookupType, value string) (Customer, error) {
+	var condition string
+	var args []interface{}
+	switch lookupType {
+	case LookupCustomerID:
+		condition, args = "customer_id = ?", []interface{}{value}
+	case LookupAadhar, LookupPassport, LookupDrivingLicense:
+		// Rows not yet migrated by `rekey-pii` have no hash and still hold
+		// the plaintext document.
+		column, hashColumn := documentColumnNames[lookupType][0], documentColumnNames[lookupType][1]
+		condition = fmt.Sprintf("(%s = ? OR (%s IS NULL AND %s = ?))", hashColumn, hashColumn, column)
+		args = []interface{}{s.pii.hash(lookupType, value), value}
+	default:
+		return Customer{}, ErrInvalidLookupType
+	}
+
+	query := "SELECT " + customerColumns + " FROM customers WHERE " + condition + " AND deleted_at IS NULL"
+	customer, err := s.scanCustomer(s.db.QueryRowContext(ctx, query, args...))
+	if err == sql.ErrNoRows {
+		return Customer{}, ErrCustomerNotFound
+	}
+	return customer, err
+}
+
+// documentColumnNames maps each document lookup type to its value and hash
+// columns.
+var documentColumnNames = map[string][2]string{
+	LookupAadhar:         {"aadharID", "aadhar_hash"},
+	LookupPassport:       {"passportID", "passport_hash"},
+	LookupDrivingLicense: {"drivingLicenseID", "driving_license_hash"},
+}
+
+func (s *mysqlStore) ListCustomers(ctx context.Context, opts CustomerListOptions) (CustomerPage, error) {
+	conds, args := opts.Filter.sqlConditions("")
+	conds = append(conds, "deleted_at IS NULL")
+
+	column := customerSortColumns[opts.Sort]
+	desc := opts.scanDesc()
+	cmp, dir := ">", "ASC"
+	if desc {
+		cmp, dir = "<", "DESC"
+	}
+
+	// Keyset pagination: continue strictly after the cursor row in scan order,
+	// using customer_id to break ties on non-unique sort keys.
+	if opts.Cursor != nil {
+		if column == "customer_id" {
+			conds = append(conds, "customer_id "+cmp+" ?")
+			args = append(args, opts.Cursor.ID)
+		} else {
+			conds = append(conds, fmt.Sprintf("(%s %s ? OR (%s = ? AND customer_id %s ?))", column, cmp, column, cmp))
+			key := opts.Cursor.keyArg()
+			args = append(args, key, key, opts.Cursor.ID)
+		}
+	}
+
+	query := "SELECT " + customerColumns + " FROM customers"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	if column == "customer_id" {
+		query += " ORDER BY customer_id " + dir
+	} else {
+		query += fmt.Sprintf(" ORDER BY %s %s, customer_id %s", column, dir, dir)
+	}
+	query += " LIMIT ?"
+	args = append(args, opts.Limit+1)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return CustomerPage{}, err
+	}
+	defer rows.Close()
+
+	customers := []Customer{}
+	for rows.Next() {
+		// A row that cannot be read or decrypted (e.g. its key is missing
+		// from the ring) fails the page rather than vanishing from it.
+		customer, err := s.scanCustomer(rows)
+		if err != nil {
+			return CustomerPage{}, err
+		}
+		customers = append(customers, customer)
+	}
+	if err := rows.Err(); err != nil {
+		return CustomerPage{}, err
+	}
+	return newCustomerPage(customers, opts), nil
+}
+
+// SearchCustomers uses the ft_customers_text FULLTEXT index in boolean mode
+// with every term as a prefix (`term*`), plus phonetic matching on the first
+// and last word of the name and substring matching on phone digits. The
+// prefix and phone bonuses use the same weights as scoreCustomer, but the
+// rest of the score is MySQL's own FULLTEXT relevance and SOUNDS LIKE rather
+// than the memory store's per-word edit distance, so the two backends can
+// order weaker matches differently.
+func (s *mysqlStore) SearchCustomers(ctx context.Context, search CustomerSearch) ([]Customer, error) {
+	terms := searchTerms(search.Query)
+	boolean := make([]string, len(terms))
+	for i, t := range terms {
+		boolean[i] = t + "*"
+	}
+	against := strings.Join(boolean, " ")
+	prefix := escapeLike(strings.TrimSpace(search.Query)) + "%"
+
+	scoreExpr := `MATCH(name, address, email) AGAINST (? IN BOOLEAN MODE)
+		+ IF(name LIKE ?, ?, 0)
+		+ IF(email LIKE ?, ?, 0)`
+	args := []interface{}{against, prefix, searchWeightNamePrefix, prefix, searchWeightEmailPrefix}
+	conds := []string{"MATCH(name, address, email) AGAINST (? IN BOOLEAN MODE)", "name LIKE ?", "email LIKE ?"}
+	condArgs := []interface{}{against, prefix, prefix}
+
+	for _, t := range terms {
+		if len(t) < 4 {
+			continue
+		}
+		scoreExpr += `
+		+ IF(SUBSTRING_INDEX(name, ' ', 1) SOUNDS LIKE ? OR SUBSTRING_INDEX(name, ' ', -1) SOUNDS LIKE ?, ?, 0)`
+		args = append(args, t, t, searchWeightFuzzyName)
+		conds = append(conds, "SUBSTRING_INDEX(name, ' ', 1) SOUNDS LIKE ?", "SUBSTRING_INDEX(name, ' ', -1) SOUNDS LIKE ?")
+		condArgs = append(condArgs, t, t)
+	}
+
+	// Phone numbers are stored as typed, so strip formatting before comparing.
+	if digits := searchDigits(search.Query); len(digits) >= 3 {
+		phoneDigits := "REGEXP_REPLACE(phoneNumber, '[^0-9]', '')"
+		scoreExpr += `
+		+ IF(` + phoneDigits + ` LIKE ?, ?, 0)`
+		pattern := "%" + escapeLike(digits) + "%"
+		args = append(args, pattern, searchWeightPhone)
+		conds = append(conds, phoneDigits+" LIKE ?")
+		condArgs = append(condArgs, pattern)
+	}
+
+	query := "SELECT " + customerColumns + ", " + scoreExpr + ` AS score
+		FROM customers
+		WHERE (` + strings.Join(conds, " OR ") + `) AND deleted_at IS NULL
+		ORDER BY score DESC, customer_id DESC
+		LIMIT ? OFFSET ?`
+	args = append(args, condArgs...)
+	args = append(args, search.Limit+1, search.Offset)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	customers := []Customer{}
+	for rows.Next() {
+		var customer Customer
+		var score float64
+		if err := rows.Scan(
+			&customer.CustomerID, &customer.Name, &customer.Age, &customer.Address,
+			&customer.PhoneNumber, &customer.Email, &customer.PassportID,
+			&customer.AadharID, &customer.DrivingLicenseID, &customer.CreatedAt, &customer.DeletedAt,
+			&customer.Version, &score,
+		); err != nil {
+			return nil, err
+		}
+		if err := s.pii.openCustomer(&customer); err != nil {
+			return nil, err
+		}
+		customers = append(customers, customer)
+	}
+	return customers, rows.Err()
+}
+
+func (s *mysqlStore) ExportCustomers(ctx context.Context, filter CustomerFilter, fn func(Customer, []Product) error) error {
+	conds, args := filter.sqlConditions("c.")
+	conds = append(conds, "c.deleted_at IS NULL")
+
+	query := "SELECT c." + strings.ReplaceAll(customerColumns, ", ", ", c.") +
+		`, p.product_id, p.sku, p.product_name, p.quantity, p.price_minor, p.currency
+		FROM customers c
+		LEFT JOIN products p ON p.customer_id = c.customer_id AND p.deleted_at IS NULL
+		WHERE ` + strings.Join(conds, " AND ") + `
+		ORDER BY c.customer_id, p.product_id`
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	// Rows arrive grouped by customer; emit each group once the next
+	// customer starts.
+	var current *Customer
+	var products []Product
+	emit := func() error {
+		if current == nil {
+			return nil
+		}
+		return fn(*current, products)
+	}
+	for rows.Next() {
+		var customer Customer
+		var productID, quantity, priceMinor sql.NullInt64
+		var sku, productName, currency sql.NullString
+		err := rows.Scan(
+			&customer.CustomerID, &customer.Name, &customer.Age, &customer.Address,
+			&customer.PhoneNumber, &customer.Email, &customer.PassportID,
+			&customer.AadharID, &customer.DrivingLicenseID, &customer.CreatedAt, &customer.DeletedAt,
+			&customer.Version, &productID, &sku, &productName, &quantity, &priceMinor, &currency,
+		)
+		if err != nil {
+			return err
+		}
+		if err := s.pii.openCustomer(&customer); err != nil {
+			return err
+		}
+		if current == nil || current.CustomerID != customer.CustomerID {
+			if err := emit(); err != nil {
+				return err
+			}
+			current, products = &customer, []Product{}
+		}
+		if productID.Valid {
+			product := Product{
+				ProductID:   int(productID.Int64),
+				CustomerID:  customer.CustomerID,
+				ProductName: productName.String,
+				Quantity:    int(quantity.Int64),
+				Price:       Money{Minor: priceMinor.Int64, Currency: currency.String},
+			}
+			if sku.Valid {
+				product.SKU = &sku.String
+			}
+			products = append(products, product)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return emit()
+}
+
+func (s *mysqlStore) UpdateCustomer(ctx context.Context, customer Customer, expectedVersion int) (Customer, error) {
+	var before, after Customer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if before, err = s.lockCustomer(ctx, tx, customer.CustomerID); err != nil {
+			return err
+		}
+		// The row is locked, so the version cannot move between check and write.
+		if expectedVersion != 0 && before.Version != expectedVersion {
+			return ErrVersionMismatch
+		}
+
+		query := `UPDATE customers SET
+                name = ?, age = ?, address = ?, phoneNumber = ?, email = ?,
+                passportID = ?, passport_hash = ?, aadharID = ?, aadhar_hash = ?,
+                drivingLicenseID = ?, driving_license_hash = ?,
+                version = version + 1
+              WHERE customer_id = ?`
+
+		args := append([]interface{}{customer.Name, customer.Age, customer.Address, customer.PhoneNumber,
+			customer.Email}, s.documentArgs(customer)...)
+		if _, err := tx.ExecContext(ctx, query, append(args, customer.CustomerID)...); err != nil {
+			return err
+		}
+
+		if after, err = s.lockCustomer(ctx, tx, customer.CustomerID); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerUpdate, &before, &after))
+	})
+	if errors.Is(err, ErrVersionMismatch) {
+		return before, err
+	} else if err != nil {
+		return Customer{}, err
+	}
+	return after, nil
+}
+
+// DeleteCustomer soft-deletes the customer and its live products and orders
+// by stamping them with the same deleted_at, which is how RestoreCustomer
+// finds the rows that went with it. PurgeDeletedCustomers removes them for
+// good.
+func (s *mysqlStore) DeleteCustomer(ctx context.Context, customerID int64) (Customer, error) {
+	var existing Customer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// Read the current ID documents inside the transaction so the caller
+		// can invalidate every cache key that pointed at this customer.
+		var err error
+		if existing, err = s.lockCustomer(ctx, tx, customerID); err != nil {
+			return err
+		}
+
+		products, err := s.listProducts(ctx, tx, customerID)
+		if err != nil {
+			return err
+		}
+		orders, err := s.listOrders(ctx, tx, customerID, "")
+		if err != nil {
+			return err
+		}
+
+		deletedAt := time.Now().UTC().Truncate(time.Microsecond)
+		if _, err := tx.ExecContext(ctx, "UPDATE products SET deleted_at = ? WHERE customer_id = ? AND deleted_at IS NULL", deletedAt, customerID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET deleted_at = ? WHERE customer_id = ? AND deleted_at IS NULL", deletedAt, customerID); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE customers SET deleted_at = ?, version = version + 1 WHERE customer_id = ?", deletedAt, customerID); err != nil {
+			return err
+		}
+
+		for i := range products {
+			if err := s.audit(ctx, tx, productAuditEntry(ctx, AuditProductDelete, &products[i], nil)); err != nil {
+				return err
+			}
+		}
+		for i := range orders {
+			if err := s.audit(ctx, tx, orderAuditEntry(ctx, AuditOrderDelete, &orders[i], nil)); err != nil {
+				return err
+			}
+		}
+		deleted := existing
+		deleted.DeletedAt = &deletedAt
+		deleted.Version++
+		return s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerDelete, &existing, &deleted))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+	return existing, nil
+}
+
+func (s *mysqlStore) RestoreCustomer(ctx context.Context, customerID int64) (Customer, error) {
+	var restored Customer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		query := "SELECT " + customerColumns + " FROM customers WHERE customer_id = ? FOR UPDATE"
+		deleted, err := s.scanCustomer(tx.QueryRowContext(ctx, query, customerID))
+		if err == sql.ErrNoRows {
+			return ErrCustomerNotFound
+		} else if err != nil {
+			return err
+		}
+		if deleted.DeletedAt == nil {
+			return ErrCustomerNotDeleted
+		}
+
+		// Only products and orders deleted together with the customer come back.
+		if _, err := tx.ExecContext(ctx, "UPDATE products SET deleted_at = NULL WHERE customer_id = ? AND deleted_at = ?", customerID, *deleted.DeletedAt); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET deleted_at = NULL WHERE customer_id = ? AND deleted_at = ?", customerID, *deleted.DeletedAt); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE customers SET deleted_at = NULL, version = version + 1 WHERE customer_id = ?", customerID); err != nil {
+			return err
+		}
+
+		if restored, err = s.lockCustomer(ctx, tx, customerID); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerRestore, &deleted, &restored))
+	})
+	if err != nil {
+		return Customer{}, err
+	}
+	return restored, nil
+}
+
+func (s *mysqlStore) PurgeDeletedCustomers(ctx context.Context, deletedBefore time.Time, limit int) ([]Customer, error) {
+	var purged []Customer
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		query := "SELECT " + customerColumns + ` FROM customers
+		WHERE deleted_at IS NOT NULL AND deleted_at < ?
+		ORDER BY deleted_at LIMIT ? FOR UPDATE`
+		rows, err := tx.QueryContext(ctx, query, deletedBefore, limit)
+		if err != nil {
+			return err
+		}
+		purged = []Customer{}
+		for rows.Next() {
+			customer, err := s.scanCustomer(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			purged = append(purged, customer)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range purged {
+			// ON DELETE CASCADE removes the customer's products and orders
+			if _, err := tx.ExecContext(ctx, "DELETE FROM customers WHERE customer_id = ?", purged[i].CustomerID); err != nil {
+				return err
+			}
+			if err := s.audit(ctx, tx, customerAuditEntry(ctx, AuditCustomerPurge, &purged[i], nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+func (s *mysqlStore) AddProduct(ctx context.Context, product *Product) error {
+	var added Product
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		// The foreign key cannot see soft deletes, so check for a live owner.
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM customers WHERE customer_id = ? AND deleted_at IS NULL)", product.CustomerID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrCustomerNotFound
+		}
+
+		// Share-lock the catalog item so its price cannot change or the item
+		// be deactivated before the purchase commits.
+		added = *product
+		if product.SKU != nil {
+			item, err := s.getCatalogItem(ctx, tx, *product.SKU, " LOCK IN SHARE MODE")
+			if err != nil {
+				return err
+			}
+			if err := applyCatalogItem(&added, item); err != nil {
+				return err
+			}
+		}
+
+		createdAt := time.Now().UTC().Truncate(time.Second)
+		added.CreatedAt = &createdAt
+		query := `INSERT INTO products (customer_id, sku, product_name, quantity, price_minor, currency, created_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)`
+		result, err := tx.ExecContext(ctx, query, added.CustomerID, added.SKU, added.ProductName, added.Quantity,
+			added.Price.Minor, added.Price.Currency, createdAt)
+		if err != nil {
+			return err
+		}
+
+		id, _ := result.LastInsertId()
+		added.ProductID = int(id)
+		return s.audit(ctx, tx, productAuditEntry(ctx, AuditProductCreate, nil, &added))
+	})
+	if err != nil {
+		return err
+	}
+	*product = added
+	return nil
+}
+
+func (s *mysqlStore) ListProducts(ctx context.Context, customerID int64) ([]Product, error) {
+	return s.listProducts(ctx, s.db, customerID)
+}
+
+// scanProduct reads a row selected with productColumns.
+func scanProduct(row rowScanner) (Product, error) {
+	var product Product
+	err := row.Scan(&product.ProductID, &product.CustomerID, &product.SKU, &product.ProductName, &product.Quantity,
+		&product.Price.Minor, &product.Price.Currency, &product.CreatedAt)
+	return product, err
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (s *mysqlStore) listProducts(ctx context.Context, q queryer, customerID int64) ([]Product, error) {
+	query := "SELECT " + productColumns + " FROM products WHERE customer_id = ? AND deleted_at IS NULL ORDER BY product_id"
+	rows, err := q.QueryContext(ctx, query, customerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	products := []Product{}
+	for rows.Next() {
+		product, err := scanProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+		products = append(products, product)
+	}
+	return products, rows.Err()
+}
+
+// lockProduct reads a live product with FOR UPDATE inside tx. A product of
+// another customer is reported as ErrProductNotFound.
+func (s *mysqlStore) lockProduct(ctx context.Context, tx *sql.Tx, customerID int64, productID int) (Product, error) {
+	product, err := scanProduct(tx.QueryRowContext(ctx, "SELECT "+productColumns+
+		" FROM products WHERE customer_id = ? AND product_id = ? AND deleted_at IS NULL FOR UPDATE", customerID, productID))
+	if err == sql.ErrNoRows {
+		return Product{}, ErrProductNotFound
+	}
+	return product, err
+}
+
+func (s *mysqlStore) UpdateProduct(ctx context.Context, customerID int64, productID int, update func(*Product) error) (Product, error) {
+	var after Product
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := s.lockProduct(ctx, tx, customerID, productID)
+		if err != nil {
+			return err
+		}
+		after = before
+		after.SKU = copyString(before.SKU)
+		if err := update(&after); err != nil {
+			return err
+		}
+		after.ProductID, after.CustomerID, after.SKU = before.ProductID, before.CustomerID, before.SKU
+		after.CreatedAt = before.CreatedAt
+
+		_, err = tx.ExecContext(ctx, `UPDATE products SET product_name = ?, quantity = ?, price_minor = ?, currency = ?
+			WHERE product_id = ?`, after.ProductName, after.Quantity, after.Price.Minor, after.Price.Currency, productID)
+		if err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, productAuditEntry(ctx, AuditProductUpdate, &before, &after))
+	})
+	if err != nil {
+		return Product{}, err
+	}
+	return after, nil
+}
+
+// SummarizeProducts runs its aggregates in one transaction so they see the
+// same snapshot of the customer's products.
+func (s *mysqlStore) SummarizeProducts(ctx context.Context, customerID int64, top int) (ProductSummary, error) {
+	var summary ProductSummary
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		summary = ProductSummary{TotalSpend: []Money{}, TopProducts: []TopProduct{}}
+		err := tx.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(quantity), 0), MIN(created_at), MAX(created_at)
+			FROM products WHERE customer_id = ? AND deleted_at IS NULL`, customerID).
+			Scan(&summary.ProductCount, &summary.TotalQuantity, &summary.FirstPurchaseAt, &summary.LastPurchaseAt)
+		if err != nil {
+			return err
+		}
+
+		rows, err := tx.QueryContext(ctx, `SELECT currency, SUM(quantity * price_minor)
+			FROM products WHERE customer_id = ? AND deleted_at IS NULL
+			GROUP BY currency ORDER BY currency`, customerID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var spend Money
+			if err := rows.Scan(&spend.Currency, &spend.Minor); err != nil {
+				rows.Close()
+				return err
+			}
+			summary.TotalSpend = append(summary.TotalSpend, spend)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		// Free-text products group by name, which the column collation
+		// compares without case, like summarizeProducts does.
+		rows, err = tx.QueryContext(ctx, `SELECT sku, MIN(product_name), currency, SUM(quantity), SUM(quantity * price_minor)
+			FROM products WHERE customer_id = ? AND deleted_at IS NULL
+			GROUP BY COALESCE(sku, product_name), sku, currency
+			ORDER BY SUM(quantity) DESC, MIN(product_name), currency LIMIT ?`, customerID, top)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var p TopProduct
+			if err := rows.Scan(&p.SKU, &p.ProductName, &p.Spend.Currency, &p.Quantity, &p.Spend.Minor); err != nil {
+				return err
+			}
+			summary.TopProducts = append(summary.TopProducts, p)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return ProductSummary{}, err
+	}
+	return summary, nil
+}
+
+func (s *mysqlStore) DeleteProduct(ctx context.Context, customerID int64, productID int) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		product, err := s.lockProduct(ctx, tx, customerID, productID)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM products WHERE customer_id = ? AND product_id = ?", customerID, productID); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, productAuditEntry(ctx, AuditProductDelete, &product, nil))
+	})
+}
+
+// Flush deletes every order, product and customer. It uses DELETE rather
+// than TRUNCATE because TRUNCATE implicitly commits, and the audit entry must
+// land in the same transaction. audit_log itself is never flushed.
+func (s *mysqlStore) Flush(ctx context.Context) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		// ON DELETE CASCADE removes the order lines
+		orders, err := tx.ExecContext(ctx, "DELETE FROM orders")
+		if err != nil {
+			return fmt.Errorf("failed to delete orders: %w", err)
+		}
+		products, err := tx.ExecContext(ctx, "DELETE FROM products")
+		if err != nil {
+			return fmt.Errorf("failed to delete products: %w", err)
+		}
+		customers, err := tx.ExecContext(ctx, "DELETE FROM customers")
+		if err != nil {
+			return fmt.Errorf("failed to delete customers: %w", err)
+		}
+
+		orderCount, _ := orders.RowsAffected()
+		productCount, _ := products.RowsAffected()
+		customerCount, _ := customers.RowsAffected()
+		entry := newAuditEntry(ctx, AuditDataFlush, "system", "all", nil, nil, map[string]int64{
+			"customers_deleted": customerCount, "products_deleted": productCount, "orders_deleted": orderCount,
+		})
+		return s.audit(ctx, tx, entry)
+	})
+}
+
+func (s *mysqlStore) CustomerHistory(ctx context.Context, customerID int64, afterID int64, limit int) ([]AuditEntry, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT audit_id, occurred_at, actor, actor_role, action, entity_type,
+		entity_id, customer_id, before_data, after_data, request_id
+		FROM audit_log WHERE customer_id = ? AND audit_id > ? ORDER BY audit_id LIMIT ?`,
+		customerID, afterID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var role, requestID, before, after sql.NullString
+		if err := rows.Scan(&e.AuditID, &e.OccurredAt, &e.Actor, &role, &e.Action, &e.EntityType,
+			&e.EntityID, &e.CustomerID, &before, &after, &requestID); err != nil {
+			return nil, err
+		}
+		e.ActorRole, e.RequestID = role.String, requestID.String
+		if before.Valid {
+			if e.Before, err = s.pii.openJSON([]byte(before.String)); err != nil {
+				return nil, fmt.Errorf("audit entry %d: %w", e.AuditID, err)
+			}
+		}
+		if after.Valid {
+			if e.After, err = s.pii.openJSON([]byte(after.String)); err != nil {
+				return nil, fmt.Errorf("audit entry %d: %w", e.AuditID, err)
+			}
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func (s *mysqlStore) ClaimIdempotencyKey(ctx context.Context, rec IdempotencyRecord, expiredBefore, abandonedBefore time.Time) (*IdempotencyRecord, error) {
+	var existing *IdempotencyRecord
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		existing = nil
+		res, err := tx.ExecContext(ctx, `INSERT IGNORE INTO idempotency_keys (idempotency_key, fingerprint, created_at)
+			VALUES (?, ?, ?)`, rec.Key, rec.Fingerprint, rec.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil
+		}
+
+		var found IdempotencyRecord
+		var status sql.NullInt64
+		var headers, body sql.NullString
+		err = tx.QueryRowContext(ctx, `SELECT idempotency_key, fingerprint, status, headers, body, created_at
+			FROM idempotency_keys WHERE idempotency_key = ? FOR UPDATE`, rec.Key).
+			Scan(&found.Key, &found.Fingerprint, &status, &headers, &body, &found.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if (status.Valid && found.CreatedAt.Before(expiredBefore)) || (!status.Valid && found.CreatedAt.Before(abandonedBefore)) {
+			_, err = tx.ExecContext(ctx, `UPDATE idempotency_keys SET fingerprint = ?, status = NULL, headers = NULL,
+				body = NULL, created_at = ? WHERE idempotency_key = ?`, rec.Fingerprint, rec.CreatedAt, rec.Key)
+			return err
+		}
+		found.Status, found.Body = int(status.Int64), body.String
+		if headers.Valid {
+			if err := json.Unmarshal([]byte(headers.String), &found.Headers); err != nil {
+				return fmt.Errorf("idempotency record headers: %w", err)
+			}
+		}
+		existing = &found
+		return nil
+	})
+	return existing, err
+}
+
+func (s *mysqlStore) CompleteIdempotencyKey(ctx context.Context, rec IdempotencyRecord) error {
+	headers, err := json.Marshal(rec.Headers)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `UPDATE idempotency_keys SET status = ?, headers = ?, body = ?
+		WHERE idempotency_key = ? AND status IS NULL`, rec.Status, headers, rec.Body, rec.Key)
+	return err
+}
+
+func (s *mysqlStore) ReleaseIdempotencyKey(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE idempotency_key = ? AND status IS NULL", key)
+	return err
+}
+
+func (s *mysqlStore) PurgeIdempotencyKeys(ctx context.Context, createdBefore time.Time) (int64, error) {
+	res, err := s.db.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE created_at < ?", createdBefore)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+const catalogColumns = "sku, name, category, list_price_minor, currency, active, created_at, updated_at"
+
+func scanCatalogItem(row rowScanner) (CatalogItem, error) {
+	var item CatalogItem
+	err := row.Scan(&item.SKU, &item.Name, &item.Category, &item.ListPrice.Minor, &item.ListPrice.Currency,
+		&item.Active, &item.CreatedAt, &item.UpdatedAt)
+	return item, err
+}
+
+// getCatalogItem reads one catalog item; lock is appended to the query, e.g.
+// " FOR UPDATE".
+func (s *mysqlStore) getCatalogItem(ctx context.Context, q queryer, sku, lock string) (CatalogItem, error) {
+	item, err := scanCatalogItem(q.QueryRowContext(ctx, "SELECT "+catalogColumns+" FROM catalog_items WHERE sku = ?"+lock, sku))
+	if err == sql.ErrNoRows {
+		return CatalogItem{}, ErrCatalogItemNotFound
+	}
+	return item, err
+}
+
+func (s *mysqlStore) CreateCatalogItem(ctx context.Context, item *CatalogItem) error {
+	var created CatalogItem
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `INSERT INTO catalog_items (sku, name, category, list_price_minor, currency, active)
+			VALUES (?, ?, ?, ?, ?, ?)`, item.SKU, item.Name, item.Category, item.ListPrice.Minor, item.ListPrice.Currency, item.Active)
+		if err != nil {
+			return translateTableError("catalog_items", err)
+		}
+
+		if created, err = s.getCatalogItem(ctx, tx, item.SKU, " FOR UPDATE"); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, catalogAuditEntry(ctx, AuditCatalogCreate, nil, &created))
+	})
+	if err != nil {
+		return err
+	}
+	*item = created
+	return nil
+}
+
+func (s *mysqlStore) GetCatalogItem(ctx context.Context, sku string) (CatalogItem, error) {
+	return s.getCatalogItem(ctx, s.db, sku, "")
+}
+
+func (s *mysqlStore) ListCatalogItems(ctx context.Context, filter CatalogFilter) ([]CatalogItem, error) {
+	var conds []string
+	var args []interface{}
+	if filter.Category != "" {
+		conds, args = append(conds, "category = ?"), append(args, filter.Category)
+	}
+	if !filter.IncludeInactive {
+		conds = append(conds, "active")
+	}
+	query := "SELECT " + catalogColumns + " FROM catalog_items"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+	query += " ORDER BY sku"
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CatalogItem{}
+	for rows.Next() {
+		item, err := scanCatalogItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func (s *mysqlStore) UpdateCatalogItem(ctx context.Context, item CatalogItem) (CatalogItem, error) {
+	var after CatalogItem
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := s.getCatalogItem(ctx, tx, item.SKU, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE catalog_items SET name = ?, category = ?, list_price_minor = ?, currency = ?,
+			active = ? WHERE sku = ?`, item.Name, item.Category, item.ListPrice.Minor, item.ListPrice.Currency, item.Active, item.SKU)
+		if err != nil {
+			return err
+		}
+		if after, err = s.getCatalogItem(ctx, tx, item.SKU, " FOR UPDATE"); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, catalogAuditEntry(ctx, AuditCatalogUpdate, &before, &after))
+	})
+	if err != nil {
+		return CatalogItem{}, err
+	}
+	return after, nil
+}
+
+// DeleteCatalogItem relies on the products.sku and order_lines.sku foreign
+// keys, which also see soft-deleted rows, to refuse items that have been
+// bought or ordered.
+func (s *mysqlStore) DeleteCatalogItem(ctx context.Context, sku string) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		item, err := s.getCatalogItem(ctx, tx, sku, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM catalog_items WHERE sku = ?", sku); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, catalogAuditEntry(ctx, AuditCatalogDelete, &item, nil))
+	})
+}
+
+// LinkProductsToCatalog runs in one transaction, including soft-deleted
+// products so a restored customer comes back linked too. Each linked product
+// is audited as a product.update.
+func (s *mysqlStore) LinkProductsToCatalog(ctx context.Context, dryRun bool) (CatalogLinkReport, error) {
+	var report CatalogLinkReport
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		report = CatalogLinkReport{DryRun: dryRun}
+		rows, err := tx.QueryContext(ctx, "SELECT "+catalogColumns+" FROM catalog_items LOCK IN SHARE MODE")
+		if err != nil {
+			return err
+		}
+		var items []CatalogItem
+		for rows.Next() {
+			item, err := scanCatalogItem(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			items = append(items, item)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		index := catalogNameIndex(items)
+
+		rows, err = tx.QueryContext(ctx, "SELECT "+productColumns+" FROM products WHERE sku IS NULL ORDER BY product_id FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		var unlinked []Product
+		for rows.Next() {
+			product, err := scanProduct(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			unlinked = append(unlinked, product)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for i := range unlinked {
+			sku := report.link(index, unlinked[i].ProductName)
+			if sku == "" || dryRun {
+				continue
+			}
+			if _, err := tx.ExecContext(ctx, "UPDATE products SET sku = ? WHERE product_id = ?", sku, unlinked[i].ProductID); err != nil {
+				return err
+			}
+			linked := unlinked[i]
+			linked.SKU = &sku
+			if err := s.audit(ctx, tx, productAuditEntry(ctx, AuditProductUpdate, &unlinked[i], &linked)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return CatalogLinkReport{}, err
+	}
+	return report, nil
+}
+
+// MigrateMoney fills in the minor-unit price columns of products and
+// catalog items from the DOUBLE columns they replace, batchSize rows per
+// transaction. Converted rows are skipped, so a failed run can be repeated,
+// and so are tables whose DOUBLE column has already been dropped. Prices are
+// what customers paid rather than edits, so nothing is audited; the report
+// lists every row that rounding changed instead.
+func (s *mysqlStore) MigrateMoney(ctx context.Context, currency string, batchSize int, dryRun bool) (MoneyMigrationReport, error) {
+	report := MoneyMigrationReport{DryRun: dryRun, Currency: currency, Changed: []MoneyChange{}}
+	for _, t := range []struct{ table, key, from, to string }{
+		{"products", "product_id", "price", "price_minor"},
+		{"catalog_items", "sku", "list_price", "list_price_minor"},
+	} {
+		var exists bool
+		err := s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM information_schema.columns
+			WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?)`, t.table, t.from).Scan(&exists)
+		if err != nil {
+			return report, err
+		}
+		if !exists {
+			continue
+		}
+
+		// Walk by key rather than by "still NULL" so dry runs terminate.
+		after := ""
+		for {
+			var converted int
+			var changed []MoneyChange
+			err := s.inTx(ctx, func(tx *sql.Tx) error {
+				converted, changed = 0, nil
+				query := fmt.Sprintf("SELECT %s, %s FROM %s WHERE %s IS NULL AND %s > ? ORDER BY %s LIMIT ? FOR UPDATE",
+					t.key, t.from, t.table, t.to, t.key, t.key)
+				rows, err := tx.QueryContext(ctx, query, after, batchSize)
+				if err != nil {
+					return err
+				}
+				type legacyPrice struct {
+					key   string
+					price sql.NullFloat64
+				}
+				var batch []legacyPrice
+				for rows.Next() {
+					var row legacyPrice
+					if err := rows.Scan(&row.key, &row.price); err != nil {
+						rows.Close()
+						return err
+					}
+					batch = append(batch, row)
+				}
+				rows.Close()
+				if err := rows.Err(); err != nil {
+					return err
+				}
+
+				update := fmt.Sprintf("UPDATE %s SET %s = ?, currency = ? WHERE %s = ?", t.table, t.to, t.key)
+				for _, row := range batch {
+					m, exact, err := moneyFromFloat(row.price.Float64, currency)
+					if err != nil {
+						return fmt.Errorf("%s %s: %w", t.table, row.key, err)
+					}
+					if !exact || !row.price.Valid {
+						change := MoneyChange{Table: t.table, Key: row.key, After: m}
+						if row.price.Valid {
+							change.Before = &row.price.Float64
+						}
+						changed = append(changed, change)
+					}
+					if !dryRun {
+						if _, err := tx.ExecContext(ctx, update, m.Minor, m.Currency, row.key); err != nil {
+							return fmt.Errorf("%s %s: %w", t.table, row.key, err)
+						}
+					}
+					after = row.key
+				}
+				converted = len(batch)
+				return nil
+			})
+			if err != nil {
+				return report, err
+			}
+			report.Converted += converted
+			report.Changed = append(report.Changed, changed...)
+			if converted < batchSize {
+				break
+			}
+		}
+	}
+	return report, nil
+}
+
+// CreateOrder share-locks the customer row, so the customer cannot be deleted
+// before the order commits, and each catalog item, so its price cannot change.
+func (s *mysqlStore) CreateOrder(ctx context.Context, order *Order) error {
+	created := cloneOrder(*order)
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		var one int
+		err := tx.QueryRowContext(ctx, "SELECT 1 FROM customers WHERE customer_id = ? AND deleted_at IS NULL LOCK IN SHARE MODE",
+			order.CustomerID).Scan(&one)
+		if err == sql.ErrNoRows {
+			return ErrCustomerNotFound
+		} else if err != nil {
+			return err
+		}
+
+		err = priceOrder(&created, func(sku string) (CatalogItem, error) {
+			return s.getCatalogItem(ctx, tx, sku, " LOCK IN SHARE MODE")
+		})
+		if err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx, "INSERT INTO orders (customer_id, status, total_minor, currency) VALUES (?, ?, ?, ?)",
+			created.CustomerID, created.Status, created.Total.Minor, created.Total.Currency)
+		if err != nil {
+			return err
+		}
+		if created.OrderID, err = result.LastInsertId(); err != nil {
+			return err
+		}
+		for _, line := range created.Lines {
+			_, err := tx.ExecContext(ctx, `INSERT INTO order_lines
+				(order_id, line_no, sku, product_name, quantity, unit_price_minor, line_total_minor)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`, created.OrderID, line.LineNo, line.SKU, line.ProductName, line.Quantity,
+				line.UnitPrice.Minor, line.LineTotal.Minor)
+			if err != nil {
+				return err
+			}
+		}
+
+		stored, err := s.getOrder(ctx, tx, created.CustomerID, created.OrderID, "")
+		if err != nil {
+			return err
+		}
+		created = stored
+		return s.audit(ctx, tx, orderAuditEntry(ctx, AuditOrderCreate, nil, &created))
+	})
+	if err != nil {
+		return err
+	}
+	*order = created
+	return nil
+}
+
+func scanOrder(row rowScanner) (Order, error) {
+	var order Order
+	err := row.Scan(&order.OrderID, &order.CustomerID, &order.Status, &order.Total.Minor, &order.Total.Currency,
+		&order.CreatedAt, &order.UpdatedAt)
+	return order, err
+}
+
+// loadOrderLines fills in the lines of orders, which all belong to one
+// customer.
+func (s *mysqlStore) loadOrderLines(ctx context.Context, q queryer, orders []Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+	byID := make(map[int64]*Order, len(orders))
+	placeholders := make([]string, len(orders))
+	args := make([]interface{}, len(orders))
+	for i := range orders {
+		orders[i].Lines = []OrderLine{}
+		byID[orders[i].OrderID] = &orders[i]
+		placeholders[i], args[i] = "?", orders[i].OrderID
+	}
+
+	rows, err := q.QueryContext(ctx, `SELECT order_id, line_no, sku, product_name, quantity, unit_price_minor, line_total_minor
+		FROM order_lines WHERE order_id IN (`+strings.Join(placeholders, ", ")+`) ORDER BY order_id, line_no`, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var orderID int64
+		var line OrderLine
+		if err := rows.Scan(&orderID, &line.LineNo, &line.SKU, &line.ProductName, &line.Quantity,
+			&line.UnitPrice.Minor, &line.LineTotal.Minor); err != nil {
+			return err
+		}
+		order := byID[orderID]
+		line.UnitPrice.Currency, line.LineTotal.Currency = order.Total.Currency, order.Total.Currency
+		order.Lines = append(order.Lines, line)
+	}
+	return rows.Err()
+}
+
+// getOrder reads one live order of the customer with its lines; lock is
+// appended to the orders query, e.g. " FOR UPDATE".
+func (s *mysqlStore) getOrder(ctx context.Context, q queryer, customerID, orderID int64, lock string) (Order, error) {
+	order, err := scanOrder(q.QueryRowContext(ctx, "SELECT "+orderColumns+
+		" FROM orders WHERE customer_id = ? AND order_id = ? AND deleted_at IS NULL"+lock, customerID, orderID))
+	if err == sql.ErrNoRows {
+		return Order{}, ErrOrderNotFound
+	} else if err != nil {
+		return Order{}, err
+	}
+	orders := []Order{order}
+	if err := s.loadOrderLines(ctx, q, orders); err != nil {
+		return Order{}, err
+	}
+	return orders[0], nil
+}
+
+func (s *mysqlStore) listOrders(ctx context.Context, q queryer, customerID int64, status string) ([]Order, error) {
+	query := "SELECT " + orderColumns + " FROM orders WHERE customer_id = ? AND deleted_at IS NULL"
+	args := []interface{}{customerID}
+	if status != "" {
+		query, args = query+" AND status = ?", append(args, status)
+	}
+	rows, err := q.QueryContext(ctx, query+" ORDER BY order_id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	orders := []Order{}
+	for rows.Next() {
+		order, err := scanOrder(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		orders = append(orders, order)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return orders, s.loadOrderLines(ctx, q, orders)
+}
+
+func (s *mysqlStore) ListOrders(ctx context.Context, customerID int64, status string) ([]Order, error) {
+	return s.listOrders(ctx, s.db, customerID, status)
+}
+
+func (s *mysqlStore) GetOrder(ctx context.Context, customerID, orderID int64) (Order, error) {
+	return s.getOrder(ctx, s.db, customerID, orderID, "")
+}
+
+func (s *mysqlStore) TransitionOrder(ctx context.Context, customerID, orderID int64, status string) (Order, error) {
+	var after Order
+	err := s.inTx(ctx, func(tx *sql.Tx) error {
+		before, err := s.getOrder(ctx, tx, customerID, orderID, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if err := checkOrderTransition(before.Status, status); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE orders SET status = ? WHERE order_id = ?", status, orderID); err != nil {
+			return err
+		}
+		if after, err = s.getOrder(ctx, tx, customerID, orderID, ""); err != nil {
+			return err
+		}
+		return s.audit(ctx, tx, orderAuditEntry(ctx, AuditOrderStatus, &before, &after))
+	})
+	if err != nil {
+		return Order{}, err
+	}
+	return after, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// --- Customer Summary ---
+
+// summaryTopProducts is how many products the summary ranks.
+const summaryTopProducts = 5
+
+// ProductSummary aggregates a customer's live products. Spend is quantity ×
+// price summed per currency, like the totals of the product list. Purchase
+// dates only cover products whose purchase time is known.
+type ProductSummary struct {
+	ProductCount    int          `json:"product_count"`
+	TotalQuantity   int64        `json:"total_quantity"`
+	TotalSpend      []Money      `json:"total_spend"`
+	FirstPurchaseAt *time.Time   `json:"first_purchase_at"`
+	LastPurchaseAt  *time.Time   `json:"last_purchase_at"`
+	TopProducts     []TopProduct `json:"top_products"`
+}
+
+// TopProduct is one product of the summary ranking: the purchases of one
+// catalog item, or of one free-text product name (ignoring case), in one
+// currency. Products are ranked by quantity, then by name.
+type TopProduct struct {
+	SKU         *string `json:"sku,omitempty"`
+	ProductName string  `json:"product_name"`
+	Quantity    int64   `json:"quantity"`
+	Spend       Money   `json:"spend"`
+}
+
+// summarizeProducts computes in Go what mysqlStore.SummarizeProducts
+// computes in SQL.
+func summarizeProducts(products []Product, top int) (ProductSummary, error) {
+	summary := ProductSummary{ProductCount: len(products), TopProducts: []TopProduct{}}
+	var err error
+	if summary.TotalSpend, err = productTotals(products); err != nil {
+		return ProductSummary{}, err
+	}
+
+	groups := make(map[string]*TopProduct)
+	for _, p := range products {
+		summary.TotalQuantity += int64(p.Quantity)
+		if at := p.CreatedAt; at != nil {
+			if summary.FirstPurchaseAt == nil || at.Before(*summary.FirstPurchaseAt) {
+				summary.FirstPurchaseAt = at
+			}
+			if summary.LastPurchaseAt == nil || at.After(*summary.LastPurchaseAt) {
+				summary.LastPurchaseAt = at
+			}
+		}
+
+		key := "name:" + strings.ToLower(p.ProductName)
+		if p.SKU != nil {
+			key = "sku:" + *p.SKU
+		}
+		key += "/" + p.Price.Currency
+		group, ok := groups[key]
+		if !ok {
+			group = &TopProduct{SKU: copyString(p.SKU), ProductName: p.ProductName, Spend: Money{Currency: p.Price.Currency}}
+			groups[key] = group
+		}
+		if p.ProductName < group.ProductName {
+			group.ProductName = p.ProductName
+		}
+		line, err := p.Price.Times(p.Quantity)
+		if err == nil {
+			group.Spend, err = group.Spend.Plus(line)
+		}
+		if err != nil {
+			return ProductSummary{}, fmt.Errorf("product %d: %w", p.ProductID, err)
+		}
+		group.Quantity += int64(p.Quantity)
+	}
+
+	for _, group := range groups {
+		summary.TopProducts = append(summary.TopProducts, *group)
+	}
+	sort.Slice(summary.TopProducts, func(i, j int) bool {
+		a, b := summary.TopProducts[i], summary.TopProducts[j]
+		if a.Quantity != b.Quantity {
+			return a.Quantity > b.Quantity
+		}
+		if a.ProductName != b.ProductName {
+			return a.ProductName < b.ProductName
+		}
+		return a.Spend.Currency < b.Spend.Currency
+	})
+	if len(summary.TopProducts) > top {
+		summary.TopProducts = summary.TopProducts[:top]
+	}
+	return summary, nil
+}
+
+// summaryCacheKey holds the customer's ProductSummary as JSON. Like the
+// product list it is unsealed, and invalidateProducts drops it.
+func summaryCacheKey(customerID int64) string {
+	return fmt.Sprintf("summary:%d", customerID)
+}
+
+// productSummary summarizes the customer's products through the cache.
+func (s *server) productSummary(w http.ResponseWriter, r *http.Request, customerID int64) (ProductSummary, error) {
+	key := summaryCacheKey(customerID)
+	status := "BYPASS"
+	if !cacheBypassed(r) {
+		status = "MISS"
+		if cached, err := s.cache.Get(key); err == nil {
+			var summary ProductSummary
+			if json.Unmarshal(cached, &summary) == nil && summary.decodedExactly() {
+				w.Header().Set("X-Cache", "HIT")
+				return summary, nil
+			}
+		}
+	}
+
+	summary, err := s.store.SummarizeProducts(r.Context(), customerID, summaryTopProducts)
+	if err != nil {
+		return ProductSummary{}, err
+	}
+	if data, err := json.Marshal(summary); err == nil {
+		s.cache.Set(key, data, s.cacheTTL)
+	}
+	w.Header().Set("X-Cache", status)
+	return summary, nil
+}
+
+// decodedExactly reports whether every amount read back from the cache is
+// valid. Spend may exceed the bound Money puts on a single price, and such a
+// summary is treated as a miss rather than shown with a wrong amount.
+func (summary ProductSummary) decodedExactly() bool {
+	for _, m := range summary.TotalSpend {
+		if m.invalid != nil {
+			return false
+		}
+	}
+	for _, p := range summary.TopProducts {
+		if p.Spend.invalid != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// getCustomerSummary handles GET /api/customers/{customer_id}/summary: the
+// customer, masked like any other read, with its ProductSummary. Both come
+// through the cache; X-Cache reports on the summary.
+func (s *server) getCustomerSummary(w http.ResponseWriter, r *http.Request) {
+	customerID, err := parseCustomerID(mux.Vars(r)["customer_id"])
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+	shape, err := newResponseShape(r)
+	if err != nil {
+		respondWithError(w, http.StatusBadRequest, ProblemInvalidParameter, err.Error())
+		return
+	}
+
+	customer, err := s.lookupCustomer(r.Context(), LookupCustomerID, strconv.FormatInt(customerID, 10))
+	if errors.Is(err, ErrCustomerNotFound) {
+		respondWithError(w, http.StatusNotFound, ProblemNotFound, "Customer not found")
+		return
+	} else if err != nil {
+		respondWithStoreError(w, "Failed to retrieve customer", err)
+		return
+	}
+
+	summary, err := s.productSummary(w, r, customerID)
+	if err != nil {
+		respondWithStoreError(w, "Failed to summarize products", err)
+		return
+	}
+	respondWithJSON(w, http.StatusOK, SuccessResponse{
+		Customer: shape.view(customer),
+		Summary:  &summary,
+	})
+}
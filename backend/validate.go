@@ -0,0 +1,361 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// --- Customer Validation ---
+
+// Validation error codes reported in FieldError.Code.
+const (
+	CodeRequired = "required"
+	CodeTooLong  = "too_long"
+	CodeRange    = "out_of_range"
+	CodeFormat   = "invalid_format"
+	CodeChecksum = "invalid_checksum"
+	CodeReadOnly = "read_only"
+	// The referenced catalog item does not exist or is no longer sold.
+	CodeUnknown  = "unknown"
+	CodeInactive = "inactive"
+	// The order lines are not all in the same currency.
+	CodeMismatch = "mismatch"
+	// Reported from database constraints rather than validateCustomer.
+	CodeDuplicate  = "duplicate"
+	CodeConstraint = "constraint"
+)
+
+// FieldError describes one failing field. Field is the JSON name, or
+// "id_documents" for the rule spanning the three document fields.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every field that failed validation.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	parts := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		parts[i] = f.Field + ": " + f.Message
+	}
+	return "Validation failed: " + strings.Join(parts, "; ")
+}
+
+// orNil returns e as an error only if it holds failures, so callers never
+// get a non-nil error wrapping an empty list.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e *ValidationError) add(field, code, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+}
+
+var (
+	// Indian passports: a series letter (Q, X and Z are not issued) and seven
+	// digits, the first non-zero.
+	passportPattern = regexp.MustCompile(`^[A-PR-WY][1-9][0-9]{6}$`)
+	// Indian driving licences: state code, two-digit RTO code, year of
+	// issue and a seven-digit serial, e.g. MH1220110012345.
+	drivingLicensePattern = regexp.MustCompile(`^([A-Z]{2})([0-9]{2})((?:19|20)[0-9]{2})([0-9]{7})$`)
+	// E.164: "+", country code and subscriber number, at most 15 digits.
+	e164Pattern = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	// SKUs: upper-case letters and digits, with inner dots, dashes and
+	// underscores, e.g. LAP-14-PRO.
+	skuPattern = regexp.MustCompile(`^[A-Z0-9][A-Z0-9._-]{0,63}$`)
+)
+
+// indianStateCodes are the registration codes used as driving licence
+// prefixes.
+var indianStateCodes = map[string]bool{
+	"AN": true, "AP": true, "AR": true, "AS": true, "BR": true, "CG": true, "CH": true, "DD": true,
+	"DL": true, "DN": true, "GA": true, "GJ": true, "HP": true, "HR": true, "JH": true, "JK": true,
+	"KA": true, "KL": true, "LA": true, "LD": true, "MH": true, "ML": true, "MN": true, "MP": true,
+	"MZ": true, "NL": true, "OD": true, "OR": true, "PB": true, "PY": true, "RJ": true, "SK": true,
+	"TN": true, "TR": true, "TS": true, "UK": true, "UP": true, "WB": true,
+}
+
+// Verhoeff checksum tables (dihedral group D5).
+var (
+	verhoeffD = [10][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 2, 3, 4, 0, 6, 7, 8, 9, 5},
+		{2, 3, 4, 0, 1, 7, 8, 9, 5, 6},
+		{3, 4, 0, 1, 2, 8, 9, 5, 6, 7},
+		{4, 0, 1, 2, 3, 9, 5, 6, 7, 8},
+		{5, 9, 8, 7, 6, 0, 4, 3, 2, 1},
+		{6, 5, 9, 8, 7, 1, 0, 4, 3, 2},
+		{7, 6, 5, 9, 8, 2, 1, 0, 4, 3},
+		{8, 7, 6, 5, 9, 3, 2, 1, 0, 4},
+		{9, 8, 7, 6, 5, 4, 3, 2, 1, 0},
+	}
+	verhoeffP = [8][10]int{
+		{0, 1, 2, 3, 4, 5, 6, 7, 8, 9},
+		{1, 5, 7, 6, 2, 8, 3, 0, 9, 4},
+		{5, 8, 0, 3, 7, 9, 6, 1, 4, 2},
+		{8, 9, 1, 6, 0, 4, 3, 5, 2, 7},
+		{9, 4, 5, 3, 1, 2, 6, 8, 7, 0},
+		{4, 2, 8, 6, 5, 7, 3, 9, 0, 1},
+		{2, 7, 9, 3, 8, 0, 6, 4, 1, 5},
+		{7, 0, 4, 6, 9, 1, 3, 2, 5, 8},
+	}
+)
+
+// verhoeffValid reports whether the digit string ends in a correct Verhoeff
+// check digit.
+func verhoeffValid(digits string) bool {
+	c := 0
+	for i := 0; i < len(digits); i++ {
+		c = verhoeffD[c][verhoeffP[i%8][digits[len(digits)-1-i]-'0']]
+	}
+	return c == 0
+}
+
+// stripSeparators removes the spaces, hyphens and dots people type inside
+// document and phone numbers.
+func stripSeparators(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ' ', '-', '.', '(', ')':
+			return -1
+		}
+		return r
+	}, v)
+}
+
+// normalizeDocument puts an ID document into the canonical form it is stored,
+// hashed and looked up in.
+func normalizeDocument(lookupType, value string) string {
+	switch lookupType {
+	case LookupAadhar, LookupPassport, LookupDrivingLicense:
+		return strings.ToUpper(stripSeparators(strings.TrimSpace(value)))
+	}
+	return strings.TrimSpace(value)
+}
+
+func normalizeOptional(v *string, normalize func(string) string) {
+	if v != nil {
+		*v = normalize(*v)
+	}
+}
+
+// validateCustomer normalises c in place (trimmed text, canonical document
+// and phone numbers) and then checks every field, collecting all failures
+// into a *ValidationError.
+func validateCustomer(c *Customer) error {
+	c.Name, c.Address = strings.TrimSpace(c.Name), strings.TrimSpace(c.Address)
+	normalizeOptional(c.Email, strings.TrimSpace)
+	normalizeOptional(c.PhoneNumber, func(v string) string { return stripSeparators(strings.TrimSpace(v)) })
+	for field, doc := range customerDocuments(c) {
+		normalizeOptional(*doc, func(v string) string { return normalizeDocument(field, v) })
+	}
+
+	errs := &ValidationError{}
+	text := func(field, value string, max int) {
+		if value == "" {
+			errs.add(field, CodeRequired, "is required")
+		} else if utf8.RuneCountInString(value) > max {
+			errs.add(field, CodeTooLong, "must be at most %d characters", max)
+		}
+	}
+	text("name", c.Name, 100)
+	if c.Age <= 0 || c.Age > 150 {
+		errs.add("age", CodeRange, "must be between 1 and 150")
+	}
+	text("address", c.Address, 255)
+
+	if c.Email != nil {
+		if addr, err := mail.ParseAddress(*c.Email); err != nil || addr.Address != *c.Email {
+			errs.add("email", CodeFormat, "must be a plain e-mail address such as name@example.com")
+		} else if len(*c.Email) > 100 {
+			errs.add("email", CodeTooLong, "must be at most 100 characters")
+		}
+	}
+	if c.PhoneNumber != nil && !e164Pattern.MatchString(*c.PhoneNumber) {
+		errs.add("phone_number", CodeFormat, "must be in E.164 format, e.g. +919876543210")
+	}
+
+	if c.AadharID == nil && c.PassportID == nil && c.DrivingLicenseID == nil {
+		errs.add("id_documents", CodeRequired, "at least one ID document (Aadhar/Passport/Driving License) is required")
+	}
+	if c.AadharID != nil {
+		switch v := *c.AadharID; {
+		case len(v) != 12 || strings.Trim(v, "0123456789") != "":
+			errs.add("aadhar_id", CodeFormat, "must be 12 digits")
+		case v[0] == '0' || v[0] == '1':
+			errs.add("aadhar_id", CodeFormat, "cannot start with 0 or 1")
+		case !verhoeffValid(v):
+			errs.add("aadhar_id", CodeChecksum, "fails the Verhoeff checksum")
+		}
+	}
+	if c.PassportID != nil && !passportPattern.MatchString(*c.PassportID) {
+		errs.add("passport_id", CodeFormat, "must be a letter followed by 7 digits, e.g. K1234567")
+	}
+	if c.DrivingLicenseID != nil {
+		m := drivingLicensePattern.FindStringSubmatch(*c.DrivingLicenseID)
+		if m == nil {
+			errs.add("driving_license_id", CodeFormat, "must be a state code, 2-digit RTO code, year of issue and 7-digit number, e.g. MH1220110012345")
+		} else if !indianStateCodes[m[1]] {
+			errs.add("driving_license_id", CodeFormat, "starts with unknown state code %q", m[1])
+		}
+	}
+
+	return errs.orNil()
+}
+
+func normalizeSKU(sku string) string {
+	return strings.ToUpper(strings.TrimSpace(sku))
+}
+
+// validateProduct trims the product name and checks every field of a new
+// product. A product bought by SKU takes its name and price from the catalog
+// item, so the request must leave them out.
+func validateProduct(p *Product) error {
+	p.ProductName = strings.TrimSpace(p.ProductName)
+	normalizeOptional(p.SKU, normalizeSKU)
+
+	errs := &ValidationError{}
+	if p.CustomerID <= 0 {
+		errs.add("customer_id", CodeRequired, "is required")
+	} else if checkCustomerIDs && !validCustomerID(p.CustomerID) {
+		errs.add("customer_id", CodeChecksum, "fails the check digit, please check for typos")
+	}
+	if p.SKU != nil {
+		if !skuPattern.MatchString(*p.SKU) {
+			errs.add("sku", CodeFormat, "must be 1 to 64 letters, digits, dots, dashes or underscores")
+		}
+		if p.ProductName != "" {
+			errs.add("product_name", CodeReadOnly, "is taken from the catalog item")
+		}
+		if !p.Price.IsZero() {
+			errs.add("price", CodeReadOnly, "is taken from the catalog item")
+		}
+	} else {
+		if p.ProductName == "" {
+			errs.add("product_name", CodeRequired, "is required")
+		} else if utf8.RuneCountInString(p.ProductName) > 100 {
+			errs.add("product_name", CodeTooLong, "must be at most 100 characters")
+		}
+		validateMoney(errs, "price", p.Price)
+	}
+	if p.Quantity <= 0 {
+		errs.add("quantity", CodeRange, "must be at least 1")
+	} else if p.Quantity > math.MaxInt32 {
+		errs.add("quantity", CodeRange, fmt.Sprintf("must be at most %d", math.MaxInt32))
+	}
+	return errs.orNil()
+}
+
+// validateProductUpdate checks a product changed by PUT, PATCH or a quantity
+// adjustment. Unlike a purchase, the quantity may drop to zero. A product
+// bought by SKU keeps the catalog item's name.
+func validateProductUpdate(p *Product, before Product) error {
+	p.ProductName = strings.TrimSpace(p.ProductName)
+
+	errs := &ValidationError{}
+	if before.SKU != nil && p.ProductName != before.ProductName {
+		errs.add("product_name", CodeReadOnly, "is taken from the catalog item")
+	} else if p.ProductName == "" {
+		errs.add("product_name", CodeRequired, "is required")
+	} else if utf8.RuneCountInString(p.ProductName) > 100 {
+		errs.add("product_name", CodeTooLong, "must be at most 100 characters")
+	}
+	if p.Quantity < 0 {
+		errs.add("quantity", CodeRange, "must be at least 0")
+	} else if p.Quantity > math.MaxInt32 {
+		errs.add("quantity", CodeRange, fmt.Sprintf("must be at most %d", math.MaxInt32))
+	}
+	validateMoney(errs, "price", p.Price)
+	return errs.orNil()
+}
+
+// validateCatalogItem normalises the SKU and text fields of item and checks
+// every field.
+func validateCatalogItem(item *CatalogItem) error {
+	item.SKU = normalizeSKU(item.SKU)
+	item.Name, item.Category = strings.TrimSpace(item.Name), strings.TrimSpace(item.Category)
+
+	errs := &ValidationError{}
+	if item.SKU == "" {
+		errs.add("sku", CodeRequired, "is required")
+	} else if !skuPattern.MatchString(item.SKU) {
+		errs.add("sku", CodeFormat, "must be 1 to 64 letters, digits, dots, dashes or underscores")
+	}
+	if item.Name == "" {
+		errs.add("name", CodeRequired, "is required")
+	} else if utf8.RuneCountInString(item.Name) > 100 {
+		errs.add("name", CodeTooLong, "must be at most 100 characters")
+	}
+	if item.Category == "" {
+		errs.add("category", CodeRequired, "is required")
+	} else if utf8.RuneCountInString(item.Category) > 50 {
+		errs.add("category", CodeTooLong, "must be at most 50 characters")
+	}
+	validateMoney(errs, "list_price", item.ListPrice)
+	return errs.orNil()
+}
+
+// validateOrder normalises the SKUs and names of a new order's lines and
+// checks every field. Like products, a line with a SKU takes its name and
+// price from the catalog item.
+func validateOrder(o *Order) error {
+	errs := &ValidationError{}
+	if o.CustomerID <= 0 {
+		errs.add("customer_id", CodeRequired, "is required")
+	} else if checkCustomerIDs && !validCustomerID(o.CustomerID) {
+		errs.add("customer_id", CodeChecksum, "fails the check digit, please check for typos")
+	}
+	switch o.Status {
+	case "":
+		o.Status = OrderDraft
+	case OrderDraft, OrderPlaced:
+	default:
+		errs.add("status", CodeFormat, "must be draft or placed for a new order")
+	}
+	if len(o.Lines) == 0 {
+		errs.add("lines", CodeRequired, "must list at least one item")
+	} else if len(o.Lines) > maxOrderLines {
+		errs.add("lines", CodeTooLong, "must list at most %d items", maxOrderLines)
+	}
+
+	for i := range o.Lines {
+		line := &o.Lines[i]
+		field := func(name string) string { return fmt.Sprintf("lines[%d].%s", i, name) }
+		line.ProductName = strings.TrimSpace(line.ProductName)
+		normalizeOptional(line.SKU, normalizeSKU)
+		if line.SKU != nil {
+			if !skuPattern.MatchString(*line.SKU) {
+				errs.add(field("sku"), CodeFormat, "must be 1 to 64 letters, digits, dots, dashes or underscores")
+			}
+			if line.ProductName != "" {
+				errs.add(field("product_name"), CodeReadOnly, "is taken from the catalog item")
+			}
+			if !line.UnitPrice.IsZero() {
+				errs.add(field("unit_price"), CodeReadOnly, "is taken from the catalog item")
+			}
+		} else {
+			if line.ProductName == "" {
+				errs.add(field("product_name"), CodeRequired, "is required")
+			} else if utf8.RuneCountInString(line.ProductName) > 100 {
+				errs.add(field("product_name"), CodeTooLong, "must be at most 100 characters")
+			}
+			validateMoney(errs, field("unit_price"), line.UnitPrice)
+		}
+		if line.Quantity <= 0 || line.Quantity > math.MaxInt32 {
+			errs.add(field("quantity"), CodeRange, "must be between 1 and %d", math.MaxInt32)
+		}
+	}
+	return errs.orNil()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerhoeffValid(t *testing.T) {
+	for _, tc := range []struct {
+		digits string
+		want   bool
+	}{
+		{"2363", true}, // the textbook example: 236 has check digit 3
+		{"2364", false},
+		{"0", true},
+		{"234123412346", true},
+		{"499182473427", true},
+		{"999999999999", true},
+		{"234123412345", false},
+	} {
+		if got := verhoeffValid(tc.digits); got != tc.want {
+			t.Errorf("verhoeffValid(%q) = %v, want %v", tc.digits, got, tc.want)
+		}
+	}
+}
+
+// Verhoeff catches every single-digit error and every transposition of
+// adjacent digits, which is why Aadhaar uses it.
+func TestVerhoeffDetectsTyposAndTranspositions(t *testing.T) {
+	const valid = "234123412346"
+	for i := 0; i < len(valid); i++ {
+		for d := byte('0'); d <= '9'; d++ {
+			if d == valid[i] {
+				continue
+			}
+			typo := valid[:i] + string(d) + valid[i+1:]
+			if verhoeffValid(typo) {
+				t.Errorf("typo %s at position %d not detected", typo, i)
+			}
+		}
+		if i+1 < len(valid) && valid[i] != valid[i+1] {
+			swapped := valid[:i] + string(valid[i+1]) + string(valid[i]) + valid[i+2:]
+			if verhoeffValid(swapped) {
+				t.Errorf("transposition %s at position %d not detected", swapped, i)
+			}
+		}
+	}
+}
+
+func TestValidateCustomerAadhar(t *testing.T) {
+	for _, tc := range []struct {
+		aadhar   string
+		wantCode string
+	}{
+		{"2341 2341 2346", ""}, // separators are stripped first
+		{"234123412345", CodeChecksum},
+		{"23412341234", CodeFormat},
+		{"23412341234X", CodeFormat},
+		{"123412341234", CodeFormat},
+	} {
+		aadhar := tc.aadhar
+		c := Customer{Name: "Asha Rao", Age: 30, Address: "12 MG Road", AadharID: &aadhar}
+		err := validateCustomer(&c)
+		var got string
+		var verr *ValidationError
+		if errors.As(err, &verr) {
+			for _, f := range verr.Fields {
+				if f.Field == "aadhar_id" {
+					got = f.Code
+				}
+			}
+		} else if err != nil {
+			t.Fatalf("validateCustomer(%q): %v", tc.aadhar, err)
+		}
+		if got != tc.wantCode {
+			t.Errorf("aadhar %q: code %q, want %q (err %v)", tc.aadhar, got, tc.wantCode, err)
+		}
+	}
+}
@@ -13,10 +13,65 @@ import {
 } from "lucide-react";
 
 const API_BASE_URL = "http://localhost:8080/api";
+// Backend API key (AUTH_API_KEYS on the server). Set in .env.development.
+// It is compiled into the public bundle, so it only ever has the viewer role;
+// writes need the credential the user signs in with.
+const API_KEY = process.env.REACT_APP_API_KEY || "";
+// SESSION_CREDENTIAL_KEY holds the signed-in user's API key or JWT for the
+// lifetime of the browser tab.
+const SESSION_CREDENTIAL_KEY = "customerApiCredential";
 const RATE_LIMIT_KEY = "customerCreationTimestamps";
 const MAX_REQUESTS = 10;
 const TIME_WINDOW = 3600000; // 1 hour in milliseconds
 
+// credentialHeaders sends the signed-in credential if there is one (a JWT
+// as a Bearer token, anything else as an API key), else the viewer key.
+const credentialHeaders = () => {
+  const credential = sessionStorage.getItem(SESSION_CREDENTIAL_KEY);
+  if (credential) {
+    return credential.split(".").length === 3
+      ? { Authorization: `Bearer ${credential}` }
+      : { "X-API-Key": credential };
+  }
+  return API_KEY ? { "X-API-Key": API_KEY } : {};
+};
+
+// apiFetch is fetch with the caller's credentials attached.
+const apiFetch = (url, options = {}) =>
+  fetch(url, {
+    ...options,
+    headers: {
+      ...(options.headers || {}),
+      ...credentialHeaders(),
+    },
+  });
+
+// violationsByField turns the violations of an API problem response into a
+// field -> message map for highlighting form inputs.
+const violationsByField = (violations = []) =>
+  violations.reduce((acc, v) => ({ ...acc, [v.field]: v.message }), {});
+
+// PRODUCT_CURRENCY is the ISO 4217 currency new products are priced in.
+const PRODUCT_CURRENCY = "INR";
+
+// formatMoney renders an API money object, e.g. {amount: "12.50", currency: "INR"}.
+const formatMoney = ({ amount, currency }) =>
+  currency === "INR" ? `₹${amount}` : `${amount} ${currency}`;
+
+// multiplyMoney multiplies a money object by a quantity exactly, working on
+// the amount's digits instead of converting it to a float.
+const multiplyMoney = ({ amount, currency }, quantity) => {
+  const [whole, frac = ""] = amount.split(".");
+  const digits = (BigInt(whole + frac) * BigInt(quantity))
+    .toString()
+    .padStart(frac.length + 1, "0");
+  const cut = digits.length - frac.length;
+  return {
+    amount: frac ? `${digits.slice(0, cut)}.${digits.slice(cut)}` : digits,
+    currency,
+  };
+};
+
 export default function CustomerManagement() {
   const [activeTab, setActiveTab] = useState("create");
   const [formData, setFormData] = useState({
@@ -43,8 +98,17 @@ export default function CustomerManagement() {
   const [remainingRequests, setRemainingRequests] = useState(MAX_REQUESTS);
   const [isEditing, setIsEditing] = useState(false);
   const [editFormData, setEditFormData] = useState({});
+  // Field-level errors from the last create request, keyed by JSON field name.
+  const [fieldErrors, setFieldErrors] = useState({});
   // NEW State for holding all customers
   const [allCustomers, setAllCustomers] = useState([]);
+  // paging.next_cursor of the last page loaded; null once every page is in.
+  const [nextCursor, setNextCursor] = useState(null);
+  // Sign-in with an operator/admin API key or JWT, kept out of the bundle
+  const [signedIn, setSignedIn] = useState(
+    () => !!sessionStorage.getItem(SESSION_CREDENTIAL_KEY)
+  );
+  const [credentialInput, setCredentialInput] = useState("");
 
   useEffect(() => {
     updateRemainingRequests();
@@ -83,8 +147,19 @@ export default function CustomerManagement() {
   const handleInputChange = (e) => {
     const { name, value } = e.target;
     setFormData((prev) => ({ ...prev, [name]: value }));
+    setFieldErrors((prev) => ({ ...prev, [name]: undefined }));
   };
 
+  const inputClass = (field) =>
+    `w-full px-4 py-2 border ${
+      fieldErrors[field] ? "border-red-500" : "border-gray-300"
+    } rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent`;
+
+  const fieldMessage = (field) =>
+    fieldErrors[field] && (
+      <p className="mt-1 text-sm text-red-600">{fieldErrors[field]}</p>
+    );
+
   const handleProductChange = (e) => {
     const { name, value } = e.target;
     setProductData((prev) => ({ ...prev, [name]: value }));
@@ -111,27 +186,37 @@ export default function CustomerManagement() {
 
   // --- API Functions ---
 
-  // NEW: Fetch All Customers Function
-  const fetchAllCustomers = async () => {
+  // Fetch the first page of customers, or with a cursor, append the next one.
+  const fetchAllCustomers = async (cursor = null) => {
     setMessage({ type: "", text: "" });
     setLoading(true);
-    setAllCustomers([]);
+    if (!cursor) {
+      setAllCustomers([]);
+      setNextCursor(null);
+    }
     try {
-      const response = await fetch(`${API_BASE_URL}/customers/all`);
+      const url = cursor
+        ? `${API_BASE_URL}/customers/all?cursor=${encodeURIComponent(cursor)}`
+        : `${API_BASE_URL}/customers/all`;
+      const response = await apiFetch(url);
       const data = await response.json();
 
       if (!response.ok) {
         throw new Error(data.error || "Failed to fetch all customers");
       }
 
-      setAllCustomers(data.customers || []);
+      const page = data.customers || [];
+      setAllCustomers((prev) => (cursor ? [...prev, ...page] : page));
+      setNextCursor((data.paging && data.paging.next_cursor) || null);
       setMessage({
         type: "success",
-        text: `Successfully loaded ${data.customers.length} customers.`,
+        text: `Successfully loaded ${page.length} customers.`,
       });
     } catch (error) {
       setMessage({ type: "error", text: error.message });
-      setAllCustomers([]);
+      if (!cursor) {
+        setAllCustomers([]);
+      }
     } finally {
       setLoading(false);
     }
@@ -140,6 +225,7 @@ export default function CustomerManagement() {
   const handleSubmit = async (e) => {
     e.preventDefault();
     setMessage({ type: "", text: "" });
+    setFieldErrors({});
 
     const rateLimitCheck = checkRateLimit();
     if (!rateLimitCheck.allowed) {
@@ -183,7 +269,7 @@ export default function CustomerManagement() {
         driving_license_id: formData.driving_license_id || null,
       };
 
-      const response = await fetch(`${API_BASE_URL}/customers`, {
+      const response = await apiFetch(`${API_BASE_URL}/customers`, {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify(payload),
@@ -192,6 +278,7 @@ export default function CustomerManagement() {
       const data = await response.json();
 
       if (!response.ok) {
+        setFieldErrors(violationsByField(data.violations));
         throw new Error(data.error || "Failed to create customer");
       }
 
@@ -239,7 +326,7 @@ export default function CustomerManagement() {
 
     setLoading(true);
     try {
-      const response = await fetch(
+      const response = await apiFetch(
         `${API_BASE_URL}/customers/search?type=customer_id&value=${encodeURIComponent(
           searchValue
         )}`
@@ -264,7 +351,7 @@ export default function CustomerManagement() {
 
   const fetchProducts = async (customerId) => {
     try {
-      const response = await fetch(`${API_BASE_URL}/products/${customerId}`);
+      const response = await apiFetch(`${API_BASE_URL}/products/${customerId}`);
       const data = await response.json();
       if (response.ok && data.products) {
         setProducts(data.products);
@@ -305,10 +392,10 @@ export default function CustomerManagement() {
         customer_id: parseInt(productData.customer_id),
         product_name: productData.product_name,
         quantity: parseInt(productData.quantity),
-        price: parseFloat(productData.price),
+        price: { amount: productData.price.trim(), currency: PRODUCT_CURRENCY },
       };
 
-      const response = await fetch(`${API_BASE_URL}/products`, {
+      const response = await apiFetch(`${API_BASE_URL}/products`, {
         method: "POST",
         headers: { "Content-Type": "application/json" },
         body: JSON.stringify(payload),
@@ -365,14 +452,25 @@ export default function CustomerManagement() {
         throw new Error("At least one ID document is required for update.");
       }
 
-      const response = await fetch(`${API_BASE_URL}/customers/${customerId}`, {
+      const response = await apiFetch(`${API_BASE_URL}/customers/${customerId}`, {
         method: "PUT",
-        headers: { "Content-Type": "application/json" },
+        headers: {
+          "Content-Type": "application/json",
+          // Optimistic concurrency: the update fails with 412 if someone
+          // else changed the customer since we loaded it.
+          "If-Match": `"${editFormData.version}"`,
+        },
         body: JSON.stringify(payload),
       });
 
       const data = await response.json();
 
+      if (response.status === 412 && data.customer) {
+        // Show the latest data so the user can re-apply their edit.
+        setSearchResult(data.customer);
+        setEditFormData(toEditForm(data.customer));
+      }
+
       if (!response.ok) {
         throw new Error(data.error || "Failed to update customer");
       }
@@ -400,7 +498,7 @@ export default function CustomerManagement() {
     setMessage({ type: "", text: "" });
     setLoading(true);
     try {
-      const response = await fetch(`${API_BASE_URL}/customers/${customerId}`, {
+      const response = await apiFetch(`${API_BASE_URL}/customers/${customerId}`, {
         method: "DELETE",
       });
 
@@ -438,7 +536,7 @@ export default function CustomerManagement() {
     setMessage({ type: "", text: "" });
     setLoading(true);
     try {
-      const response = await fetch(
+      const response = await apiFetch(
         `${API_BASE_URL}/products/${customerId}/${productId}`,
         {
           method: "DELETE",
@@ -464,6 +562,22 @@ export default function CustomerManagement() {
     }
   };
 
+  const handleSignIn = (e) => {
+    e.preventDefault();
+    const credential = credentialInput.trim();
+    if (!credential) return;
+    sessionStorage.setItem(SESSION_CREDENTIAL_KEY, credential);
+    setCredentialInput("");
+    setSignedIn(true);
+    setMessage({ type: "success", text: "Signed in." });
+  };
+
+  const handleSignOut = () => {
+    sessionStorage.removeItem(SESSION_CREDENTIAL_KEY);
+    setSignedIn(false);
+    setMessage({ type: "success", text: "Signed out; the UI is read-only." });
+  };
+
   const handleFlushAllData = async () => {
     if (
       !window.confirm(
@@ -475,7 +589,7 @@ export default function CustomerManagement() {
     setMessage({ type: "", text: "" });
     setLoading(true);
     try {
-      const response = await fetch(`${API_BASE_URL}/flush`, {
+      const response = await apiFetch(`${API_BASE_URL}/flush`, {
         method: "POST",
       });
 
@@ -495,6 +609,7 @@ export default function CustomerManagement() {
       setIsEditing(false);
       setEditFormData({});
       setAllCustomers([]); // Clear the list on flush
+      setNextCursor(null);
     } catch (error) {
       setMessage({ type: "error", text: error.message });
     } finally {
@@ -502,21 +617,25 @@ export default function CustomerManagement() {
     }
   };
 
+  // Builds the edit form state from a customer returned by the API
+  const toEditForm = (customer) => ({
+    customer_id: customer.customer_id,
+    version: customer.version,
+    name: customer.name,
+    age: customer.age,
+    address: customer.address,
+    // Handle optional fields
+    phone_number: customer.phone_number || "",
+    email: customer.email || "",
+    aadhar_id: customer.aadhar_id || "",
+    passport_id: customer.passport_id || "",
+    driving_license_id: customer.driving_license_id || "",
+  });
+
   const startEdit = () => {
     setIsEditing(true);
     // Populate the edit form state with current search result data
-    setEditFormData({
-      customer_id: searchResult.customer_id,
-      name: searchResult.name,
-      age: searchResult.age,
-      address: searchResult.address,
-      // Handle optional fields
-      phone_number: searchResult.phone_number || "",
-      email: searchResult.email || "",
-      aadhar_id: searchResult.aadhar_id || "",
-      passport_id: searchResult.passport_id || "",
-      driving_license_id: searchResult.driving_license_id || "",
-    });
+    setEditFormData(toEditForm(searchResult));
   };
 
   // --- Render Logic ---
@@ -531,15 +650,43 @@ export default function CustomerManagement() {
               Customer Management System
             </h1>
 
-            <button
-              onClick={handleFlushAllData}
-              disabled={loading}
-              className="flex items-center gap-2 bg-red-500 text-white text-sm py-2 px-4 rounded-lg font-medium hover:bg-red-600 transition-colors disabled:opacity-50"
-              title="Permanently delete ALL customer and product data"
-            >
-              <RotateCcw className="w-4 h-4" />
-              Flush All Data
-            </button>
+            <div className="flex items-center gap-3">
+              {signedIn ? (
+                <button
+                  onClick={handleSignOut}
+                  className="bg-white/20 text-white text-sm py-2 px-4 rounded-lg font-medium hover:bg-white/30 transition-colors"
+                >
+                  Sign out
+                </button>
+              ) : (
+                <form onSubmit={handleSignIn} className="flex items-center gap-2">
+                  <input
+                    type="password"
+                    value={credentialInput}
+                    onChange={(e) => setCredentialInput(e.target.value)}
+                    placeholder="API key or token"
+                    autoComplete="off"
+                    className="px-3 py-2 rounded-lg text-sm"
+                    title="Sign in with an operator or admin API key or JWT to make changes"
+                  />
+                  <button
+                    type="submit"
+                    className="bg-white/20 text-white text-sm py-2 px-4 rounded-lg font-medium hover:bg-white/30 transition-colors"
+                  >
+                    Sign in
+                  </button>
+                </form>
+              )}
+              <button
+                onClick={handleFlushAllData}
+                disabled={loading}
+                className="flex items-center gap-2 bg-red-500 text-white text-sm py-2 px-4 rounded-lg font-medium hover:bg-red-600 transition-colors disabled:opacity-50"
+                title="Permanently delete ALL customer and product data"
+              >
+                <RotateCcw className="w-4 h-4" />
+                Flush All Data
+              </button>
+            </div>
           </div>
 
           <div className="flex border-b">
@@ -629,8 +776,9 @@ export default function CustomerManagement() {
                         name="name"
                         value={formData.name}
                         onChange={handleInputChange}
-                        className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                        className={inputClass("name")}
                       />
+                      {fieldMessage("name")}
                     </div>
 
                     <div>
@@ -642,9 +790,10 @@ export default function CustomerManagement() {
                         name="age"
                         value={formData.age}
                         onChange={handleInputChange}
-                        className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                        className={inputClass("age")}
                         min="1"
                       />
+                      {fieldMessage("age")}
                     </div>
                   </div>
 
@@ -657,8 +806,9 @@ export default function CustomerManagement() {
                       value={formData.address}
                       onChange={handleInputChange}
                       rows={3}
-                      className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                      className={inputClass("address")}
                     />
+                    {fieldMessage("address")}
                   </div>
 
                   <div className="grid grid-cols-1 md:grid-cols-2 gap-6">
@@ -671,9 +821,10 @@ export default function CustomerManagement() {
                         name="phone_number"
                         value={formData.phone_number}
                         onChange={handleInputChange}
-                        className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                        className={inputClass("phone_number")}
                         placeholder="+91 1234567890"
                       />
+                      {fieldMessage("phone_number")}
                     </div>
 
                     <div>
@@ -685,9 +836,10 @@ export default function CustomerManagement() {
                         name="email"
                         value={formData.email}
                         onChange={handleInputChange}
-                        className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                        className={inputClass("email")}
                         placeholder="customer@example.com"
                       />
+                      {fieldMessage("email")}
                     </div>
                   </div>
 
@@ -696,6 +848,7 @@ export default function CustomerManagement() {
                       ID Documents <span className="text-red-500">*</span> (At
                       least one required)
                     </p>
+                    {fieldMessage("id_documents")}
                     <div className="grid grid-cols-1 md:grid-cols-3 gap-6">
                       <div>
                         <label className="block text-sm font-medium text-gray-700 mb-2">
@@ -706,10 +859,11 @@ export default function CustomerManagement() {
                           name="aadhar_id"
                           value={formData.aadhar_id}
                           onChange={handleInputChange}
-                          className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                          className={inputClass("aadhar_id")}
                           placeholder="12 digits"
-                          maxLength={12}
+                          maxLength={14}
                         />
+                        {fieldMessage("aadhar_id")}
                       </div>
 
                       <div>
@@ -721,9 +875,10 @@ export default function CustomerManagement() {
                           name="passport_id"
                           value={formData.passport_id}
                           onChange={handleInputChange}
-                          className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                          className={inputClass("passport_id")}
                           maxLength={50}
                         />
+                        {fieldMessage("passport_id")}
                       </div>
 
                       <div>
@@ -735,9 +890,10 @@ export default function CustomerManagement() {
                           name="driving_license_id"
                           value={formData.driving_license_id}
                           onChange={handleInputChange}
-                          className="w-full px-4 py-2 border border-gray-300 rounded-lg focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+                          className={inputClass("driving_license_id")}
                           maxLength={50}
                         />
+                        {fieldMessage("driving_license_id")}
                       </div>
                     </div>
                   </div>
@@ -1053,12 +1209,11 @@ export default function CustomerManagement() {
                                     {product.quantity}
                                   </td>
                                   <td className="px-6 py-4 whitespace-nowrap text-sm text-gray-900">
-                                    ₹{product.price.toFixed(2)}
+                                    {formatMoney(product.price)}
                                   </td>
                                   <td className="px-6 py-4 whitespace-nowrap text-sm font-semibold text-gray-900">
-                                    ₹
-                                    {(product.quantity * product.price).toFixed(
-                                      2
+                                    {formatMoney(
+                                      multiplyMoney(product.price, product.quantity)
                                     )}
                                   </td>
                                   <td className="px-6 py-4 whitespace-nowrap text-sm font-medium">
@@ -1094,7 +1249,7 @@ export default function CustomerManagement() {
                 <h3 className="text-2xl font-bold text-gray-800 mb-4">
                   All Existing Customers ({allCustomers.length})
                 </h3>
-                {loading ? (
+                {loading && allCustomers.length === 0 ? (
                   <p className="text-blue-600">Loading all customer data...</p>
                 ) : allCustomers.length === 0 ? (
                   <p className="text-gray-500">
@@ -1174,6 +1329,17 @@ export default function CustomerManagement() {
                         ))}
                       </tbody>
                     </table>
+                    {nextCursor && (
+                      <div className="p-4 text-center">
+                        <button
+                          onClick={() => fetchAllCustomers(nextCursor)}
+                          disabled={loading}
+                          className="bg-blue-600 text-white text-sm py-2 px-4 rounded-lg font-medium hover:bg-blue-700 transition-colors disabled:opacity-50"
+                        >
+                          {loading ? "Loading..." : "Load more"}
+                        </button>
+                      </div>
+                    )}
                   </div>
                 )}
               </div>